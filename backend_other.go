@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "github.com/kevpar/hcstool/internal/hcs"
+
+// HCS is only available on Windows, so elsewhere the simulator is used.
+func defaultBackend() hcs.Backend {
+	return hcs.NewSimulator()
+}
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/kevpar/hcstool/internal/hcs"
+
+func defaultBackend() hcs.Backend {
+	return hcs.NewComputeCore()
+}
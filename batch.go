@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// batchRequest is a ModifySettingRequest of a batch file, with its settings
+// left encoded so that they are sent as written.
+type batchRequest struct {
+	ResourcePath string
+	RequestType  string
+	Settings     json.RawMessage
+	GuestRequest json.RawMessage
+}
+
+// batchStep is a request of a batch, and the request that undoes it.
+type batchStep struct {
+	req, undo hcsschema.ModifySettingRequest
+}
+
+// errRollbackIncomplete is returned by runBatch when a request failed and
+// some of the requests before it could not be undone.
+var errRollbackIncomplete = errors.New("rollback incomplete, the system is partly modified")
+
+// memorySizePath is the resource path of a VM's memory size, which HCS
+// reports in the Memory properties.
+const memorySizePath = "VirtualMachine/ComputeTopology/Memory/SizeInMB"
+
+// readBatch reads a JSON array of ModifySettingRequests from path.
+func readBatch(path string) ([]batchRequest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []batchRequest
+	if err := json.Unmarshal(b, &reqs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("%s has no requests", path)
+	}
+	for i := range reqs {
+		r := &reqs[i]
+		typ, ok := map[string]string{
+			"add":    "Add",
+			"remove": "Remove",
+			"update": "Update",
+		}[strings.ToLower(r.RequestType)]
+		if !ok {
+			return nil, fmt.Errorf("request %d: unrecognized request type: %q", i+1, r.RequestType)
+		}
+		r.RequestType = typ
+		if r.ResourcePath == "" {
+			return nil, fmt.Errorf("request %d: no resource path", i+1)
+		}
+		if len(r.GuestRequest) != 0 {
+			return nil, fmt.Errorf("request %d: guest requests cannot be undone, so cannot be batched", i+1)
+		}
+	}
+	return reqs, nil
+}
+
+// planBatch derives the request that undoes each request of a batch, from
+// the state the system will be in when it runs. That state is the tracked
+// configuration of the system, updated by the requests before it. Requests
+// whose undo cannot be derived fail the whole batch before anything is sent.
+func planBatch(state *state, cf *commonFlags, cs *cs, reqs []batchRequest) ([]batchStep, map[string]any, error) {
+	tree, err := configTree(cs)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Without a tracked configuration, only the settings reported by
+	// properties are known. HCS reports the memory size, but no processor
+	// or device settings, so only memory size changes and Adds can be
+	// undone on systems hcstool did not create.
+	known := tree != nil
+	if tree == nil {
+		tree = make(map[string]any)
+	}
+	// The memory a VM has been assigned is only its configured size when
+	// it does not use dynamic memory, so it is the size of last resort.
+	if _, ok := hcs.LookupTree(tree, memorySizePath); !ok {
+		if props, err := queryProperties(state, cf, cs, hcsschema.PTMemory); err == nil && props.Memory != nil {
+			if m := props.Memory.VirtualMachineMemory; m != nil && m.AssignedMemory != 0 {
+				sizeMB := json.Number(fmt.Sprint(m.AssignedMemory * pageSize >> 20))
+				if err := hcs.ApplyModify(tree, memorySizePath, "Update", sizeMB); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	steps := make([]batchStep, len(reqs))
+	for i, r := range reqs {
+		settings, err := hcs.DecodeValue(r.Settings)
+		if err != nil {
+			return nil, nil, fmt.Errorf("request %d: settings: %w", i+1, err)
+		}
+		prior, found := hcs.LookupTree(tree, r.ResourcePath)
+		// Later requests change the tree in place.
+		prior = hcs.CloneTree(prior)
+		if !found && !known && r.RequestType != "Add" {
+			return nil, nil, fmt.Errorf("request %d: cannot undo %s of %s, as its current value is not known: HCS does not report it, and hcstool does not track the configuration of this system", i+1, r.RequestType, r.ResourcePath)
+		}
+		undo := hcsschema.ModifySettingRequest{ResourcePath: r.ResourcePath}
+		switch {
+		case r.RequestType == "Add":
+			// List elements are removed by the settings that added them.
+			undo.RequestType = "Remove"
+			undo.Settings = settings
+		case r.RequestType == "Update" && !found:
+			undo.RequestType = "Remove"
+		case r.RequestType == "Update":
+			undo.RequestType = "Update"
+			undo.Settings = prior
+		case !found:
+			return nil, nil, fmt.Errorf("request %d: %s is not configured, so cannot be removed", i+1, r.ResourcePath)
+		default:
+			undo.RequestType = "Add"
+			undo.Settings = prior
+			if list, ok := prior.([]any); ok && settings != nil {
+				undo.Settings = nil
+				for _, item := range list {
+					if hcs.SameElement(item, settings) {
+						undo.Settings = item
+					}
+				}
+				if undo.Settings == nil {
+					return nil, nil, fmt.Errorf("request %d: %s has no element matching the settings", i+1, r.ResourcePath)
+				}
+			}
+		}
+		steps[i].undo = undo
+		steps[i].req = hcsschema.ModifySettingRequest{
+			ResourcePath: r.ResourcePath,
+			RequestType:  r.RequestType,
+			Settings:     settings,
+		}
+		if err := hcs.ApplyModify(tree, r.ResourcePath, r.RequestType, hcs.CloneTree(settings)); err != nil {
+			return nil, nil, fmt.Errorf("request %d (%s %s): %w", i+1, r.RequestType, r.ResourcePath, err)
+		}
+	}
+	if !known {
+		tree = nil
+	}
+	return steps, tree, nil
+}
+
+// runBatch sends the requests of steps in order. If one fails, the requests
+// already sent are undone in reverse order.
+func runBatch(state *state, cf *commonFlags, cs *cs, steps []batchStep) error {
+	for i, s := range steps {
+		err := modifySystem(state, cf, cs, s.req.RequestType, s.req.ResourcePath, s.req.Settings)
+		if err == nil {
+			fmt.Printf("%d/%d: %s %s\n", i+1, len(steps), s.req.RequestType, s.req.ResourcePath)
+			continue
+		}
+		err = fmt.Errorf("request %d (%s %s): %w", i+1, s.req.RequestType, s.req.ResourcePath, err)
+		var undoErrs []error
+		for j := i - 1; j >= 0; j-- {
+			u := steps[j].undo
+			if uerr := modifySystem(state, cf, cs, u.RequestType, u.ResourcePath, u.Settings); uerr != nil {
+				undoErrs = append(undoErrs, fmt.Errorf("undo of request %d (%s %s): %w", j+1, u.RequestType, u.ResourcePath, uerr))
+				continue
+			}
+			fmt.Printf("undid %d/%d: %s %s\n", j+1, len(steps), u.RequestType, u.ResourcePath)
+		}
+		if len(undoErrs) > 0 {
+			return errors.Join(append([]error{err, errRollbackIncomplete}, undoErrs...)...)
+		}
+		return fmt.Errorf("%w; rolled back %d requests", err, i)
+	}
+	return nil
+}
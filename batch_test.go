@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// newBatchState returns a state with a simulated system created from config.
+// If tracked, hcstool tracks the system's configuration.
+func newBatchState(t *testing.T, config string, tracked bool) (*state, *cs) {
+	t.Helper()
+	state := &state{
+		ctx:       context.Background(),
+		interrupt: &interruptHandler{},
+		hcs:       hcs.NewSimulator(),
+		systems:   make(map[string]*cs),
+	}
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	sys, err := state.hcs.CreateComputeSystem("vm", config, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := op.WaitResult(hcs.Infinite); err != nil {
+		t.Fatal(err)
+	}
+	cs := &cs{sys: sys}
+	if tracked {
+		cs.config = &hcsschema.ComputeSystem{}
+		if err := json.Unmarshal([]byte(config), cs.config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state.systems["vm"] = cs
+	return state, cs
+}
+
+func TestPlanBatch(t *testing.T) {
+	const config = `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024}},"Devices":{
+		"Scsi":{"0":{"Attachments":{"0":{"Type":"VirtualDisk","Path":"a.vhdx"}}}},
+		"Plan9":{"Shares":[{"Name":"a","Path":"C:\\a"},{"Name":"b","Path":"C:\\b"}]}}}}`
+	for _, tc := range []struct {
+		name    string
+		config  string
+		tracked bool
+		reqs    string
+		// undo are the requests that undo each request, as JSON.
+		undo []string
+		// tree is the configuration after the batch, if tracked.
+		tree string
+		err  string
+	}{
+		{
+			name:    "tracked configuration",
+			config:  config,
+			tracked: true,
+			reqs: `[
+				{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":2048},
+				{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/1","RequestType":"Add","Settings":{"Type":"VirtualDisk","Path":"b.vhdx"}},
+				{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/0","RequestType":"remove"},
+				{"ResourcePath":"VirtualMachine/Devices/Plan9/Shares","RequestType":"Remove","Settings":{"Name":"b"}},
+				{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":4096}]`,
+			undo: []string{
+				`{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":1024}`,
+				`{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/1","RequestType":"Remove","Settings":{"Path":"b.vhdx","Type":"VirtualDisk"}}`,
+				`{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/0","RequestType":"Add","Settings":{"Path":"a.vhdx","Type":"VirtualDisk"}}`,
+				`{"ResourcePath":"VirtualMachine/Devices/Plan9/Shares","RequestType":"Add","Settings":{"Name":"b","Path":"C:\\b"}}`,
+				`{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":2048}`,
+			},
+			tree: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":4096}},"Devices":{
+				"Scsi":{"0":{"Attachments":{"1":{"Type":"VirtualDisk","Path":"b.vhdx"}}}},
+				"Plan9":{"Shares":[{"Name":"a","Path":"C:\\a"}]}}}}`,
+		},
+		{
+			name:    "update of an unset value is undone by removing it",
+			config:  config,
+			tracked: true,
+			reqs:    `[{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/EnableHotHint","RequestType":"Update","Settings":true}]`,
+			undo:    []string{`{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/EnableHotHint","RequestType":"Remove"}`},
+			tree: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024,"EnableHotHint":true}},"Devices":{
+				"Scsi":{"0":{"Attachments":{"0":{"Type":"VirtualDisk","Path":"a.vhdx"}}}},
+				"Plan9":{"Shares":[{"Name":"a","Path":"C:\\a"},{"Name":"b","Path":"C:\\b"}]}}}}`,
+		},
+		{
+			name:   "memory size from properties",
+			config: config,
+			reqs:   `[{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":2048}]`,
+			undo:   []string{`{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":1024}`},
+		},
+		{
+			name:    "memory size from properties when the tracked configuration has none",
+			config:  `{"VirtualMachine":{}}`,
+			tracked: true,
+			reqs:    `[{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":2048}]`,
+			undo:    []string{`{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":1024}`},
+			tree:    `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":2048}}}}`,
+		},
+		{
+			name:   "untracked add",
+			config: config,
+			reqs:   `[{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/1","RequestType":"Add","Settings":{"Path":"b.vhdx"}}]`,
+			undo:   []string{`{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/1","RequestType":"Remove","Settings":{"Path":"b.vhdx"}}`},
+		},
+		{
+			name:   "untracked remove",
+			config: config,
+			reqs:   `[{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/0","RequestType":"Remove"}]`,
+			err:    "request 1: cannot undo Remove of VirtualMachine/Devices/Scsi/0/Attachments/0, as its current value is not known",
+		},
+		{
+			name:    "remove of an unset value",
+			config:  config,
+			tracked: true,
+			reqs:    `[{"ResourcePath":"VirtualMachine/Devices/Scsi/1","RequestType":"Remove"}]`,
+			err:     "request 1: VirtualMachine/Devices/Scsi/1 is not configured, so cannot be removed",
+		},
+		{
+			name:    "remove of a missing list element",
+			config:  config,
+			tracked: true,
+			reqs:    `[{"ResourcePath":"VirtualMachine/Devices/Plan9/Shares","RequestType":"Remove","Settings":{"Name":"z"}}]`,
+			err:     "request 1: VirtualMachine/Devices/Plan9/Shares has no element matching the settings",
+		},
+		{
+			name:    "request that fails on the configuration before it",
+			config:  config,
+			tracked: true,
+			reqs: `[
+				{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/1","RequestType":"Add","Settings":{}},
+				{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/1","RequestType":"Add","Settings":{}}]`,
+			err: "request 2 (Add VirtualMachine/Devices/Scsi/0/Attachments/1): ",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "batch.json")
+			if err := os.WriteFile(path, []byte(tc.reqs), 0644); err != nil {
+				t.Fatal(err)
+			}
+			reqs, err := readBatch(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, cs := newBatchState(t, tc.config, tc.tracked)
+			steps, tree, err := planBatch(state, &commonFlags{}, cs, reqs)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("planBatch error = %v, want %s", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(steps) != len(tc.undo) {
+				t.Fatalf("planBatch returned %d steps, want %d", len(steps), len(tc.undo))
+			}
+			for i, s := range steps {
+				if got, _ := json.Marshal(s.undo); string(got) != tc.undo[i] {
+					t.Errorf("undo of request %d = %s\nwant %s", i+1, got, tc.undo[i])
+				}
+			}
+			if tc.tree == "" {
+				if tree != nil {
+					t.Errorf("planBatch returned a configuration for an untracked system")
+				}
+				return
+			}
+			want, err := hcs.DecodeTree([]byte(tc.tree))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(tree)
+			wantJSON, _ := json.Marshal(want)
+			if string(got) != string(wantJSON) {
+				t.Errorf("configuration = %s\nwant %s", got, wantJSON)
+			}
+		})
+	}
+}
+
+// failingSystem fails the Modify calls numbered in fail, counting from 1.
+type failingSystem struct {
+	hcs.System
+	fail  map[int]bool
+	calls int
+}
+
+func (s *failingSystem) Modify(op hcs.Operation, config string) error {
+	s.calls++
+	if s.fail[s.calls] {
+		return hcs.E_INVALIDARG
+	}
+	return s.System.Modify(op, config)
+}
+
+func TestModifyBatch(t *testing.T) {
+	const batch = `[
+		{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":2048},
+		{"ResourcePath":"VirtualMachine/Devices/Scsi/0/Attachments/1","RequestType":"Add","Settings":{"Type":"VirtualDisk","Path":"C:\\b.vhdx"}}]`
+	for _, tc := range []struct {
+		name string
+		fail []int
+		err  string
+		// size is the tracked memory size after the batch, or 0 if the
+		// configuration is no longer tracked.
+		size uint64
+	}{
+		{name: "success", size: 2048},
+		{name: "rolled back", fail: []int{2}, err: "rolled back 1 requests", size: 1024},
+		{name: "rollback incomplete", fail: []int{2, 3}, err: "rollback incomplete"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "batch.json")
+			if err := os.WriteFile(path, []byte(batch), 0644); err != nil {
+				t.Fatal(err)
+			}
+			state, cs := newBatchState(t, `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024}},"Devices":{"Scsi":{"0":{}}}}}`, true)
+			fail := make(map[int]bool)
+			for _, n := range tc.fail {
+				fail[n] = true
+			}
+			cs.sys = &failingSystem{System: cs.sys, fail: fail}
+			c := &modifyCommand{}
+			fs := flag.NewFlagSet("modify", flag.ContinueOnError)
+			c.SetupFlags(fs)
+			if err := fs.Parse([]string{"-cs", "vm", "-batch", path}); err != nil {
+				t.Fatal(err)
+			}
+			err := c.Execute(state, fs)
+			if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("modify -batch = %v, want %q", err, tc.err)
+			}
+			if tc.size == 0 {
+				if cs.config != nil {
+					t.Errorf("the configuration is still tracked after an incomplete rollback")
+				}
+				return
+			}
+			if cs.config == nil {
+				t.Fatal("the configuration is no longer tracked")
+			}
+			if size := cs.config.VirtualMachine.ComputeTopology.Memory.SizeInMB; size != tc.size {
+				t.Errorf("tracked memory size = %d, want %d", size, tc.size)
+			}
+		})
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	for _, tc := range []struct {
+		name, batch, err string
+	}{
+		{"not JSON", `[`, "unexpected end of JSON input"},
+		{"empty", `[]`, "has no requests"},
+		{"unknown request type", `[{"ResourcePath":"a","RequestType":"Replace"}]`, `request 1: unrecognized request type: "Replace"`},
+		{"no resource path", `[{"RequestType":"Add"}]`, "request 1: no resource path"},
+		{"guest request", `[{"ResourcePath":"a","RequestType":"Add"},{"ResourcePath":"a","RequestType":"Add","GuestRequest":{}}]`, "request 2: guest requests cannot be undone"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "batch.json")
+			if err := os.WriteFile(path, []byte(tc.batch), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readBatch(path); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("readBatch error = %v, want %s", err, tc.err)
+			}
+		})
+	}
+}
@@ -1,27 +1,43 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/kevpar/hcstool/internal/computecore"
+	"github.com/kevpar/hcstool/internal/hcs"
 	"github.com/kevpar/hcstool/internal/hcsschema"
 	"github.com/kevpar/repl-go"
-	"golang.org/x/sys/windows"
 )
 
 func allCommands() []repl.Command[*state] {
 	return []repl.Command[*state]{
 		&createCommand{},
+		&newCommand{},
+		&validateCommand{},
+		&lintCommand{},
 		&startCommand{},
 		&closeCommand{},
 		&suspendCommand{},
 		&resumeCommand{},
+		&shutdownCommand{},
+		&terminateCommand{},
+		&crashCommand{},
+		&execCommand{},
+		&psCommand{},
+		&popenCommand{},
+		&pcloseCommand{},
+		&signalCommand{},
+		&killCommand{},
+		&ttySizeCommand{},
+		&closeStdinCommand{},
 		&saveCommand{},
 		&propsCommand{},
 		&grantCommand{},
@@ -30,28 +46,62 @@ func allCommands() []repl.Command[*state] {
 		&openCommand{},
 		&svcPropsCommand{},
 		&modifyCommand{},
+		&guestModifyCommand{},
+		&diskCommand{},
+		&nicCommand{},
+		&shareCommand{},
+		&pmemCommand{},
+		&hvsockCommand{},
+		&vpciCommand{},
+		&resizeCommand{},
+		&cpuGroupCommand{},
+		&topologyCommand{},
 		&lmSourceInitializeCommand{},
 		&lmSourceStartCommand{},
 		&lmTransferCommand{},
 		&lmFinalizeCommand{},
+		&jobsCommand{},
+		&eventsCommand{},
+		&topCommand{},
+		&waitCommand{},
+		&cancelCommand{},
+		&simFailCommand{},
 	}
 }
 
 type state struct {
-	def     string
-	systems map[string]*cs
+	ctx       context.Context
+	interrupt *interruptHandler
+	timeout   time.Duration
+	hcs       hcs.Backend
+	stdin     *stdinRouter
+	def       string
+	systems   map[string]*cs
+	processes map[string]*proc
+	nextProc  int
+	jobs      map[int]*job
+	events    *eventLog
+	nextJob   int
 }
 
 type cs struct {
-	handle computecore.HCS_SYSTEM
+	sys hcs.System
+	// config is the document the system was created with, if known.
+	config *hcsschema.ComputeSystem
+	// bars are the BAR sizes given for the vPCI devices assigned to the
+	// system, by device ID.
+	bars map[string]vpciBARs
 }
 
 func setupCommonFlags(cf *commonFlags, fs *flag.FlagSet) {
 	cf.cs = fs.String("cs", "", "Specifies the compute system to operate on.")
+	setupTimeoutFlag(cf, fs)
 }
 
 type commonFlags struct {
-	cs *string
+	cs      *string
+	timeout *time.Duration
+	bg      *bool
 }
 
 func getCS(state *state, cf *commonFlags) (string, *cs, error) {
@@ -69,13 +119,23 @@ func getCS(state *state, cf *commonFlags) (string, *cs, error) {
 	return key, cs, nil
 }
 
-type createCommand struct{ setDefault *bool }
+type createCommand struct {
+	cf         commonFlags
+	setDefault *bool
+	noValidate *bool
+	noGrant    *bool
+	dryRun     *bool
+}
 
 func (c *createCommand) Name() string        { return "create" }
 func (c *createCommand) Description() string { return "Creates a compute system." }
 func (c *createCommand) ArgHelp() string     { return "ID PATH" }
 func (c *createCommand) SetupFlags(fs *flag.FlagSet) {
+	setupTimeoutFlag(&c.cf, fs)
 	c.setDefault = fs.Bool("def", false, "Set the new compute system as the default.")
+	c.noValidate = fs.Bool("novalidate", false, "Pass the document to HCS without checking it against the schema first.")
+	c.noGrant = fs.Bool("nogrant", false, "Do not grant the VM access to the host files the document references.")
+	c.dryRun = fs.Bool("dry-run", false, "List the host paths that would be granted access to, without creating the system.")
 }
 
 func (c *createCommand) Execute(state *state, fs *flag.FlagSet) error {
@@ -83,21 +143,59 @@ func (c *createCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if _, ok := state.systems[id]; ok {
 		return fmt.Errorf("compute system already open: %s", id)
 	}
-	var cs cs
 	doc, err := os.ReadFile(fs.Arg(1))
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
+	var parsed hcsschema.ComputeSystem
+	parseErr := json.Unmarshal(doc, &parsed)
+	if !*c.noValidate {
+		if err := preflight(doc, documentTypes["ComputeSystem"]); err != nil {
+			return fmt.Errorf("%w (use -novalidate to create anyway)", err)
+		}
+		if parseErr == nil {
+			if err := checkHostPaths(&parsed); err != nil {
+				return fmt.Errorf("%w (use -novalidate to create anyway)", err)
+			}
+		}
+	}
+	if !*c.noGrant || *c.dryRun {
+		if parseErr != nil {
+			return fmt.Errorf("find host paths to grant: %w (use -nogrant to create anyway)", parseErr)
+		}
+		if err := grantHostPaths(state, id, &parsed, *c.dryRun); err != nil {
+			return err
+		}
+		if *c.dryRun {
+			return nil
+		}
+	}
+	return createSystem(state, &c.cf, id, string(doc), *c.setDefault)
+}
+
+// createSystem creates a compute system from doc, and adds it to the open
+// systems.
+func createSystem(state *state, cf *commonFlags, id, doc string, setDefault bool) error {
+	ctx, cancel := opContext(state, cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
 	defer op.Close()
-	if err := computecore.HcsCreateComputeSystem(fs.Arg(0), string(doc), op, nil, &cs.handle); err != nil {
+	sys, err := state.hcs.CreateComputeSystem(id, doc, op)
+	if err != nil {
 		return err
 	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
+	if _, err := hcs.WaitResult(ctx, op); err != nil {
+		sys.Close()
 		return err
 	}
-	state.systems[id] = &cs
-	if *c.setDefault {
+	c := &cs{sys: sys}
+	var config hcsschema.ComputeSystem
+	if json.Unmarshal([]byte(doc), &config) == nil {
+		c.config = &config
+	}
+	state.systems[id] = c
+	watchSystem(state, id, sys)
+	if setDefault {
 		state.def = id
 	}
 	return nil
@@ -113,6 +211,7 @@ func (c *startCommand) Description() string { return "Starts a compute system."
 func (c *startCommand) ArgHelp() string     { return "" }
 func (c *startCommand) SetupFlags(fs *flag.FlagSet) {
 	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
 	c.migsocket = fs.String("migsocket", "", "TCP address to dial for live migration connection.")
 }
 
@@ -130,17 +229,12 @@ func (c *startCommand) Execute(state *state, fs *flag.FlagSet) error {
 		sock = uintptr(s)
 	}
 
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
-	defer op.Close()
 	var optionsRaw []byte
 	if sock != 0 {
-		if err := computecore.HcsAddResourceToOperation(op, computecore.HcsResourceTypeSocket, "hcs:/VirtualMachine/LiveMigrationSocket", sock); err != nil {
-			return err
-		}
 		options := hcsschema.StartOptions{
 			DestinationMigrationOptions: &hcsschema.MigrationStartOptions{
 				NetworkSettings: &hcsschema.MigrationNetworkSettings{
@@ -153,31 +247,15 @@ func (c *startCommand) Execute(state *state, fs *flag.FlagSet) error {
 			return err
 		}
 	}
-	if err := computecore.HcsStartComputeSystem(cs.handle, op, string(optionsRaw)); err != nil {
-		return err
-	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
-		return err
-	}
-	return nil
-}
-
-func dial(addr netip.AddrPort) (_ windows.Handle, err error) {
-	conn, err := windows.Socket(windows.AF_INET, windows.SOCK_STREAM, windows.IPPROTO_TCP)
-	if err != nil {
-		return 0, err
-	}
-	defer func() {
-		if err != nil {
-			windows.Closesocket(conn)
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		if sock != 0 {
+			if err := op.AddResource(hcs.ResourceTypeSocket, "hcs:/VirtualMachine/LiveMigrationSocket", sock); err != nil {
+				return err
+			}
 		}
-	}()
-	fmt.Printf("connecting...\n")
-	if err := windows.Connect(conn, &windows.SockaddrInet4{Port: int(addr.Port()), Addr: addr.Addr().As4()}); err != nil {
-		return 0, err
-	}
-	fmt.Printf("connected\n")
-	return conn, nil
+		return cs.sys.Start(op, string(optionsRaw))
+	})
+	return err
 }
 
 type closeCommand struct{ cf commonFlags }
@@ -192,8 +270,12 @@ func (c *closeCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if err != nil {
 		return err
 	}
-	computecore.HcsCloseComputeSystem(cs.handle)
-	cs.handle = 0
+	for name, p := range state.processes {
+		if p.csID == id {
+			closeProc(state, name)
+		}
+	}
+	cs.sys.Close()
 	delete(state.systems, id)
 	if state.def == id {
 		state.def = ""
@@ -203,59 +285,179 @@ func (c *closeCommand) Execute(state *state, fs *flag.FlagSet) error {
 
 type suspendCommand struct{ cf commonFlags }
 
-func (c *suspendCommand) Name() string                { return "pause" }
-func (c *suspendCommand) Description() string         { return "Pauses a compute system." }
-func (c *suspendCommand) ArgHelp() string             { return "" }
-func (c *suspendCommand) SetupFlags(fs *flag.FlagSet) { setupCommonFlags(&c.cf, fs) }
+func (c *suspendCommand) Name() string        { return "pause" }
+func (c *suspendCommand) Description() string { return "Pauses a compute system." }
+func (c *suspendCommand) ArgHelp() string     { return "" }
+func (c *suspendCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
+}
 
 func (c *suspendCommand) Execute(state *state, fs *flag.FlagSet) error {
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.Pause(op, "")
+	})
+	return err
+}
+
+type resumeCommand struct{ cf commonFlags }
+
+func (c *resumeCommand) Name() string        { return "resume" }
+func (c *resumeCommand) Description() string { return "Resumes a compute system." }
+func (c *resumeCommand) ArgHelp() string     { return "" }
+func (c *resumeCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
+}
+
+func (c *resumeCommand) Execute(state *state, fs *flag.FlagSet) error {
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.Resume(op, "")
+	})
+	return err
+}
+
+type shutdownCommand struct {
+	cf        commonFlags
+	mechanism *string
+	typ       *string
+	force     *bool
+	reason    *string
+	grace     *time.Duration
+}
+
+func (c *shutdownCommand) Name() string        { return "shutdown" }
+func (c *shutdownCommand) Description() string { return "Shuts down a compute system." }
+func (c *shutdownCommand) ArgHelp() string     { return "" }
+func (c *shutdownCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.mechanism = fs.String("mechanism", "", "Shutdown mechanism: GuestConnection or IntegrationService.")
+	c.typ = fs.String("type", "", "Shutdown type: Shutdown, Hibernate, or Reboot.")
+	c.force = fs.Bool("force", false, "Do not allow the guest to cancel the shutdown.")
+	c.reason = fs.String("reason", "", "Reason for the shutdown, reported to the guest.")
+	c.grace = fs.Duration("grace", 30*time.Second, "Time to wait before terminating the compute system instead. 0 waits forever.")
+}
+
+func (c *shutdownCommand) Execute(state *state, fs *flag.FlagSet) error {
 	_, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
+	so := hcsschema.ShutdownOptions{
+		Mechanism: hcsschema.ShutdownMechanism(*c.mechanism),
+		Type:      hcsschema.ShutdownType(*c.typ),
+		Force:     *c.force,
+		Reason:    *c.reason,
+	}
+	switch so.Mechanism {
+	case "", hcsschema.ShutdownMechanismGuestConnection, hcsschema.ShutdownMechanismIntegrationService:
+	default:
+		return fmt.Errorf("unrecognized shutdown mechanism: %s", so.Mechanism)
+	}
+	switch so.Type {
+	case "", hcsschema.ShutdownTypeShutdown, hcsschema.ShutdownTypeHibernate, hcsschema.ShutdownTypeReboot:
+	default:
+		return fmt.Errorf("unrecognized shutdown type: %s", so.Type)
+	}
+	j, err := json.Marshal(so)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
 	defer op.Close()
-	if err := computecore.HcsPauseComputeSystem(cs.handle, op, ""); err != nil {
+	if err := cs.sys.Shutdown(op, string(j)); err != nil {
 		return err
 	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
+	graceCtx := ctx
+	if *c.grace > 0 {
+		var cancelGrace context.CancelFunc
+		graceCtx, cancelGrace = context.WithTimeout(ctx, *c.grace)
+		defer cancelGrace()
+	}
+	_, err = hcs.WaitResult(graceCtx, op)
+	if !errors.Is(err, hcs.ErrTimedOut) || ctx.Err() != nil {
 		return err
 	}
-	return nil
+	fmt.Printf("shutdown did not complete within %s, terminating\n", *c.grace)
+	return terminate(ctx, state, cs)
 }
 
-type resumeCommand struct{ cf commonFlags }
+type terminateCommand struct{ cf commonFlags }
 
-func (c *resumeCommand) Name() string                { return "resume" }
-func (c *resumeCommand) Description() string         { return "Resumes a compute system." }
-func (c *resumeCommand) ArgHelp() string             { return "" }
-func (c *resumeCommand) SetupFlags(fs *flag.FlagSet) { setupCommonFlags(&c.cf, fs) }
+func (c *terminateCommand) Name() string        { return "terminate" }
+func (c *terminateCommand) Description() string { return "Terminates a compute system." }
+func (c *terminateCommand) ArgHelp() string     { return "" }
+func (c *terminateCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
+}
 
-func (c *resumeCommand) Execute(state *state, fs *flag.FlagSet) error {
-	_, cs, err := getCS(state, &c.cf)
+func (c *terminateCommand) Execute(state *state, fs *flag.FlagSet) error {
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.Terminate(op, "")
+	})
+	return err
+}
+
+func terminate(ctx context.Context, state *state, cs *cs) error {
+	op := state.hcs.NewOperation()
 	defer op.Close()
-	if err := computecore.HcsResumeComputeSystem(cs.handle, op, ""); err != nil {
+	if err := cs.sys.Terminate(op, ""); err != nil {
 		return err
 	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
+	if _, err := hcs.WaitResult(ctx, op); err != nil {
 		return err
 	}
 	return nil
 }
 
+type crashCommand struct{ cf commonFlags }
+
+func (c *crashCommand) Name() string        { return "crash" }
+func (c *crashCommand) Description() string { return "Crashes the guest of a compute system." }
+func (c *crashCommand) ArgHelp() string     { return "" }
+func (c *crashCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
+}
+
+func (c *crashCommand) Execute(state *state, fs *flag.FlagSet) error {
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.Crash(op, "")
+	})
+	return err
+}
+
 type saveCommand struct{ cf commonFlags }
 
-func (c *saveCommand) Name() string                { return "save" }
-func (c *saveCommand) Description() string         { return "Saves the compute system to disk." }
-func (c *saveCommand) ArgHelp() string             { return "PATH" }
-func (c *saveCommand) SetupFlags(fs *flag.FlagSet) { setupCommonFlags(&c.cf, fs) }
+func (c *saveCommand) Name() string        { return "save" }
+func (c *saveCommand) Description() string { return "Saves the compute system to disk." }
+func (c *saveCommand) ArgHelp() string     { return "PATH" }
+func (c *saveCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
+}
 
 func (c *saveCommand) Execute(state *state, fs *flag.FlagSet) error {
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
@@ -275,20 +477,17 @@ func (c *saveCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
-	defer op.Close()
-	if err := computecore.HcsSaveComputeSystem(cs.handle, op, string(j)); err != nil {
-		return err
-	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
-		return err
-	}
-	return nil
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.Save(op, string(j))
+	})
+	return err
 }
 
 type propsCommand struct {
 	cf         commonFlags
 	rawQuery   *string
+	types      *string
+	output     *string
 	vmVersion  *bool
 	compatInfo *bool
 	procReqs   *bool
@@ -300,24 +499,37 @@ func (c *propsCommand) ArgHelp() string     { return "" }
 func (c *propsCommand) SetupFlags(fs *flag.FlagSet) {
 	setupCommonFlags(&c.cf, fs)
 	c.rawQuery = fs.String("rawquery", "", "Exact query string to use.")
+	c.types = fs.String("types", "", "Comma separated property types to query as well, such as Memory,Statistics,ProcessList.")
+	c.output = fs.String("o", "text", "Output format: text, or json for the response as returned.")
 	c.vmVersion = fs.Bool("vmversion", false, "Query for VmVersion property as well.")
 	c.compatInfo = fs.Bool("compatinfo", false, "Query for CompatibilityInfo property as well.")
 	c.procReqs = fs.Bool("procreqs", false, "Query for VmProcessorRequirements as well.")
 }
 
 func (c *propsCommand) Execute(state *state, fs *flag.FlagSet) error {
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	output, err := oneOf("o", *c.output, "text", "json")
 	if err != nil {
 		return err
 	}
 	var query string
-	if c.rawQuery != nil {
+	var pq hcsschema.PropertyQuery
+	if *c.rawQuery != "" {
 		query = *c.rawQuery
 	} else {
-		pq := hcsschema.PropertyQuery{
-			Queries: map[string]interface{}{
+		if *c.types != "" {
+			if pq.PropertyTypes, err = parsePropertyTypes(*c.types); err != nil {
+				return err
+			}
+		}
+		// PropertyTypes queries return the basic properties anyway.
+		if pq.PropertyTypes == nil || *c.vmVersion || *c.compatInfo || *c.procReqs {
+			pq.Queries = map[string]interface{}{
 				"Basic": nil,
-			},
+			}
 		}
 		if *c.vmVersion {
 			pq.Queries["VmVersion"] = nil
@@ -334,15 +546,20 @@ func (c *propsCommand) Execute(state *state, fs *flag.FlagSet) error {
 		}
 		query = string(j)
 	}
-	op := computecore.NewOperation(0)
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
 	defer op.Close()
-	if err := computecore.HcsGetComputeSystemProperties(cs.handle, op, query); err != nil {
+	if err := cs.sys.GetProperties(op, query); err != nil {
 		return err
 	}
-	properties, err := op.WaitResult(windows.INFINITE)
+	properties, err := hcs.WaitResult(ctx, op)
 	if err != nil {
 		return err
 	}
+	if output == "text" {
+		return printProperties(properties, pq.PropertyTypes, processNames(state, id))
+	}
 	var results any
 	if err := json.Unmarshal([]byte(properties), &results); err != nil {
 		return err
@@ -371,7 +588,7 @@ func (c *grantCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if err != nil {
 		return err
 	}
-	if err := computecore.HcsGrantVmAccess(fs.Arg(0), path); err != nil {
+	if err := state.hcs.GrantVmAccess(fs.Arg(0), path); err != nil {
 		return err
 	}
 	return nil
@@ -401,23 +618,29 @@ func (c *defaultCommand) Execute(state *state, fs *flag.FlagSet) error {
 	return nil
 }
 
-type listCommand struct{ all *bool }
+type listCommand struct {
+	cf  commonFlags
+	all *bool
+}
 
 func (c *listCommand) Name() string        { return "list" }
 func (c *listCommand) Description() string { return "Lists compute systems." }
 func (c *listCommand) ArgHelp() string     { return "" }
 func (c *listCommand) SetupFlags(fs *flag.FlagSet) {
+	setupTimeoutFlag(&c.cf, fs)
 	c.all = fs.Bool("all", false, "Show all systems instead of only those you have open.")
 }
 
 func (c *listCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if *c.all {
-		op := computecore.NewOperation(0)
+		ctx, cancel := opContext(state, &c.cf)
+		defer cancel()
+		op := state.hcs.NewOperation()
 		defer op.Close()
-		if err := computecore.HcsEnumerateComputeSystems("", op); err != nil {
+		if err := state.hcs.EnumerateComputeSystems("", op); err != nil {
 			return err
 		}
-		systemsRaw, err := op.WaitResult(windows.INFINITE)
+		systemsRaw, err := hcs.WaitResult(ctx, op)
 		if err != nil {
 			return err
 		}
@@ -459,11 +682,12 @@ func (c *openCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if _, ok := state.systems[id]; ok {
 		return fmt.Errorf("compute system already open: %s", id)
 	}
-	var cs cs
-	if err := computecore.HcsOpenComputeSystem(id, windows.GENERIC_ALL, &cs.handle); err != nil {
+	sys, err := state.hcs.OpenComputeSystem(id)
+	if err != nil {
 		return err
 	}
-	state.systems[id] = &cs
+	state.systems[id] = &cs{sys: sys}
+	watchSystem(state, id, sys)
 	return nil
 }
 
@@ -480,7 +704,7 @@ func (c *svcPropsCommand) SetupFlags(fs *flag.FlagSet) {
 
 func (c *svcPropsCommand) Execute(state *state, fs *flag.FlagSet) error {
 	var query string
-	if c.rawQuery != nil {
+	if *c.rawQuery != "" {
 		query = *c.rawQuery
 	} else {
 		pq := struct {
@@ -497,11 +721,11 @@ func (c *svcPropsCommand) Execute(state *state, fs *flag.FlagSet) error {
 		}
 		query = string(j)
 	}
-	var properties *uint16
-	if err := computecore.HcsGetServiceProperties(query, &properties); err != nil {
+	properties, err := state.hcs.GetServiceProperties(query)
+	if err != nil {
 		return err
 	}
-	j, err := json.MarshalIndent(json.RawMessage(windows.UTF16PtrToString(properties)), "\t", "\t")
+	j, err := json.MarshalIndent(json.RawMessage(properties), "\t", "\t")
 	if err != nil {
 		return err
 	}
@@ -509,20 +733,32 @@ func (c *svcPropsCommand) Execute(state *state, fs *flag.FlagSet) error {
 	return nil
 }
 
-type modifyCommand struct{ cf commonFlags }
+type modifyCommand struct {
+	cf     commonFlags
+	batch  *string
+	dryRun *bool
+}
 
 func (c *modifyCommand) Name() string        { return "modify" }
 func (c *modifyCommand) Description() string { return "Modifies a compute system." }
-func (c *modifyCommand) ArgHelp() string     { return "add|remove|update PATH SETTINGS" }
+func (c *modifyCommand) ArgHelp() string {
+	return "add|remove|update PATH SETTINGS, or -batch FILE"
+}
 func (c *modifyCommand) SetupFlags(fs *flag.FlagSet) {
 	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
+	c.batch = fs.String("batch", "", "Apply the JSON array of ModifySettingRequests in FILE in order, undoing them all if one fails. Undo requests come from the tracked configuration; on systems hcstool did not create, only Adds and memory size Updates can be undone, as HCS reports no other settings.")
+	c.dryRun = fs.Bool("dry-run", false, "With -batch, print each request and the request that undoes it, without sending them.")
 }
 
 func (c *modifyCommand) Execute(state *state, fs *flag.FlagSet) error {
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
+	if *c.batch != "" {
+		return c.runBatch(state, fs, id, cs)
+	}
 	typ, ok := map[string]string{
 		"add":    "Add",
 		"remove": "Remove",
@@ -531,23 +767,86 @@ func (c *modifyCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if !ok {
 		return fmt.Errorf("unrecognized operation: %s", fs.Arg(0))
 	}
+	settings := json.RawMessage(fs.Arg(2))
 	req := hcsschema.ModifySettingRequest{
 		RequestType:  typ,
 		ResourcePath: fs.Arg(1),
-		Settings:     json.RawMessage(fs.Arg(2)),
+	}
+	// Removals usually take no settings.
+	if len(settings) != 0 {
+		req.Settings = settings
 	}
 	j, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
-	defer op.Close()
-	if err := computecore.HcsModifyComputeSystem(cs.handle, op, string(j), 0); err != nil {
+	start := func(op hcs.Operation) error {
+		return cs.sys.Modify(op, string(j))
+	}
+	// The device commands work from the tracked configuration, so keep it
+	// in step with requests made here.
+	track := func() { trackModify(id, cs, typ, req.ResourcePath, req.Settings) }
+	if background(&c.cf, fs) {
+		bg, err := startJob(state, &c.cf, id, fs.Name(), start)
+		if err != nil {
+			return err
+		}
+		bg.onSuccess = track
+		return nil
+	}
+	if _, err := runOp(state, &c.cf, fs, id, start); err != nil {
 		return err
 	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
+	track()
+	return nil
+}
+
+func (c *modifyCommand) runBatch(state *state, fs *flag.FlagSet, id string, cs *cs) error {
+	if background(&c.cf, fs) {
+		return fmt.Errorf("-batch cannot run in the background, as it must wait for each request")
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("-batch takes no arguments")
+	}
+	reqs, err := readBatch(*c.batch)
+	if err != nil {
 		return err
 	}
+	steps, tree, err := planBatch(state, &c.cf, cs, reqs)
+	if err != nil {
+		return err
+	}
+	if *c.dryRun {
+		for i, s := range steps {
+			for _, r := range []struct {
+				label string
+				req   hcsschema.ModifySettingRequest
+			}{{"do", s.req}, {"undo", s.undo}} {
+				line := fmt.Sprintf("%d %-4s %s %s", i+1, r.label, r.req.RequestType, r.req.ResourcePath)
+				if r.req.Settings != nil {
+					j, err := json.Marshal(r.req.Settings)
+					if err != nil {
+						return err
+					}
+					line += " " + string(j)
+				}
+				fmt.Printf("%s\n", line)
+			}
+		}
+		return nil
+	}
+	if err := runBatch(state, &c.cf, cs, steps); err != nil {
+		// The system is in neither the state before the batch nor the one
+		// after it.
+		if errors.Is(err, errRollbackIncomplete) && cs.config != nil {
+			cs.config = nil
+			fmt.Printf("warning: no longer tracking the configuration of %s, as the batch was only partly undone\n", id)
+		}
+		return err
+	}
+	if tree != nil {
+		return setConfigTree(cs, tree)
+	}
 	return nil
 }
 
@@ -560,27 +859,23 @@ func (c *lmSourceInitializeCommand) Description() string {
 func (c *lmSourceInitializeCommand) ArgHelp() string { return "" }
 func (c *lmSourceInitializeCommand) SetupFlags(fs *flag.FlagSet) {
 	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
 }
 
 func (c *lmSourceInitializeCommand) Execute(state *state, fs *flag.FlagSet) error {
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
-	defer op.Close()
 	options := hcsschema.MigrationInitializeOptions{}
 	optionsRaw, err := json.Marshal(options)
 	if err != nil {
 		return err
 	}
-	if err := computecore.HcsInitializeLiveMigrationOnSource(cs.handle, op, string(optionsRaw)); err != nil {
-		return err
-	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
-		return err
-	}
-	return nil
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.InitializeLiveMigrationOnSource(op, string(optionsRaw))
+	})
+	return err
 }
 
 type lmSourceStartCommand struct{ cf commonFlags }
@@ -592,6 +887,7 @@ func (c *lmSourceStartCommand) Description() string {
 func (c *lmSourceStartCommand) ArgHelp() string { return "SOCKET" }
 func (c *lmSourceStartCommand) SetupFlags(fs *flag.FlagSet) {
 	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
 }
 
 func (c *lmSourceStartCommand) Execute(state *state, fs *flag.FlagSet) error {
@@ -604,15 +900,10 @@ func (c *lmSourceStartCommand) Execute(state *state, fs *flag.FlagSet) error {
 		return err
 	}
 
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
-	defer op.Close()
-	if err := computecore.HcsAddResourceToOperation(op, computecore.HcsResourceTypeSocket, "hcs:/VirtualMachine/LiveMigrationSocket", uintptr(sock)); err != nil {
-		return err
-	}
 	options := hcsschema.MigrationStartOptions{
 		NetworkSettings: &hcsschema.MigrationNetworkSettings{
 			SessionID: 1,
@@ -622,53 +913,13 @@ func (c *lmSourceStartCommand) Execute(state *state, fs *flag.FlagSet) error {
 	if err != nil {
 		return err
 	}
-	if err := computecore.HcsStartLiveMigrationOnSource(cs.handle, op, string(optionsRaw)); err != nil {
-		return err
-	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
-		return err
-	}
-	return nil
-}
-
-func listen(addr netip.AddrPort) (_ windows.Handle, err error) {
-	l, err := windows.Socket(windows.AF_INET, windows.SOCK_STREAM, windows.IPPROTO_TCP)
-	if err != nil {
-		return 0, err
-	}
-	defer windows.Closesocket(l)
-	if err := windows.Bind(l, &windows.SockaddrInet4{Port: int(addr.Port()), Addr: addr.Addr().As4()}); err != nil {
-		return 0, err
-	}
-	conn, err := windows.Socket(windows.AF_INET, windows.SOCK_STREAM, windows.IPPROTO_TCP)
-	if err != nil {
-		return 0, err
-	}
-	defer func() {
-		if err != nil {
-			windows.Closesocket(conn)
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		if err := op.AddResource(hcs.ResourceTypeSocket, "hcs:/VirtualMachine/LiveMigrationSocket", uintptr(sock)); err != nil {
+			return err
 		}
-	}()
-	if err := windows.Listen(l, 1); err != nil {
-		return 0, err
-	}
-	var buf [64]byte
-	var recvd uint32
-	event, err := windows.CreateEvent(nil, 1, 0, nil)
-	if err != nil {
-		return 0, err
-	}
-	defer windows.CloseHandle(event)
-	overlapped := windows.Overlapped{HEvent: event}
-	if err := windows.AcceptEx(l, conn, &buf[0], 0, 32, 32, &recvd, &overlapped); err != nil && err != windows.ERROR_IO_PENDING {
-		return 0, err
-	}
-	fmt.Printf("connecting...\n")
-	if _, err := windows.WaitForSingleObject(event, windows.INFINITE); err != nil {
-		return 0, err
-	}
-	fmt.Printf("connected\n")
-	return conn, nil
+		return cs.sys.StartLiveMigrationOnSource(op, string(optionsRaw))
+	})
+	return err
 }
 
 type lmTransferCommand struct{ cf commonFlags }
@@ -680,27 +931,23 @@ func (c *lmTransferCommand) Description() string {
 func (c *lmTransferCommand) ArgHelp() string { return "" }
 func (c *lmTransferCommand) SetupFlags(fs *flag.FlagSet) {
 	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
 }
 
 func (c *lmTransferCommand) Execute(state *state, fs *flag.FlagSet) error {
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
-	defer op.Close()
 	options := hcsschema.MigrationTransferOptions{}
 	optionsRaw, err := json.Marshal(options)
 	if err != nil {
 		return err
 	}
-	if err := computecore.HcsStartLiveMigrationTransfer(cs.handle, op, string(optionsRaw)); err != nil {
-		return err
-	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
-		return err
-	}
-	return nil
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.StartLiveMigrationTransfer(op, string(optionsRaw))
+	})
+	return err
 }
 
 type lmFinalizeCommand struct{ cf commonFlags }
@@ -712,25 +959,21 @@ func (c *lmFinalizeCommand) Description() string {
 func (c *lmFinalizeCommand) ArgHelp() string { return "" }
 func (c *lmFinalizeCommand) SetupFlags(fs *flag.FlagSet) {
 	setupCommonFlags(&c.cf, fs)
+	setupBackgroundFlag(&c.cf, fs)
 }
 
 func (c *lmFinalizeCommand) Execute(state *state, fs *flag.FlagSet) error {
-	_, cs, err := getCS(state, &c.cf)
+	id, cs, err := getCS(state, &c.cf)
 	if err != nil {
 		return err
 	}
-	op := computecore.NewOperation(0)
-	defer op.Close()
 	options := hcsschema.MigrationFinalizedOptions{}
 	optionsRaw, err := json.Marshal(options)
 	if err != nil {
 		return err
 	}
-	if err := computecore.HcsFinalizeLiveMigration(cs.handle, op, string(optionsRaw)); err != nil {
-		return err
-	}
-	if _, err := op.WaitResult(windows.INFINITE); err != nil {
-		return err
-	}
-	return nil
+	_, err = runOp(state, &c.cf, fs, id, func(op hcs.Operation) error {
+		return cs.sys.FinalizeLiveMigration(op, string(optionsRaw))
+	})
+	return err
 }
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// enableVT does nothing, as terminals elsewhere process escape sequences.
+func enableVT() {}
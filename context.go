@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// interruptHandler routes Ctrl-C to the command that is running, so that it
+// cancels the command's HCS operation rather than killing hcstool. Ctrl-C at
+// the prompt is ignored.
+type interruptHandler struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+func newInterruptHandler() *interruptHandler {
+	h := &interruptHandler{}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		for range c {
+			h.mu.Lock()
+			if h.cancel != nil {
+				h.cancel()
+			}
+			h.mu.Unlock()
+		}
+	}()
+	return h
+}
+
+func (h *interruptHandler) set(cancel context.CancelFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cancel = cancel
+}
+
+// opContext returns the context to wait on a command's HCS operations with.
+// It is cancelled by Ctrl-C, and times out after the command's -timeout, or
+// the global -timeout if the command's is not set.
+func opContext(state *state, cf *commonFlags) (context.Context, context.CancelFunc) {
+	ctx, cancel := interruptContext(state)
+	if timeout := opTimeout(state, cf); timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		return ctx, func() {
+			cancelTimeout()
+			cancel()
+		}
+	}
+	return ctx, cancel
+}
+
+// interruptContext returns a context that only Ctrl-C cancels, for commands
+// that run until stopped and bound each of their HCS calls separately.
+func interruptContext(state *state) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(state.ctx)
+	state.interrupt.set(cancel)
+	return ctx, func() {
+		state.interrupt.set(nil)
+		cancel()
+	}
+}
+
+// opTimeout returns how long a command's operations may take, or 0 if there
+// is no limit.
+func opTimeout(state *state, cf *commonFlags) time.Duration {
+	if cf.timeout != nil && *cf.timeout > 0 {
+		return *cf.timeout
+	}
+	return state.timeout
+}
+
+func setupTimeoutFlag(cf *commonFlags, fs *flag.FlagSet) {
+	cf.timeout = fs.Duration("timeout", 0, "Time to wait for HCS operations before cancelling them. Defaults to the global -timeout.")
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// cpuGroupProperties are the properties that cpugroup set can change, by the
+// flag that sets them.
+var cpuGroupProperties = []struct {
+	flag string
+	code uint32
+}{
+	{"capacity", hcsschema.CPUCapacityProperty},
+	{"priority", hcsschema.CPUSchedulingPriorityProperty},
+	{"idlereserve", hcsschema.IdleLPReserveProperty},
+}
+
+type cpuGroupCommand struct {
+	cf          commonFlags
+	lps         *string
+	count       *int
+	policy      *string
+	noSMT       *bool
+	overlap     *bool
+	dryRun      *bool
+	capacity    *uint
+	priority    *uint
+	idleReserve *uint
+}
+
+func (c *cpuGroupCommand) Name() string { return "cpugroup" }
+func (c *cpuGroupCommand) Description() string {
+	return "Creates, deletes, sets properties of, lists or assigns VMs to host CPU groups."
+}
+func (c *cpuGroupCommand) ArgHelp() string {
+	return "create [ID]|delete ID|set ID|list|assign ID|none"
+}
+func (c *cpuGroupCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.lps = fs.String("lps", "", "Logical processors of a new group, as a list of indexes and ranges such as 0-3,8.")
+	c.count = fs.Int("count", 0, "Number of logical processors to choose for a new group, instead of -lps.")
+	c.policy = fs.String("policy", placePack, "How -count chooses logical processors: pack onto one NUMA node, or spread across nodes.")
+	c.noSMT = fs.Bool("nosmt", false, "With -count, choose at most one logical processor of each core.")
+	c.overlap = fs.Bool("overlap", false, "With -count, allow logical processors that are already in other groups.")
+	c.dryRun = fs.Bool("dry-run", false, "Print the request that would create the group instead of sending it.")
+	c.capacity = fs.Uint("capacity", 0, "CPUCapacity property of the group.")
+	c.priority = fs.Uint("priority", 0, "SchedulingPriority property of the group.")
+	c.idleReserve = fs.Uint("idlereserve", 0, "IdleLPReserve property of the group.")
+}
+
+func (c *cpuGroupCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sub, err := parseSubcommand(fs, "create", "delete", "set", "list", "assign")
+	if err != nil {
+		return err
+	}
+	id := strings.ToLower(fs.Arg(0))
+	if id == "" && sub != "create" && sub != "list" {
+		return fmt.Errorf("a CPU group ID is required")
+	}
+	switch sub {
+	case "create":
+		return c.create(state, fs, id)
+	case "delete":
+		return modifyCPUGroups(state, hcsschema.DeleteGroup, hcsschema.DeleteGroupOperation{GroupId: id})
+	case "set":
+		set, err := c.setProperties(state, fs, id)
+		if err == nil && !set {
+			err = fmt.Errorf("nothing to set; set -capacity, -priority or -idlereserve")
+		}
+		return err
+	case "assign":
+		return c.assign(state, id)
+	default:
+		return c.list(state)
+	}
+}
+
+func (c *cpuGroupCommand) create(state *state, fs *flag.FlagSet, id string) error {
+	var (
+		lps []uint32
+		err error
+	)
+	switch {
+	case *c.lps != "" && *c.count != 0:
+		return fmt.Errorf("-lps and -count cannot be used together")
+	case *c.lps != "":
+		lps, err = parseLPList(*c.lps)
+	case *c.count != 0:
+		lps, err = c.place(state)
+	default:
+		return fmt.Errorf("-lps or -count is required to create a group")
+	}
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		id = hcs.NewGUID()
+	}
+	op := hcsschema.CreateGroupOperation{
+		GroupId:               id,
+		LogicalProcessorCount: uint32(len(lps)),
+		LogicalProcessors:     lps,
+	}
+	if *c.dryRun {
+		j, err := json.MarshalIndent(cpuGroupRequest(hcsschema.CreateGroup, op), "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", j)
+		return nil
+	}
+	if err := modifyCPUGroups(state, hcsschema.CreateGroup, op); err != nil {
+		return err
+	}
+	fmt.Printf("created CPU group %s with logical processors %s\n", id, formatLPList(lps))
+	// Properties given along with create are set straight away.
+	_, err = c.setProperties(state, fs, id)
+	return err
+}
+
+// place chooses the logical processors of a new group from the host topology,
+// according to the -count, -policy, -nosmt and -overlap flags.
+func (c *cpuGroupCommand) place(state *state) ([]uint32, error) {
+	policy, err := oneOf("policy", *c.policy, placementPolicies...)
+	if err != nil {
+		return nil, err
+	}
+	var topology hcsschema.ProcessorTopology
+	if err := serviceProperty(state, hcsschema.PTProcessorTopology, &topology); err != nil {
+		return nil, err
+	}
+	used := make(map[uint32]bool)
+	if !*c.overlap {
+		groups, err := cpuGroups(state)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			if g.Affinity != nil {
+				for _, lp := range g.Affinity.LogicalProcessors {
+					used[uint32(lp)] = true
+				}
+			}
+		}
+	}
+	return placeLPs(&topology, used, *c.count, policy, *c.noSMT)
+}
+
+// setProperties sets the group properties whose flags were given, and reports
+// whether there were any.
+func (c *cpuGroupCommand) setProperties(state *state, fs *flag.FlagSet, id string) (bool, error) {
+	values := map[string]uint{"capacity": *c.capacity, "priority": *c.priority, "idlereserve": *c.idleReserve}
+	var ops []hcsschema.SetPropertyOperation
+	fs.Visit(func(f *flag.Flag) {
+		for _, p := range cpuGroupProperties {
+			if p.flag == f.Name {
+				ops = append(ops, hcsschema.SetPropertyOperation{GroupId: id, PropertyCode: p.code, PropertyValue: uint32(values[p.flag])})
+			}
+		}
+	})
+	for _, op := range ops {
+		if err := modifyCPUGroups(state, hcsschema.SetProperty, op); err != nil {
+			return false, err
+		}
+	}
+	return len(ops) > 0, nil
+}
+
+func (c *cpuGroupCommand) assign(state *state, group string) error {
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	if cs.config != nil && cs.config.VirtualMachine == nil {
+		return fmt.Errorf("%s is not a virtual machine", id)
+	}
+	if group == "none" {
+		group = hcsschema.NullCpuGroupId
+	}
+	g := &hcsschema.CpuGroup{Id: group}
+	if err := modifySystem(state, &c.cf, cs, "Update", "VirtualMachine/ComputeTopology/Processor/CpuGroup", g); err != nil {
+		return err
+	}
+	if cs.config != nil {
+		vm := cs.config.VirtualMachine
+		if vm.ComputeTopology == nil {
+			vm.ComputeTopology = &hcsschema.Topology{}
+		}
+		if vm.ComputeTopology.Processor == nil {
+			vm.ComputeTopology.Processor = &hcsschema.Processor2{}
+		}
+		vm.ComputeTopology.Processor.CpuGroup = g
+	}
+	return nil
+}
+
+func (c *cpuGroupCommand) list(state *state) error {
+	groups, err := cpuGroups(state)
+	if err != nil {
+		return err
+	}
+	cols := []colInfo{{"ID", "%s"}, {"HVID", "%d"}, {"LPS", "%s"}}
+	for _, p := range cpuGroupProperties {
+		cols = append(cols, colInfo{strings.ToUpper(p.flag), "%s"})
+	}
+	return printTable(cols, groups, func(g hcsschema.CpuGroupConfig) []any {
+		var lps []uint32
+		if g.Affinity != nil {
+			for _, lp := range g.Affinity.LogicalProcessors {
+				lps = append(lps, uint32(lp))
+			}
+		}
+		row := []any{g.GroupId, g.HypervisorGroupId, formatLPList(lps)}
+		for _, p := range cpuGroupProperties {
+			value := ""
+			for _, gp := range g.GroupProperties {
+				if gp.PropertyCode == p.code {
+					value = strconv.FormatUint(uint64(gp.PropertyValue), 10)
+				}
+			}
+			row = append(row, value)
+		}
+		return row
+	})
+}
+
+func cpuGroupRequest(op hcsschema.CPUGroupOperation, details any) hcsschema.ModificationRequest {
+	return hcsschema.ModificationRequest{
+		PropertyType: hcsschema.PTCPUGroup,
+		Settings: &hcsschema.HostProcessorModificationRequest{
+			Operation:        op,
+			OperationDetails: details,
+		},
+	}
+}
+
+// modifyCPUGroups sends a CPU group operation to the host.
+func modifyCPUGroups(state *state, op hcsschema.CPUGroupOperation, details any) error {
+	j, err := json.Marshal(cpuGroupRequest(op, details))
+	if err != nil {
+		return err
+	}
+	return state.hcs.ModifyServiceSettings(string(j))
+}
+
+// serviceProperty queries a single service property into v.
+func serviceProperty(state *state, pt hcsschema.PropertyType, v any) error {
+	j, err := json.Marshal(hcsschema.PropertyQuery{PropertyTypes: []hcsschema.PropertyType{pt}})
+	if err != nil {
+		return err
+	}
+	result, err := state.hcs.GetServiceProperties(string(j))
+	if err != nil {
+		return err
+	}
+	var sp hcsschema.ServiceProperties
+	if err := json.Unmarshal([]byte(result), &sp); err != nil {
+		return err
+	}
+	if len(sp.Properties) != 1 {
+		return fmt.Errorf("expected 1 %s property, got %d", pt, len(sp.Properties))
+	}
+	return json.Unmarshal(sp.Properties[0], v)
+}
+
+func cpuGroups(state *state) ([]hcsschema.CpuGroupConfig, error) {
+	var groups hcsschema.CpuGroupConfigurations
+	if err := serviceProperty(state, hcsschema.PTCPUGroup, &groups); err != nil {
+		return nil, err
+	}
+	return groups.CpuGroups, nil
+}
+
+// parseLPList parses a list of logical processor indexes and ranges, such as
+// 0-3,8. The result is sorted.
+func parseLPList(s string) ([]uint32, error) {
+	seen := make(map[uint32]bool)
+	var lps []uint32
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.ParseUint(first, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid logical processor %q", part)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.ParseUint(last, 10, 32); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid logical processor range %q", part)
+			}
+		}
+		for lp := lo; lp <= hi; lp++ {
+			if seen[uint32(lp)] {
+				return nil, fmt.Errorf("logical processor %d is listed twice", lp)
+			}
+			seen[uint32(lp)] = true
+			lps = append(lps, uint32(lp))
+		}
+	}
+	sort.Slice(lps, func(i, j int) bool { return lps[i] < lps[j] })
+	return lps, nil
+}
+
+// formatLPList formats sorted logical processor indexes the way parseLPList
+// accepts them, with runs collapsed into ranges.
+func formatLPList(lps []uint32) string {
+	var parts []string
+	for i := 0; i < len(lps); {
+		j := i
+		for j+1 < len(lps) && lps[j+1] == lps[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", lps[i], lps[j]))
+		} else {
+			parts = append(parts, strconv.FormatUint(uint64(lps[i]), 10))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLPList(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []uint32
+		err  bool
+	}{
+		{in: "0", want: []uint32{0}},
+		{in: "3,1,2", want: []uint32{1, 2, 3}},
+		{in: "0-3,8,10-11", want: []uint32{0, 1, 2, 3, 8, 10, 11}},
+		{in: "5-5", want: []uint32{5}},
+		{in: "", err: true},
+		{in: "1,", err: true},
+		{in: "a", err: true},
+		{in: "-1", err: true},
+		{in: "3-1", err: true},
+		{in: "1-", err: true},
+		{in: "4294967296", err: true},
+		{in: "1,0-2", err: true},
+	} {
+		lps, err := parseLPList(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("parseLPList(%q) error = %v, want error %v", tc.in, err, tc.err)
+			continue
+		}
+		if !reflect.DeepEqual(lps, tc.want) {
+			t.Errorf("parseLPList(%q) = %v, want %v", tc.in, lps, tc.want)
+		}
+	}
+}
+
+func TestFormatLPList(t *testing.T) {
+	for _, tc := range []struct {
+		in   []uint32
+		want string
+	}{
+		{nil, ""},
+		{[]uint32{7}, "7"},
+		{[]uint32{0, 1}, "0-1"},
+		{[]uint32{0, 1, 2, 3, 8, 10, 11}, "0-3,8,10-11"},
+		{[]uint32{1, 3, 5}, "1,3,5"},
+	} {
+		got := formatLPList(tc.in)
+		if got != tc.want {
+			t.Errorf("formatLPList(%v) = %q, want %q", tc.in, got, tc.want)
+			continue
+		}
+		if got == "" {
+			continue
+		}
+		// The output is accepted by parseLPList.
+		if lps, err := parseLPList(got); err != nil || !reflect.DeepEqual(lps, tc.in) {
+			t.Errorf("parseLPList(%q) = %v, %v, want %v", got, lps, err, tc.in)
+		}
+	}
+}
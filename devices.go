@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// parseSubcommand returns the subcommand named by the first argument, which
+// must be one of subcommands. The flags after it are parsed too, so that they
+// can be given on either side.
+func parseSubcommand(fs *flag.FlagSet, subcommands ...string) (string, error) {
+	sub := strings.ToLower(fs.Arg(0))
+	found := false
+	for _, s := range subcommands {
+		found = found || sub == s
+	}
+	if !found {
+		return "", fmt.Errorf("expected one of %s, got %q", strings.Join(subcommands, ", "), fs.Arg(0))
+	}
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return "", err
+	}
+	return sub, nil
+}
+
+// modifySystem sends a ModifySettingRequest for the resource at path, and
+// waits for it to complete.
+func modifySystem(state *state, cf *commonFlags, cs *cs, requestType, path string, settings any) error {
+	return sendModify(state, cf, cs, hcsschema.ModifySettingRequest{
+		RequestType:  requestType,
+		ResourcePath: path,
+		Settings:     settings,
+	})
+}
+
+// sendModify sends req to cs, and waits for it to complete.
+func sendModify(state *state, cf *commonFlags, cs *cs, req hcsschema.ModifySettingRequest) error {
+	j, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := cs.sys.Modify(op, string(j)); err != nil {
+		return err
+	}
+	_, err = hcs.WaitResult(ctx, op)
+	return err
+}
+
+// queryProperties returns the basic properties of cs along with those of the
+// given types.
+func queryProperties(state *state, cf *commonFlags, cs *cs, types ...hcsschema.PropertyType) (*hcsschema.Properties, error) {
+	result, err := queryRawProperties(state, cf, cs, types...)
+	if err != nil {
+		return nil, err
+	}
+	var props hcsschema.Properties
+	if err := json.Unmarshal([]byte(result), &props); err != nil {
+		return nil, err
+	}
+	return &props, nil
+}
+
+// queryRawProperties is queryProperties, returning the properties as HCS
+// encoded them.
+func queryRawProperties(state *state, cf *commonFlags, cs *cs, types ...hcsschema.PropertyType) (string, error) {
+	j, err := json.Marshal(hcsschema.PropertyQuery{PropertyTypes: types})
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := opContext(state, cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := cs.sys.GetProperties(op, string(j)); err != nil {
+		return "", err
+	}
+	return hcs.WaitResult(ctx, op)
+}
+
+// vmConfig returns the VirtualMachine section of the configuration hcstool
+// tracks for cs: the document it was created with, as changed since by
+// hcstool. HCS does not report device configuration, so it is not known for
+// systems that were opened rather than created.
+func vmConfig(id string, cs *cs) (*hcsschema.VirtualMachine, error) {
+	if cs.config == nil {
+		return nil, fmt.Errorf("configuration of %s is not known, as it was not created by hcstool or was changed in a way hcstool could not follow", id)
+	}
+	vm := cs.config.VirtualMachine
+	if vm == nil {
+		return nil, fmt.Errorf("%s is not a virtual machine", id)
+	}
+	if vm.Devices == nil {
+		vm.Devices = &hcsschema.Devices{}
+	}
+	return vm, nil
+}
+
+// printTracked notes above a device list that it shows the tracked
+// configuration of id, not properties reported by HCS.
+func printTracked(id string) {
+	fmt.Printf("Tracked configuration of %s, as created and changed through hcstool (not live properties):\n", id)
+}
+
+// configTree returns the tracked configuration of cs as a document tree, or
+// nil if it is not known.
+func configTree(cs *cs) (map[string]any, error) {
+	if cs.config == nil {
+		return nil, nil
+	}
+	j, err := json.Marshal(cs.config)
+	if err != nil {
+		return nil, err
+	}
+	return hcs.DecodeTree(j)
+}
+
+// setConfigTree replaces the tracked configuration of cs with tree.
+func setConfigTree(cs *cs, tree map[string]any) error {
+	j, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	var config hcsschema.ComputeSystem
+	if err := json.Unmarshal(j, &config); err != nil {
+		return err
+	}
+	cs.config = &config
+	return nil
+}
+
+// trackModify applies a request that HCS has carried out to the tracked
+// configuration of cs. If the request does not apply to it, the tracked
+// configuration no longer matches the system, so it is dropped.
+func trackModify(id string, cs *cs, requestType, path string, settings any) {
+	tree, err := configTree(cs)
+	if err != nil || tree == nil {
+		return
+	}
+	var v any
+	if settings != nil {
+		var j []byte
+		if j, err = json.Marshal(settings); err == nil {
+			v, err = hcs.DecodeValue(j)
+		}
+	}
+	if err == nil {
+		err = hcs.ApplyModify(tree, path, requestType, v)
+		// Removing what the tracked configuration lacks leaves it right.
+		if requestType == "Remove" && errors.Is(err, hcs.ERROR_NOT_FOUND) {
+			return
+		}
+	}
+	if err == nil {
+		err = setConfigTree(cs, tree)
+	}
+	if err != nil {
+		cs.config = nil
+		fmt.Printf("warning: no longer tracking the configuration of %s: %s\n", id, err)
+	}
+}
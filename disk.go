@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+type diskCommand struct {
+	cf               commonFlags
+	controller       *uint
+	lun              *int
+	typ              *string
+	readOnly         *bool
+	cache            *string
+	ignoreFlushes    *bool
+	noWriteHardening *bool
+	noGrant          *bool
+}
+
+func (c *diskCommand) Name() string { return "disk" }
+func (c *diskCommand) Description() string {
+	return "Hot-adds, removes or lists SCSI disks of a VM."
+}
+func (c *diskCommand) ArgHelp() string { return "add PATH|remove|list" }
+func (c *diskCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.controller = fs.Uint("controller", 0, "SCSI controller of the disk.")
+	c.lun = fs.Int("lun", -1, "LUN of the disk. Defaults to the first free LUN when adding.")
+	c.typ = fs.String("type", "", "Attachment type: VirtualDisk, Iso or PassThru. Defaults to Iso for .iso files, else VirtualDisk.")
+	c.readOnly = fs.Bool("ro", false, "Attach the disk read-only.")
+	c.cache = fs.String("cache", "", "Caching mode: Uncached, Cached or ReadOnlyCached.")
+	c.ignoreFlushes = fs.Bool("ignoreflushes", false, "Ignore flushes from the guest.")
+	c.noWriteHardening = fs.Bool("nowritehardening", false, "Disable write hardening.")
+	c.noGrant = fs.Bool("nogrant", false, "Do not grant the VM access to the disk.")
+}
+
+func (c *diskCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sub, err := parseSubcommand(fs, "add", "remove", "list")
+	if err != nil {
+		return err
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	if sub == "list" {
+		return c.list(state, id, cs)
+	}
+	vm, err := vmConfig(id, cs)
+	if err != nil {
+		return err
+	}
+	if sub == "add" {
+		return c.add(state, id, cs, vm, fs.Arg(0))
+	}
+	return c.remove(state, cs, vm)
+}
+
+func (c *diskCommand) add(state *state, id string, cs *cs, vm *hcsschema.VirtualMachine, path string) error {
+	if path == "" {
+		return fmt.Errorf("a disk path is required")
+	}
+	controller := strconv.FormatUint(uint64(*c.controller), 10)
+	ctrl, ok := vm.Devices.Scsi[controller]
+	if !ok {
+		// SCSI controllers cannot be hot-added.
+		return fmt.Errorf("%s has no SCSI controller %s", id, controller)
+	}
+	lun, err := c.pickLUN(ctrl)
+	if err != nil {
+		return err
+	}
+	a := hcsschema.Attachment{
+		Type_:            "VirtualDisk",
+		ReadOnly:         *c.readOnly,
+		IgnoreFlushes:    *c.ignoreFlushes,
+		NoWriteHardening: *c.noWriteHardening,
+	}
+	if strings.EqualFold(filepath.Ext(path), ".iso") {
+		a.Type_ = "Iso"
+	}
+	if *c.typ != "" {
+		if a.Type_, err = oneOf("type", *c.typ, fieldEnums["Attachment.Type"]...); err != nil {
+			return err
+		}
+	}
+	if *c.cache != "" {
+		if a.CachingMode, err = oneOf("cache", *c.cache, fieldEnums["Attachment.CachingMode"]...); err != nil {
+			return err
+		}
+	}
+	// Pass-through disks are named by device path, not file.
+	a.Path = path
+	if a.Type_ != "PassThru" {
+		if a.Path, err = filepath.Abs(path); err != nil {
+			return err
+		}
+		if !*c.noGrant {
+			if err := state.hcs.GrantVmAccess(id, a.Path); err != nil {
+				return fmt.Errorf("grant access to %s: %w", a.Path, err)
+			}
+		}
+	}
+	if err := modifySystem(state, &c.cf, cs, "Add", scsiResourcePath(controller, lun), a); err != nil {
+		return err
+	}
+	if ctrl.Attachments == nil {
+		ctrl.Attachments = make(map[string]hcsschema.Attachment)
+		vm.Devices.Scsi[controller] = ctrl
+	}
+	ctrl.Attachments[lun] = a
+	fmt.Printf("added %s at controller %s, LUN %s\n", a.Path, controller, lun)
+	return nil
+}
+
+// pickLUN returns the -lun flag, or else the first free LUN of ctrl.
+func (c *diskCommand) pickLUN(ctrl hcsschema.Scsi) (string, error) {
+	if *c.lun >= 0 {
+		if *c.lun > 63 {
+			return "", fmt.Errorf("LUN %d is not from 0 to 63", *c.lun)
+		}
+		lun := strconv.Itoa(*c.lun)
+		if a, ok := ctrl.Attachments[lun]; ok {
+			return "", fmt.Errorf("LUN %s is already used by %s", lun, a.Path)
+		}
+		return lun, nil
+	}
+	for n := 0; n <= 63; n++ {
+		if _, ok := ctrl.Attachments[strconv.Itoa(n)]; !ok {
+			return strconv.Itoa(n), nil
+		}
+	}
+	return "", fmt.Errorf("no free LUN on the controller")
+}
+
+func (c *diskCommand) remove(state *state, cs *cs, vm *hcsschema.VirtualMachine) error {
+	if *c.lun < 0 {
+		return fmt.Errorf("-lun is required to remove a disk")
+	}
+	controller := strconv.FormatUint(uint64(*c.controller), 10)
+	lun := strconv.Itoa(*c.lun)
+	if _, ok := vm.Devices.Scsi[controller].Attachments[lun]; !ok {
+		return fmt.Errorf("no disk at controller %s, LUN %s", controller, lun)
+	}
+	if err := modifySystem(state, &c.cf, cs, "Remove", scsiResourcePath(controller, lun), nil); err != nil {
+		return err
+	}
+	delete(vm.Devices.Scsi[controller].Attachments, lun)
+	return nil
+}
+
+// reportedSCSI returns the SCSI controllers of cs that its properties
+// report, in a VirtualMachine section shaped as in the document. The
+// properties HCS reports today leave out device configuration, so ok is
+// usually false.
+func reportedSCSI(state *state, cf *commonFlags, cs *cs) (scsi map[string]hcsschema.Scsi, ok bool, err error) {
+	result, err := queryRawProperties(state, cf, cs)
+	if err != nil {
+		return nil, false, err
+	}
+	var props struct {
+		VirtualMachine *struct {
+			Devices *struct {
+				Scsi map[string]hcsschema.Scsi
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(result), &props); err != nil {
+		return nil, false, err
+	}
+	if vm := props.VirtualMachine; vm != nil && vm.Devices != nil && vm.Devices.Scsi != nil {
+		return vm.Devices.Scsi, true, nil
+	}
+	return nil, false, nil
+}
+
+// list shows the SCSI attachments of id as its properties report them, or
+// else as the tracked configuration has them.
+func (c *diskCommand) list(state *state, id string, cs *cs) error {
+	scsi, ok, err := reportedSCSI(state, &c.cf, cs)
+	if err != nil {
+		return err
+	}
+	if ok {
+		fmt.Printf("SCSI attachments of %s, as reported by HCS:\n", id)
+	} else {
+		vm, err := vmConfig(id, cs)
+		if err != nil {
+			return fmt.Errorf("HCS did not report the SCSI attachments of %s: %w", id, err)
+		}
+		printTracked(id)
+		scsi = vm.Devices.Scsi
+	}
+	type disk struct {
+		controller, lun string
+		a               hcsschema.Attachment
+	}
+	var disks []disk
+	for controller, ctrl := range scsi {
+		for lun, a := range ctrl.Attachments {
+			disks = append(disks, disk{controller, lun, a})
+		}
+	}
+	sort.Slice(disks, func(i, j int) bool {
+		a, b := disks[i], disks[j]
+		if a.controller != b.controller {
+			return numericLess(a.controller, b.controller)
+		}
+		return numericLess(a.lun, b.lun)
+	})
+	return printTable(
+		[]colInfo{{"CONTROLLER", "%s"}, {"LUN", "%s"}, {"TYPE", "%s"}, {"CACHE", "%s"}, {"FLAGS", "%s"}, {"PATH", "%s"}},
+		disks,
+		func(d disk) []any {
+			var flags []string
+			if d.a.ReadOnly {
+				flags = append(flags, "ro")
+			}
+			if d.a.IgnoreFlushes {
+				flags = append(flags, "ignoreflushes")
+			}
+			if d.a.NoWriteHardening {
+				flags = append(flags, "nowritehardening")
+			}
+			return []any{d.controller, d.lun, d.a.Type_, d.a.CachingMode, strings.Join(flags, ","), d.a.Path}
+		},
+	)
+}
+
+func scsiResourcePath(controller, lun string) string {
+	return fmt.Sprintf("VirtualMachine/Devices/Scsi/%s/Attachments/%s", controller, lun)
+}
+
+// numericLess orders keys that are numbers by value, and other keys after
+// them by name.
+func numericLess(a, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return x < y
+	case errA == nil || errB == nil:
+		return errA == nil
+	}
+	return a < b
+}
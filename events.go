@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// eventHistory is the number of events kept for the events command.
+const eventHistory = 512
+
+type eventRecord struct {
+	seq  int
+	time time.Time
+	cs   string
+	e    hcs.Event
+}
+
+// eventLog collects events from every watched compute system. Events arrive
+// on HCS callback threads, so all fields are protected by mu.
+type eventLog struct {
+	mu       sync.Mutex
+	records  []eventRecord
+	nextSeq  int
+	notified int
+	subs     map[chan eventRecord]struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{subs: make(map[chan eventRecord]struct{})}
+}
+
+func (l *eventLog) add(cs string, e hcs.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextSeq++
+	r := eventRecord{seq: l.nextSeq, time: time.Now(), cs: cs, e: e}
+	if len(l.records) == eventHistory {
+		copy(l.records, l.records[1:])
+		l.records = l.records[:eventHistory-1]
+	}
+	l.records = append(l.records, r)
+	for ch := range l.subs {
+		// A follower that is not keeping up misses events rather than
+		// blocking HCS.
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+func (l *eventLog) history() []eventRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]eventRecord(nil), l.records...)
+}
+
+func (l *eventLog) subscribe() chan eventRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan eventRecord, 64)
+	l.subs[ch] = struct{}{}
+	return ch
+}
+
+func (l *eventLog) unsubscribe(ch chan eventRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.subs, ch)
+}
+
+// watchSystem registers a callback that records sys's events under id. A
+// system that cannot be watched is still usable, so failure is only reported.
+func watchSystem(state *state, id string, sys hcs.System) {
+	if err := sys.SetCallback(func(e hcs.Event) { state.events.add(id, e) }); err != nil {
+		fmt.Printf("warning: cannot watch events for %s: %s\n", id, err)
+	}
+}
+
+// notifyEvents prints a line for each event since the last prompt that
+// changes what can be done with a system.
+func notifyEvents(state *state) {
+	l := state.events
+	l.mu.Lock()
+	var records []eventRecord
+	for _, r := range l.records {
+		if r.seq > l.notified {
+			records = append(records, r)
+		}
+	}
+	l.notified = l.nextSeq
+	l.mu.Unlock()
+	for _, r := range records {
+		switch r.e.Type {
+		case hcs.EventTypeSystemExited, hcs.EventTypeSystemCrashInitiated, hcs.EventTypeSystemGuestConnectionClosed, hcs.EventTypeServiceDisconnect:
+			fmt.Println(strings.TrimSpace(fmt.Sprintf("[event] %s %s %s", r.cs, r.e.Type, describeEvent(r.e))))
+		}
+	}
+}
+
+// describeEvent summarizes an event's payload. Payloads that cannot be
+// decoded are shown as is.
+func describeEvent(e hcs.Event) string {
+	if e.Data == "" {
+		return ""
+	}
+	switch e.Type {
+	case hcs.EventTypeSystemExited:
+		var status hcsschema.SystemExitStatus
+		if err := json.Unmarshal([]byte(e.Data), &status); err != nil {
+			break
+		}
+		s := status.ExitType
+		if status.Status != 0 {
+			s += ": " + hcs.HRESULT(status.Status).Error()
+		}
+		return s
+	case hcs.EventTypeSystemCrashInitiated, hcs.EventTypeSystemCrashReport:
+		var report hcsschema.CrashReport
+		if err := json.Unmarshal([]byte(e.Data), &report); err != nil {
+			break
+		}
+		params := make([]string, 0, len(report.CrashParameters))
+		for _, p := range report.CrashParameters {
+			params = append(params, fmt.Sprintf("0x%x", p))
+		}
+		s := "parameters " + strings.Join(params, " ")
+		if report.WindowsCrashInfo != nil && report.WindowsCrashInfo.DumpFile != "" {
+			s += ", dump " + report.WindowsCrashInfo.DumpFile
+		}
+		if report.CrashLog != "" {
+			s += fmt.Sprintf(", log %q", report.CrashLog)
+		}
+		return s
+	case hcs.EventTypeSystemSiloJobCreated:
+		var silo hcsschema.SiloProperties
+		if err := json.Unmarshal([]byte(e.Data), &silo); err != nil {
+			break
+		}
+		return "job " + silo.JobName
+	}
+	return e.Data
+}
+
+// parseEventTypes parses a comma separated list of event type names. The
+// "System" or "Service" prefix may be left out, and case is ignored.
+func parseEventTypes(s string) (map[hcs.EventType]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	all := []hcs.EventType{
+		hcs.EventTypeSystemExited,
+		hcs.EventTypeSystemCrashInitiated,
+		hcs.EventTypeSystemCrashReport,
+		hcs.EventTypeSystemRdpEnhancedModeStateChanged,
+		hcs.EventTypeSystemSiloJobCreated,
+		hcs.EventTypeSystemGuestConnectionClosed,
+		hcs.EventTypeServiceDisconnect,
+	}
+	types := make(map[hcs.EventType]bool)
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, t := range all {
+			short := strings.TrimPrefix(strings.TrimPrefix(t.String(), "System"), "Service")
+			if strings.EqualFold(name, t.String()) || strings.EqualFold(name, short) {
+				types[t] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown event type: %s", name)
+		}
+	}
+	return types, nil
+}
+
+type eventsCommand struct {
+	cf     commonFlags
+	system *string
+	types  *string
+	n      *int
+	follow *bool
+	raw    *bool
+}
+
+func (c *eventsCommand) Name() string { return "events" }
+func (c *eventsCommand) Description() string {
+	return "Shows recent compute system events, or follows new ones."
+}
+func (c *eventsCommand) ArgHelp() string { return "" }
+func (c *eventsCommand) SetupFlags(fs *flag.FlagSet) {
+	setupTimeoutFlag(&c.cf, fs)
+	c.system = fs.String("cs", "", "Only show events for this compute system.")
+	c.types = fs.String("type", "", "Only show events of these comma separated types, such as Exited,CrashReport.")
+	c.n = fs.Int("n", 0, fmt.Sprintf("Number of past events to show. 0 shows all that are kept, up to %d.", eventHistory))
+	c.follow = fs.Bool("f", false, "Keep showing new events until Ctrl-C or the timeout.")
+	c.raw = fs.Bool("raw", false, "Show the JSON payload of events instead of decoding it.")
+}
+
+func (c *eventsCommand) Execute(state *state, fs *flag.FlagSet) error {
+	types, err := parseEventTypes(*c.types)
+	if err != nil {
+		return err
+	}
+	match := func(r eventRecord) bool {
+		return (*c.system == "" || r.cs == *c.system) && (types == nil || types[r.e.Type])
+	}
+	// Subscribe before reading the history, so that nothing is missed in
+	// between.
+	var ch chan eventRecord
+	if *c.follow {
+		ch = state.events.subscribe()
+		defer state.events.unsubscribe(ch)
+	}
+	var records []eventRecord
+	last := 0
+	for _, r := range state.events.history() {
+		if match(r) {
+			records = append(records, r)
+		}
+		last = r.seq
+	}
+	if *c.n > 0 && len(records) > *c.n {
+		records = records[len(records)-*c.n:]
+	}
+	for _, r := range records {
+		c.print(r)
+	}
+	if !*c.follow {
+		return nil
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	for {
+		select {
+		case r := <-ch:
+			if r.seq > last && match(r) {
+				c.print(r)
+			}
+		case <-ctx.Done():
+			// Following ends on Ctrl-C or the timeout, neither of which is
+			// an error.
+			return nil
+		}
+	}
+}
+
+func (c *eventsCommand) print(r eventRecord) {
+	details := r.e.Data
+	if !*c.raw {
+		details = describeEvent(r.e)
+	}
+	fmt.Println(strings.TrimSpace(fmt.Sprintf("%s %s %s %s", r.time.Format("15:04:05.000"), r.cs, r.e.Type, details)))
+}
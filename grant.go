@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// hostPath is a file or directory on the host that a VM needs access to.
+type hostPath struct {
+	pointer string
+	path    string
+	// output is set for files that HCS creates, such as dump files. Until
+	// they exist, access is granted to their directory instead.
+	output bool
+}
+
+// hostPaths collects every host path that doc references.
+func hostPaths(doc *hcsschema.ComputeSystem) []hostPath {
+	vm := doc.VirtualMachine
+	if vm == nil {
+		return nil
+	}
+	var paths []hostPath
+	add := func(pointer, path string, output bool) {
+		if path != "" {
+			paths = append(paths, hostPath{pointer, path, output})
+		}
+	}
+	if vm.Chipset != nil && vm.Chipset.LinuxKernelDirect != nil {
+		add("/VirtualMachine/Chipset/LinuxKernelDirect/KernelFilePath", vm.Chipset.LinuxKernelDirect.KernelFilePath, false)
+		add("/VirtualMachine/Chipset/LinuxKernelDirect/InitRdPath", vm.Chipset.LinuxKernelDirect.InitRdPath, false)
+	}
+	if vm.GuestState != nil {
+		add("/VirtualMachine/GuestState/GuestStateFilePath", vm.GuestState.GuestStateFilePath, true)
+		add("/VirtualMachine/GuestState/RuntimeStateFilePath", vm.GuestState.RuntimeStateFilePath, true)
+	}
+	if vm.RestoreState != nil {
+		add("/VirtualMachine/RestoreState/SaveStateFilePath", vm.RestoreState.SaveStateFilePath, false)
+	}
+	if d := vm.DebugOptions; d != nil {
+		add("/VirtualMachine/DebugOptions/BugcheckSavedStateFileName", d.BugcheckSavedStateFileName, true)
+		add("/VirtualMachine/DebugOptions/BugcheckNoCrashdumpSavedStateFileName", d.BugcheckNoCrashdumpSavedStateFileName, true)
+		add("/VirtualMachine/DebugOptions/TripleFaultSavedStateFileName", d.TripleFaultSavedStateFileName, true)
+		add("/VirtualMachine/DebugOptions/FirmwareDumpFileName", d.FirmwareDumpFileName, true)
+	}
+	if devices := vm.Devices; devices != nil {
+		for _, c := range sortedKeys(devices.Scsi) {
+			for _, lun := range sortedKeys(devices.Scsi[c].Attachments) {
+				a := devices.Scsi[c].Attachments[lun]
+				// Pass-through disks are not files.
+				if a.Type_ != "PassThru" {
+					add("/VirtualMachine/Devices/Scsi/"+escapePointer(c)+"/Attachments/"+escapePointer(lun)+"/Path", a.Path, false)
+				}
+			}
+		}
+		if devices.VirtualPMem != nil {
+			for _, k := range sortedKeys(devices.VirtualPMem.Devices) {
+				d := devices.VirtualPMem.Devices[k]
+				add("/VirtualMachine/Devices/VirtualPMem/Devices/"+escapePointer(k)+"/HostPath", d.HostPath, false)
+				for _, offset := range pmemOffsets(d) {
+					add(fmt.Sprintf("/VirtualMachine/Devices/VirtualPMem/Devices/%s/Mappings/%d/HostPath", escapePointer(k), offset), d.Mappings[offset].HostPath, false)
+				}
+			}
+		}
+		if devices.Plan9 != nil {
+			for i, s := range devices.Plan9.Shares {
+				add("/VirtualMachine/Devices/Plan9/Shares/"+strconv.Itoa(i)+"/Path", s.Path, false)
+			}
+		}
+		if devices.VirtualSmb != nil {
+			for i, s := range devices.VirtualSmb.Shares {
+				add("/VirtualMachine/Devices/VirtualSmb/Shares/"+strconv.Itoa(i)+"/Path", s.Path, false)
+			}
+		}
+		if r := devices.GuestCrashReporting; r != nil && r.WindowsCrashSettings != nil {
+			add("/VirtualMachine/Devices/GuestCrashReporting/WindowsCrashSettings/DumpFileName", r.WindowsCrashSettings.DumpFileName, true)
+		}
+	}
+	return paths
+}
+
+// grantTarget returns the path to grant access to for p: the path as the
+// document gives it, since that is the path HCS opens.
+func grantTarget(p hostPath) string {
+	if p.output {
+		if _, err := os.Stat(p.path); errors.Is(err, fs.ErrNotExist) {
+			return filepath.Dir(p.path)
+		}
+	}
+	return p.path
+}
+
+// isAbsHostPath reports whether path is absolute. Documents hold Windows
+// paths, so drive and UNC paths are absolute on any OS hcstool runs on.
+func isAbsHostPath(path string) bool {
+	if filepath.IsAbs(path) || strings.HasPrefix(path, `\\`) {
+		return true
+	}
+	return len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/') &&
+		('a' <= path[0]|0x20 && path[0]|0x20 <= 'z')
+}
+
+// checkHostPaths reports every relative host path in doc. HCS opens files
+// from its own working directory, so a relative path names the wrong file.
+func checkHostPaths(doc *hcsschema.ComputeSystem) error {
+	n := 0
+	for _, p := range hostPaths(doc) {
+		if !isAbsHostPath(p.path) {
+			fmt.Printf("%s: %s is a relative path\n", p.pointer, p.path)
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("document has %d relative host path(s), which HCS would resolve against its own working directory", n)
+	}
+	return nil
+}
+
+// grantHostPaths grants the VM id access to every host path in doc, or with
+// dryRun, lists what would be granted.
+func grantHostPaths(state *state, id string, doc *hcsschema.ComputeSystem, dryRun bool) error {
+	type grant struct {
+		hostPath
+		target string
+	}
+	var grants []grant
+	seen := make(map[string]bool)
+	for _, p := range hostPaths(doc) {
+		target := grantTarget(p)
+		// Windows paths are not case sensitive.
+		if seen[strings.ToLower(target)] {
+			continue
+		}
+		seen[strings.ToLower(target)] = true
+		grants = append(grants, grant{p, target})
+	}
+	if dryRun {
+		return printTable(
+			[]colInfo{{"FIELD", "%s"}, {"GRANT", "%s"}},
+			grants,
+			func(g grant) []any { return []any{g.pointer, g.target} },
+		)
+	}
+	for _, g := range grants {
+		if err := state.hcs.GrantVmAccess(id, g.target); err != nil {
+			return fmt.Errorf("grant access to %s (%s): %w", g.target, g.pointer, err)
+		}
+	}
+	return nil
+}
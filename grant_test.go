@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+func TestIsAbsHostPath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		abs  bool
+	}{
+		{`C:\vm\a.vhdx`, true},
+		{`c:/vm/a.vhdx`, true},
+		{`\\server\share\a.vhdx`, true},
+		{`\\?\C:\vm\a.vhdx`, true},
+		{`a.vhdx`, false},
+		{`vm\a.vhdx`, false},
+		{`C:a.vhdx`, false},
+		{`1:\a.vhdx`, false},
+		{``, false},
+	} {
+		if abs := isAbsHostPath(tc.path); abs != tc.abs {
+			t.Errorf("isAbsHostPath(%q) = %v, want %v", tc.path, abs, tc.abs)
+		}
+	}
+}
+
+func TestCheckHostPaths(t *testing.T) {
+	var doc hcsschema.ComputeSystem
+	if err := json.Unmarshal([]byte(`{"VirtualMachine":{
+		"Chipset":{"LinuxKernelDirect":{"KernelFilePath":"C:\\vm\\vmlinux","InitRdPath":"initrd.img"}},
+		"Devices":{"Scsi":{"0":{"Attachments":{"0":{"Path":"a.vhdx"},"1":{"Type":"PassThru","Path":"PhysicalDrive1"}}}}}}}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+	err := checkHostPaths(&doc)
+	if err == nil || !strings.Contains(err.Error(), "2 relative host path(s)") {
+		t.Errorf("checkHostPaths = %v, want 2 relative paths", err)
+	}
+	doc.VirtualMachine.Chipset.LinuxKernelDirect.InitRdPath = `C:\vm\initrd.img`
+	doc.VirtualMachine.Devices.Scsi["0"].Attachments["0"] = hcsschema.Attachment{Path: `\\server\share\a.vhdx`}
+	if err := checkHostPaths(&doc); err != nil {
+		t.Errorf("checkHostPaths = %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// guestResources are the kinds of guest resource guestmodify builds requests
+// for, with the flags that apply to each on top of the common ones.
+var guestResources = map[string][]string{
+	"disk":    {"path", "controller", "lun", "ro", "opt"},
+	"dir":     {"path", "ro", "share", "port", "host"},
+	"layers":  {"path", "container", "layer", "scratch"},
+	"network": {"adapter", "ns", "endpoint", "mac", "ip", "gateway", "dns", "dnssuffix"},
+	"vpmem":   {"path", "device", "offset", "size"},
+	"raw":     nil,
+}
+
+// guestCommonFlags are the guestmodify flags that apply to every resource.
+var guestCommonFlags = []string{"cs", "timeout", "os", "dry-run"}
+
+type guestModifyCommand struct {
+	cf         commonFlags
+	os         *string
+	dryRun     *bool
+	path       *string
+	readOnly   *bool
+	controller *uint
+	lun        *int
+	options    *string
+	share      *string
+	port       *int
+	host       *string
+	container  *string
+	layers     listFlag
+	scratch    *string
+	adapter    *string
+	namespace  *string
+	endpoint   *string
+	mac        *string
+	ip         *string
+	gateway    *string
+	dns        *string
+	dnsSuffix  *string
+	device     *uint
+	offset     *uint64
+	size       *string
+}
+
+func (c *guestModifyCommand) Name() string { return "guestmodify" }
+func (c *guestModifyCommand) Description() string {
+	return "Sends a guest-side modify request to the guest of a VM."
+}
+func (c *guestModifyCommand) ArgHelp() string {
+	return "add|remove|update disk|dir|layers|network|vpmem, or add|remove|update raw RESOURCETYPE SETTINGS"
+}
+func (c *guestModifyCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.os = fs.String("os", "", "Guest OS: linux or windows. Defaults to linux for VMs created with a Linux kernel, and windows for other VMs created by hcstool.")
+	c.dryRun = fs.Bool("dry-run", false, "Print the request instead of sending it.")
+	c.path = fs.String("path", "", "disk, dir, vpmem: path to mount at in the guest. layers: container root path in the guest.")
+	c.readOnly = fs.Bool("ro", false, "disk, dir: mount read-only.")
+	c.controller = fs.Uint("controller", 0, "disk: SCSI controller index the disk is attached to. Linux guests only.")
+	c.lun = fs.Int("lun", -1, "disk: SCSI LUN the disk is attached to.")
+	c.options = fs.String("opt", "", "disk: comma separated mount options. Linux guests only.")
+	c.share = fs.String("share", "", "dir: access name of the Plan9 share to mount. Linux guests only.")
+	c.port = fs.Int("port", plan9Port, "dir: Plan9 port. Linux guests only.")
+	c.host = fs.String("host", "", "dir: host path of the directory. Windows guests only.")
+	c.container = fs.String("container", "", "layers: ID of the container the layers are for. Linux guests only.")
+	c.layers = nil
+	fs.Var(&c.layers, "layer", "layers: guest path of a read-only layer, topmost first. Can be repeated.")
+	c.scratch = fs.String("scratch", "", "layers: guest path of the scratch layer.")
+	c.adapter = fs.String("adapter", "", "network: ID of the network adapter.")
+	c.namespace = fs.String("ns", "", "network: ID of the network namespace to put the adapter in. Linux guests only.")
+	c.endpoint = fs.String("endpoint", "", "network: ID of the HNS endpoint of the adapter. Windows guests only.")
+	c.mac = fs.String("mac", "", "network: MAC address of the adapter.")
+	c.ip = fs.String("ip", "", "network: address and prefix length of the adapter, such as 10.0.0.2/24. Linux guests only.")
+	c.gateway = fs.String("gateway", "", "network: default gateway address. Linux guests only.")
+	c.dns = fs.String("dns", "", "network: comma separated DNS server addresses. Linux guests only.")
+	c.dnsSuffix = fs.String("dnssuffix", "", "network: DNS suffix. Linux guests only.")
+	c.device = fs.Uint("device", 0, "vpmem: VPMem device number.")
+	c.offset = fs.Uint64("offset", 0, "vpmem: offset of the image in the device, for images mapped with pmem map.")
+	c.size = fs.String("size", "", "vpmem: size of the image in the device, such as 512M, for images mapped with pmem map.")
+}
+
+func (c *guestModifyCommand) Execute(state *state, fs *flag.FlagSet) error {
+	op, err := parseSubcommand(fs, "add", "remove", "update")
+	if err != nil {
+		return err
+	}
+	kind, err := parseSubcommand(fs, sortedKeys(guestResources)...)
+	if err != nil {
+		return err
+	}
+	var badFlag string
+	fs.Visit(func(f *flag.Flag) {
+		if !contains(guestCommonFlags, f.Name) && !contains(guestResources[kind], f.Name) {
+			badFlag = f.Name
+		}
+	})
+	if badFlag != "" {
+		return fmt.Errorf("-%s does not apply to %s requests", badFlag, kind)
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	linux, err := c.linuxGuest(id, cs)
+	if err != nil {
+		return err
+	}
+	requestType := strings.ToUpper(op[:1]) + op[1:]
+
+	var resourceType hcsschema.GuestResourceType
+	var settings any
+	switch kind {
+	case "disk":
+		resourceType, settings, err = c.mappedVirtualDisk(linux)
+	case "dir":
+		resourceType, settings, err = c.mappedDirectory(linux)
+	case "layers":
+		resourceType, settings, err = c.combinedLayers(linux, requestType)
+	case "network":
+		resourceType, settings, err = c.networkAdapter(linux, requestType)
+	case "vpmem":
+		resourceType, settings, err = c.mappedVPMemDevice(linux)
+	case "raw":
+		resourceType = hcsschema.GuestResourceType(fs.Arg(0))
+		if resourceType == "" || !json.Valid([]byte(fs.Arg(1))) {
+			return fmt.Errorf("raw requests take a resource type and JSON settings")
+		}
+		settings = json.RawMessage(fs.Arg(1))
+	}
+	if err != nil {
+		return err
+	}
+	req := guestRequest(requestType, resourceType, settings)
+	if *c.dryRun {
+		j, err := json.MarshalIndent(req, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", j)
+		return nil
+	}
+	return sendModify(state, &c.cf, cs, req)
+}
+
+// guestRequest wraps settings for a guest resource in the envelope HCS
+// passes to the guest. It has no resource path, so HCS changes nothing on
+// the host.
+func guestRequest(requestType string, resourceType hcsschema.GuestResourceType, settings any) hcsschema.ModifySettingRequest {
+	return hcsschema.ModifySettingRequest{
+		GuestRequest: hcsschema.GuestModificationRequest{
+			ResourceType: resourceType,
+			RequestType:  requestType,
+			Settings:     settings,
+		},
+	}
+}
+
+// linuxGuest reports whether the guest of cs runs Linux, from -os or the
+// document cs was created with.
+func (c *guestModifyCommand) linuxGuest(id string, cs *cs) (bool, error) {
+	if *c.os != "" {
+		guestOS, err := oneOf("os", *c.os, "linux", "windows")
+		return guestOS == "linux", err
+	}
+	if cs.config == nil {
+		return false, fmt.Errorf("the guest OS of %s is not known, as it was not created by hcstool; use -os", id)
+	}
+	vm := cs.config.VirtualMachine
+	if vm == nil {
+		return false, fmt.Errorf("%s is not a virtual machine", id)
+	}
+	return vm.Chipset != nil && vm.Chipset.LinuxKernelDirect != nil, nil
+}
+
+func (c *guestModifyCommand) mappedVirtualDisk(linux bool) (hcsschema.GuestResourceType, any, error) {
+	if *c.lun < 0 || *c.lun > 255 {
+		return "", nil, fmt.Errorf("-lun is required, from 0 to 255")
+	}
+	if *c.path == "" {
+		return "", nil, fmt.Errorf("-path is required")
+	}
+	if !linux {
+		if *c.readOnly || *c.options != "" || *c.controller != 0 {
+			return "", nil, fmt.Errorf("-ro, -opt and -controller are only supported by Linux guests")
+		}
+		return hcsschema.GuestResourceTypeMappedVirtualDisk, hcsschema.WCOWMappedVirtualDisk{
+			ContainerPath: *c.path,
+			Lun:           int32(*c.lun),
+		}, nil
+	}
+	if *c.controller > 255 {
+		return "", nil, fmt.Errorf("-controller must be below 256")
+	}
+	disk := hcsschema.LCOWMappedVirtualDisk{
+		MountPath:  *c.path,
+		Lun:        uint8(*c.lun),
+		Controller: uint8(*c.controller),
+		ReadOnly:   *c.readOnly,
+	}
+	if *c.options != "" {
+		disk.Options = strings.Split(*c.options, ",")
+	}
+	return hcsschema.GuestResourceTypeMappedVirtualDisk, disk, nil
+}
+
+func (c *guestModifyCommand) mappedDirectory(linux bool) (hcsschema.GuestResourceType, any, error) {
+	if *c.path == "" {
+		return "", nil, fmt.Errorf("-path is required")
+	}
+	if !linux {
+		if *c.host == "" {
+			return "", nil, fmt.Errorf("-host is required for Windows guests")
+		}
+		return hcsschema.GuestResourceTypeMappedDirectory, hcsschema.MappedDirectory{
+			HostPath:      *c.host,
+			ContainerPath: *c.path,
+			ReadOnly:      *c.readOnly,
+		}, nil
+	}
+	if *c.host != "" {
+		return "", nil, fmt.Errorf("-host is only supported by Windows guests; Linux guests mount a Plan9 share by -share")
+	}
+	return hcsschema.GuestResourceTypeMappedDirectory, hcsschema.LCOWMappedDirectory{
+		MountPath: *c.path,
+		Port:      int32(*c.port),
+		ShareName: *c.share,
+		ReadOnly:  *c.readOnly,
+	}, nil
+}
+
+func (c *guestModifyCommand) combinedLayers(linux bool, requestType string) (hcsschema.GuestResourceType, any, error) {
+	if *c.path == "" {
+		return "", nil, fmt.Errorf("-path is required")
+	}
+	if requestType == "Add" && len(c.layers) == 0 {
+		return "", nil, fmt.Errorf("at least one -layer is required")
+	}
+	var layers []hcsschema.Layer
+	for _, l := range c.layers {
+		layers = append(layers, hcsschema.Layer{Path: l})
+	}
+	if !linux {
+		if *c.container != "" {
+			return "", nil, fmt.Errorf("-container is only supported by Linux guests")
+		}
+		return hcsschema.GuestResourceTypeCombinedLayers, hcsschema.WCOWCombinedLayers{
+			ContainerRootPath: *c.path,
+			Layers:            layers,
+			ScratchPath:       *c.scratch,
+		}, nil
+	}
+	return hcsschema.GuestResourceTypeCombinedLayers, hcsschema.LCOWCombinedLayers{
+		ContainerId:       *c.container,
+		ContainerRootPath: *c.path,
+		Layers:            layers,
+		ScratchPath:       *c.scratch,
+	}, nil
+}
+
+func (c *guestModifyCommand) networkAdapter(linux bool, requestType string) (hcsschema.GuestResourceType, any, error) {
+	if *c.adapter == "" {
+		return "", nil, fmt.Errorf("-adapter is required")
+	}
+	if !linux {
+		if *c.namespace != "" || *c.ip != "" || *c.gateway != "" || *c.dns != "" || *c.dnsSuffix != "" {
+			return "", nil, fmt.Errorf("-ns, -ip, -gateway, -dns and -dnssuffix are only supported by Linux guests")
+		}
+		req := hcsschema.WCOWNetworkModifyRequest{
+			AdapterId:   *c.adapter,
+			RequestType: requestType,
+		}
+		if requestType != "Remove" {
+			req.Settings = &hcsschema.NetworkAdapter{
+				EndpointId: *c.endpoint,
+				MacAddress: *c.mac,
+			}
+		}
+		return hcsschema.GuestResourceTypeNetwork, req, nil
+	}
+	if *c.endpoint != "" {
+		return "", nil, fmt.Errorf("-endpoint is only supported by Windows guests")
+	}
+	if *c.namespace == "" {
+		return "", nil, fmt.Errorf("-ns is required for Linux guests")
+	}
+	adapter := hcsschema.LCOWNetworkAdapter{
+		NamespaceID:    *c.namespace,
+		ID:             *c.adapter,
+		MacAddress:     *c.mac,
+		GatewayAddress: *c.gateway,
+		DNSSuffix:      *c.dnsSuffix,
+		DNSServerList:  *c.dns,
+	}
+	if *c.ip != "" {
+		prefix, err := netip.ParsePrefix(*c.ip)
+		if err != nil {
+			return "", nil, fmt.Errorf("-ip: %w", err)
+		}
+		adapter.IPAddress = prefix.Addr().String()
+		adapter.PrefixLength = uint8(prefix.Bits())
+	}
+	if *c.gateway != "" {
+		if _, err := netip.ParseAddr(*c.gateway); err != nil {
+			return "", nil, fmt.Errorf("-gateway: %w", err)
+		}
+	}
+	return hcsschema.GuestResourceTypeNetwork, adapter, nil
+}
+
+func (c *guestModifyCommand) mappedVPMemDevice(linux bool) (hcsschema.GuestResourceType, any, error) {
+	if !linux {
+		return "", nil, fmt.Errorf("VPMem mounts are only supported by Linux guests")
+	}
+	if *c.path == "" {
+		return "", nil, fmt.Errorf("-path is required")
+	}
+	dev := hcsschema.LCOWMappedVPMemDevice{
+		DeviceNumber: uint32(*c.device),
+		MountPath:    *c.path,
+	}
+	if *c.size != "" {
+		size, err := parseSize(*c.size)
+		if err != nil {
+			return "", nil, fmt.Errorf("-size: %w", err)
+		}
+		dev.MappingInfo = &hcsschema.LCOWVPMemMappingInfo{
+			DeviceOffsetInBytes: *c.offset,
+			DeviceSizeInBytes:   size,
+		}
+	} else if *c.offset != 0 {
+		return "", nil, fmt.Errorf("-offset requires -size")
+	}
+	return hcsschema.GuestResourceTypeVPMemDevice, dev, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// vsockServiceSuffix is the part of the well-known service GUID template
+// that follows the port, for services that Linux guests reach as vsock ports.
+const vsockServiceSuffix = "-facb-11e6-bd58-64006a7986d3"
+
+// vsockServiceID returns the service GUID of a vsock port.
+func vsockServiceID(port uint32) string {
+	return fmt.Sprintf("%08x%s", port, vsockServiceSuffix)
+}
+
+// vsockPort returns the vsock port of a service GUID, if it follows the
+// template.
+func vsockPort(id string) (uint32, bool) {
+	id = strings.ToLower(id)
+	if len(id) != 36 || !strings.HasSuffix(id, vsockServiceSuffix) {
+		return 0, false
+	}
+	port, err := strconv.ParseUint(id[:8], 16, 32)
+	return uint32(port), err == nil
+}
+
+// parseHvSocketService accepts a service GUID, or a vsock port number.
+func parseHvSocketService(s string) (string, error) {
+	if port, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return vsockServiceID(uint32(port)), nil
+	}
+	if !guidPattern.MatchString(s) {
+		return "", fmt.Errorf("%q is neither a service GUID nor a vsock port", s)
+	}
+	return strings.ToLower(s), nil
+}
+
+type hvsockCommand struct {
+	cf       commonFlags
+	bind     *string
+	connect  *string
+	wildcard *bool
+	disabled *bool
+}
+
+func (c *hvsockCommand) Name() string { return "hvsock" }
+func (c *hvsockCommand) Description() string {
+	return "Adds, removes or lists HvSocket services of a VM."
+}
+func (c *hvsockCommand) ArgHelp() string { return "add|remove SERVICE|list" }
+func (c *hvsockCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.bind = fs.String("bind", "", "SDDL that host processes must pass to bind to the service.")
+	c.connect = fs.String("connect", "", "SDDL that host processes must pass to connect to the service.")
+	c.wildcard = fs.Bool("wildcard", false, "Allow wildcard binds for the service.")
+	c.disabled = fs.Bool("disabled", false, "Add the service disabled, refusing connections.")
+}
+
+func (c *hvsockCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sub, err := parseSubcommand(fs, "add", "remove", "list")
+	if err != nil {
+		return err
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	vm, err := vmConfig(id, cs)
+	if err != nil {
+		return err
+	}
+	if sub == "list" {
+		printTracked(id)
+		return c.list(vm)
+	}
+	if fs.Arg(0) == "" {
+		return fmt.Errorf("a service GUID or vsock port is required")
+	}
+	service, err := parseHvSocketService(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var table map[string]hcsschema.HvSocketServiceConfig
+	if h := vm.Devices.HvSocket; h != nil && h.HvSocketConfig != nil {
+		table = h.HvSocketConfig.ServiceTable
+	}
+	// Documents may spell service GUIDs in either case.
+	for key := range table {
+		if strings.EqualFold(key, service) {
+			service = key
+		}
+	}
+	_, exists := table[service]
+	switch sub {
+	case "add":
+		if exists {
+			return fmt.Errorf("%s already has HvSocket service %s", id, service)
+		}
+		for _, f := range []struct{ name, sddl string }{{"bind", *c.bind}, {"connect", *c.connect}} {
+			if f.sddl == "" {
+				continue
+			}
+			if err := checkSDDL(f.sddl); err != nil {
+				return fmt.Errorf("-%s: %w", f.name, err)
+			}
+		}
+		config := hcsschema.HvSocketServiceConfig{
+			BindSecurityDescriptor:    *c.bind,
+			ConnectSecurityDescriptor: *c.connect,
+			AllowWildcardBinds:        *c.wildcard,
+			Disabled:                  *c.disabled,
+		}
+		// HCS adds services to the table by updating them.
+		if err := modifySystem(state, &c.cf, cs, "Update", hvsockResourcePath(service), config); err != nil {
+			return err
+		}
+		if vm.Devices.HvSocket == nil {
+			vm.Devices.HvSocket = &hcsschema.HvSocket2{}
+		}
+		if vm.Devices.HvSocket.HvSocketConfig == nil {
+			vm.Devices.HvSocket.HvSocketConfig = &hcsschema.HvSocketSystemConfig{}
+		}
+		if vm.Devices.HvSocket.HvSocketConfig.ServiceTable == nil {
+			vm.Devices.HvSocket.HvSocketConfig.ServiceTable = make(map[string]hcsschema.HvSocketServiceConfig)
+		}
+		vm.Devices.HvSocket.HvSocketConfig.ServiceTable[service] = config
+		fmt.Printf("added HvSocket service %s\n", describeHvSocketService(service))
+	case "remove":
+		if !exists {
+			return fmt.Errorf("%s has no HvSocket service %s", id, service)
+		}
+		if err := modifySystem(state, &c.cf, cs, "Remove", hvsockResourcePath(service), nil); err != nil {
+			return err
+		}
+		delete(table, service)
+	}
+	return nil
+}
+
+func (c *hvsockCommand) list(vm *hcsschema.VirtualMachine) error {
+	var table map[string]hcsschema.HvSocketServiceConfig
+	if h := vm.Devices.HvSocket; h != nil && h.HvSocketConfig != nil {
+		table = h.HvSocketConfig.ServiceTable
+	}
+	services := sortedKeys(table)
+	sort.SliceStable(services, func(i, j int) bool {
+		// Vsock services come first, by port.
+		pi, okI := vsockPort(services[i])
+		pj, okJ := vsockPort(services[j])
+		if okI != okJ || !okI {
+			return okI && !okJ
+		}
+		return pi < pj
+	})
+	return printTable(
+		[]colInfo{{"SERVICE", "%s"}, {"PORT", "%s"}, {"FLAGS", "%s"}, {"BIND", "%s"}, {"CONNECT", "%s"}},
+		services,
+		func(s string) []any {
+			config := table[s]
+			port := ""
+			if p, ok := vsockPort(s); ok {
+				port = strconv.FormatUint(uint64(p), 10)
+			}
+			var flags []string
+			if config.AllowWildcardBinds {
+				flags = append(flags, "wildcard")
+			}
+			if config.Disabled {
+				flags = append(flags, "disabled")
+			}
+			return []any{s, port, strings.Join(flags, ","), config.BindSecurityDescriptor, config.ConnectSecurityDescriptor}
+		},
+	)
+}
+
+func describeHvSocketService(service string) string {
+	if port, ok := vsockPort(service); ok {
+		return fmt.Sprintf("%s (vsock port %d)", service, port)
+	}
+	return service
+}
+
+func hvsockResourcePath(service string) string {
+	return "VirtualMachine/Devices/HvSocket/HvSocketConfig/ServiceTable/" + service
+}
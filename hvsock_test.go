@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestVsockServiceID(t *testing.T) {
+	for _, tc := range []struct {
+		port uint32
+		id   string
+	}{
+		{0, "00000000-facb-11e6-bd58-64006a7986d3"},
+		{5000, "00001388-facb-11e6-bd58-64006a7986d3"},
+		{0xffffffff, "ffffffff-facb-11e6-bd58-64006a7986d3"},
+	} {
+		if id := vsockServiceID(tc.port); id != tc.id {
+			t.Errorf("vsockServiceID(%d) = %s, want %s", tc.port, id, tc.id)
+		}
+		if port, ok := vsockPort(tc.id); !ok || port != tc.port {
+			t.Errorf("vsockPort(%s) = %d, %v, want %d", tc.id, port, ok, tc.port)
+		}
+	}
+}
+
+func TestVsockPort(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		port uint32
+		ok   bool
+	}{
+		{"00001388-FACB-11E6-BD58-64006A7986D3", 5000, true},
+		{"00001388-facb-11e6-bd58-64006a7986d4", 0, false},
+		{"0001388-facb-11e6-bd58-64006a7986d3", 0, false},
+		{"zzzzzzzz-facb-11e6-bd58-64006a7986d3", 0, false},
+		{"", 0, false},
+	} {
+		port, ok := vsockPort(tc.id)
+		if port != tc.port || ok != tc.ok {
+			t.Errorf("vsockPort(%s) = %d, %v, want %d, %v", tc.id, port, ok, tc.port, tc.ok)
+		}
+	}
+}
@@ -1,3 +1,5 @@
+//go:build windows
+
 package computecore
 
 import (
@@ -43,8 +45,20 @@ import (
 //sys HcsSetComputeSystemCallback(cs HCS_SYSTEM, options HCS_EVENT_OPTIONS, context uintptr, callback uintptr) (hr error) = computecore.HcsSetComputeSystemCallback
 //sys HcsEnumerateComputeSystems(query string, op HCS_OPERATION) (hr error) = computecore.HcsEnumerateComputeSystems
 
+// Processes
+//sys HcsCreateProcess(cs HCS_SYSTEM, processParameters string, op HCS_OPERATION, sd *windows.SECURITY_DESCRIPTOR, proc *HCS_PROCESS) (hr error) = computecore.HcsCreateProcess
+//sys HcsOpenProcess(cs HCS_SYSTEM, pid uint32, access uint32, proc *HCS_PROCESS) (hr error) = computecore.HcsOpenProcess
+//sys HcsCloseProcess(proc HCS_PROCESS) () = computecore.HcsCloseProcess
+//sys HcsTerminateProcess(proc HCS_PROCESS, op HCS_OPERATION, options string) (hr error) = computecore.HcsTerminateProcess
+//sys HcsSignalProcess(proc HCS_PROCESS, op HCS_OPERATION, options string) (hr error) = computecore.HcsSignalProcess
+//sys HcsGetProcessInfo(proc HCS_PROCESS, op HCS_OPERATION) (hr error) = computecore.HcsGetProcessInfo
+//sys HcsGetProcessProperties(proc HCS_PROCESS, op HCS_OPERATION, propertyQuery string) (hr error) = computecore.HcsGetProcessProperties
+//sys HcsModifyProcess(proc HCS_PROCESS, op HCS_OPERATION, settings string) (hr error) = computecore.HcsModifyProcess
+//sys HcsSetProcessCallback(proc HCS_PROCESS, options HCS_EVENT_OPTIONS, context uintptr, callback uintptr) (hr error) = computecore.HcsSetProcessCallback
+
 // Service
 //sys HcsGetServiceProperties(query string, result **uint16) (hr error) = computecore.HcsGetServiceProperties
+//sys HcsModifyServiceSettings(settings string, result **uint16) (hr error) = computecore.HcsModifyServiceSettings
 
 // Utility
 //sys HcsGrantVmAccess(vmID string, path string) (hr error) = computecore.HcsGrantVmAccess
@@ -168,6 +182,21 @@ func (op HCS_OPERATION) WaitResult(timeoutMS uint32) (string, error) {
 	return s, nil
 }
 
+func (op HCS_OPERATION) WaitResultAndProcessInfo(timeoutMS uint32) (string, HCS_PROCESS_INFORMATION, error) {
+	var (
+		result   *uint16
+		procInfo HCS_PROCESS_INFORMATION
+	)
+	if err := HcsWaitForOperationResultAndProcessInfo(op, timeoutMS, &procInfo, &result); err != nil {
+		return "", procInfo, err
+	}
+	s, err := convertResult(result)
+	if err != nil {
+		return "", procInfo, err
+	}
+	return s, procInfo, nil
+}
+
 func convertResult(result *uint16) (string, error) {
 	s := windows.UTF16PtrToString(result)
 	if _, err := syscall.LocalFree(syscall.Handle(unsafe.Pointer(result))); err != nil {
@@ -175,3 +204,23 @@ func convertResult(result *uint16) (string, error) {
 	}
 	return s, nil
 }
+
+func GetServiceProperties(query string) (string, error) {
+	var result *uint16
+	if err := HcsGetServiceProperties(query, &result); err != nil {
+		return "", err
+	}
+	return convertResult(result)
+}
+
+func ModifyServiceSettings(settings string) error {
+	var result *uint16
+	err := HcsModifyServiceSettings(settings, &result)
+	if result != nil {
+		// The result only carries details of a failure, which err covers.
+		if _, freeErr := convertResult(result); err == nil {
+			err = freeErr
+		}
+	}
+	return err
+}
@@ -43,9 +43,11 @@ var (
 	procHcsCancelOperation                      = modcomputecore.NewProc("HcsCancelOperation")
 	procHcsCloseComputeSystem                   = modcomputecore.NewProc("HcsCloseComputeSystem")
 	procHcsCloseOperation                       = modcomputecore.NewProc("HcsCloseOperation")
+	procHcsCloseProcess                         = modcomputecore.NewProc("HcsCloseProcess")
 	procHcsCrashComputeSystem                   = modcomputecore.NewProc("HcsCrashComputeSystem")
 	procHcsCreateComputeSystem                  = modcomputecore.NewProc("HcsCreateComputeSystem")
 	procHcsCreateOperation                      = modcomputecore.NewProc("HcsCreateOperation")
+	procHcsCreateProcess                        = modcomputecore.NewProc("HcsCreateProcess")
 	procHcsEnumerateComputeSystems              = modcomputecore.NewProc("HcsEnumerateComputeSystems")
 	procHcsFinalizeLiveMigration                = modcomputecore.NewProc("HcsFinalizeLiveMigration")
 	procHcsGetComputeSystemFromOperation        = modcomputecore.NewProc("HcsGetComputeSystemFromOperation")
@@ -56,22 +58,30 @@ var (
 	procHcsGetOperationResultAndProcessInfo     = modcomputecore.NewProc("HcsGetOperationResultAndProcessInfo")
 	procHcsGetOperationType                     = modcomputecore.NewProc("HcsGetOperationType")
 	procHcsGetProcessFromOperation              = modcomputecore.NewProc("HcsGetProcessFromOperation")
+	procHcsGetProcessInfo                       = modcomputecore.NewProc("HcsGetProcessInfo")
+	procHcsGetProcessProperties                 = modcomputecore.NewProc("HcsGetProcessProperties")
 	procHcsGetServiceProperties                 = modcomputecore.NewProc("HcsGetServiceProperties")
 	procHcsGrantVmAccess                        = modcomputecore.NewProc("HcsGrantVmAccess")
 	procHcsInitializeLiveMigrationOnSource      = modcomputecore.NewProc("HcsInitializeLiveMigrationOnSource")
 	procHcsModifyComputeSystem                  = modcomputecore.NewProc("HcsModifyComputeSystem")
+	procHcsModifyProcess                        = modcomputecore.NewProc("HcsModifyProcess")
+	procHcsModifyServiceSettings                = modcomputecore.NewProc("HcsModifyServiceSettings")
 	procHcsOpenComputeSystem                    = modcomputecore.NewProc("HcsOpenComputeSystem")
+	procHcsOpenProcess                          = modcomputecore.NewProc("HcsOpenProcess")
 	procHcsPauseComputeSystem                   = modcomputecore.NewProc("HcsPauseComputeSystem")
 	procHcsResumeComputeSystem                  = modcomputecore.NewProc("HcsResumeComputeSystem")
 	procHcsSaveComputeSystem                    = modcomputecore.NewProc("HcsSaveComputeSystem")
 	procHcsSetComputeSystemCallback             = modcomputecore.NewProc("HcsSetComputeSystemCallback")
 	procHcsSetOperationCallback                 = modcomputecore.NewProc("HcsSetOperationCallback")
 	procHcsSetOperationContext                  = modcomputecore.NewProc("HcsSetOperationContext")
+	procHcsSetProcessCallback                   = modcomputecore.NewProc("HcsSetProcessCallback")
 	procHcsShutDownComputeSystem                = modcomputecore.NewProc("HcsShutDownComputeSystem")
+	procHcsSignalProcess                        = modcomputecore.NewProc("HcsSignalProcess")
 	procHcsStartComputeSystem                   = modcomputecore.NewProc("HcsStartComputeSystem")
 	procHcsStartLiveMigrationOnSource           = modcomputecore.NewProc("HcsStartLiveMigrationOnSource")
 	procHcsStartLiveMigrationTransfer           = modcomputecore.NewProc("HcsStartLiveMigrationTransfer")
 	procHcsTerminateComputeSystem               = modcomputecore.NewProc("HcsTerminateComputeSystem")
+	procHcsTerminateProcess                     = modcomputecore.NewProc("HcsTerminateProcess")
 	procHcsWaitForOperationResult               = modcomputecore.NewProc("HcsWaitForOperationResult")
 	procHcsWaitForOperationResultAndProcessInfo = modcomputecore.NewProc("HcsWaitForOperationResultAndProcessInfo")
 )
@@ -117,6 +127,11 @@ func HcsCloseOperation(op HCS_OPERATION) {
 	return
 }
 
+func HcsCloseProcess(proc HCS_PROCESS) {
+	syscall.SyscallN(procHcsCloseProcess.Addr(), uintptr(proc))
+	return
+}
+
 func HcsCrashComputeSystem(cs HCS_SYSTEM, op HCS_OPERATION, options string) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(options)
@@ -168,6 +183,26 @@ func HcsCreateOperation(context uintptr, callback uintptr) (op HCS_OPERATION) {
 	return
 }
 
+func HcsCreateProcess(cs HCS_SYSTEM, processParameters string, op HCS_OPERATION, sd *windows.SECURITY_DESCRIPTOR, proc *HCS_PROCESS) (hr error) {
+	var _p0 *uint16
+	_p0, hr = syscall.UTF16PtrFromString(processParameters)
+	if hr != nil {
+		return
+	}
+	return _HcsCreateProcess(cs, _p0, op, sd, proc)
+}
+
+func _HcsCreateProcess(cs HCS_SYSTEM, processParameters *uint16, op HCS_OPERATION, sd *windows.SECURITY_DESCRIPTOR, proc *HCS_PROCESS) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsCreateProcess.Addr(), uintptr(cs), uintptr(unsafe.Pointer(processParameters)), uintptr(op), uintptr(unsafe.Pointer(sd)), uintptr(unsafe.Pointer(proc)))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsEnumerateComputeSystems(query string, op HCS_OPERATION) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(query)
@@ -280,6 +315,37 @@ func HcsGetProcessFromOperation(op HCS_OPERATION) (proc HCS_PROCESS) {
 	return
 }
 
+func HcsGetProcessInfo(proc HCS_PROCESS, op HCS_OPERATION) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsGetProcessInfo.Addr(), uintptr(proc), uintptr(op))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
+func HcsGetProcessProperties(proc HCS_PROCESS, op HCS_OPERATION, propertyQuery string) (hr error) {
+	var _p0 *uint16
+	_p0, hr = syscall.UTF16PtrFromString(propertyQuery)
+	if hr != nil {
+		return
+	}
+	return _HcsGetProcessProperties(proc, op, _p0)
+}
+
+func _HcsGetProcessProperties(proc HCS_PROCESS, op HCS_OPERATION, propertyQuery *uint16) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsGetProcessProperties.Addr(), uintptr(proc), uintptr(op), uintptr(unsafe.Pointer(propertyQuery)))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsGetServiceProperties(query string, result **uint16) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(query)
@@ -300,6 +366,26 @@ func _HcsGetServiceProperties(query *uint16, result **uint16) (hr error) {
 	return
 }
 
+func HcsModifyServiceSettings(settings string, result **uint16) (hr error) {
+	var _p0 *uint16
+	_p0, hr = syscall.UTF16PtrFromString(settings)
+	if hr != nil {
+		return
+	}
+	return _HcsModifyServiceSettings(_p0, result)
+}
+
+func _HcsModifyServiceSettings(settings *uint16, result **uint16) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsModifyServiceSettings.Addr(), uintptr(unsafe.Pointer(settings)), uintptr(unsafe.Pointer(result)))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsGrantVmAccess(vmID string, path string) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(vmID)
@@ -365,6 +451,26 @@ func _HcsModifyComputeSystem(cs HCS_SYSTEM, op HCS_OPERATION, config *uint16, id
 	return
 }
 
+func HcsModifyProcess(proc HCS_PROCESS, op HCS_OPERATION, settings string) (hr error) {
+	var _p0 *uint16
+	_p0, hr = syscall.UTF16PtrFromString(settings)
+	if hr != nil {
+		return
+	}
+	return _HcsModifyProcess(proc, op, _p0)
+}
+
+func _HcsModifyProcess(proc HCS_PROCESS, op HCS_OPERATION, settings *uint16) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsModifyProcess.Addr(), uintptr(proc), uintptr(op), uintptr(unsafe.Pointer(settings)))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsOpenComputeSystem(id string, access uint32, cs *HCS_SYSTEM) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(id)
@@ -385,6 +491,17 @@ func _HcsOpenComputeSystem(id *uint16, access uint32, cs *HCS_SYSTEM) (hr error)
 	return
 }
 
+func HcsOpenProcess(cs HCS_SYSTEM, pid uint32, access uint32, proc *HCS_PROCESS) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsOpenProcess.Addr(), uintptr(cs), uintptr(pid), uintptr(access), uintptr(unsafe.Pointer(proc)))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsPauseComputeSystem(cs HCS_SYSTEM, op HCS_OPERATION, options string) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(options)
@@ -478,6 +595,17 @@ func HcsSetOperationContext(op HCS_OPERATION, context uintptr) (hr error) {
 	return
 }
 
+func HcsSetProcessCallback(proc HCS_PROCESS, options HCS_EVENT_OPTIONS, context uintptr, callback uintptr) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsSetProcessCallback.Addr(), uintptr(proc), uintptr(options), uintptr(context), uintptr(callback))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsShutDownComputeSystem(cs HCS_SYSTEM, op HCS_OPERATION, options string) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(options)
@@ -498,6 +626,26 @@ func _HcsShutDownComputeSystem(cs HCS_SYSTEM, op HCS_OPERATION, options *uint16)
 	return
 }
 
+func HcsSignalProcess(proc HCS_PROCESS, op HCS_OPERATION, options string) (hr error) {
+	var _p0 *uint16
+	_p0, hr = syscall.UTF16PtrFromString(options)
+	if hr != nil {
+		return
+	}
+	return _HcsSignalProcess(proc, op, _p0)
+}
+
+func _HcsSignalProcess(proc HCS_PROCESS, op HCS_OPERATION, options *uint16) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsSignalProcess.Addr(), uintptr(proc), uintptr(op), uintptr(unsafe.Pointer(options)))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsStartComputeSystem(cs HCS_SYSTEM, op HCS_OPERATION, options string) (hr error) {
 	var _p0 *uint16
 	_p0, hr = syscall.UTF16PtrFromString(options)
@@ -578,6 +726,26 @@ func _HcsTerminateComputeSystem(cs HCS_SYSTEM, op HCS_OPERATION, options *uint16
 	return
 }
 
+func HcsTerminateProcess(proc HCS_PROCESS, op HCS_OPERATION, options string) (hr error) {
+	var _p0 *uint16
+	_p0, hr = syscall.UTF16PtrFromString(options)
+	if hr != nil {
+		return
+	}
+	return _HcsTerminateProcess(proc, op, _p0)
+}
+
+func _HcsTerminateProcess(proc HCS_PROCESS, op HCS_OPERATION, options *uint16) (hr error) {
+	r0, _, _ := syscall.SyscallN(procHcsTerminateProcess.Addr(), uintptr(proc), uintptr(op), uintptr(unsafe.Pointer(options)))
+	if int32(r0) < 0 {
+		if r0&0x1fff0000 == 0x00070000 {
+			r0 &= 0xffff
+		}
+		hr = syscall.Errno(r0)
+	}
+	return
+}
+
 func HcsWaitForOperationResult(op HCS_OPERATION, timeoutMS uint32, result **uint16) (hr error) {
 	r0, _, _ := syscall.SyscallN(procHcsWaitForOperationResult.Addr(), uintptr(op), uintptr(timeoutMS), uintptr(unsafe.Pointer(result)))
 	if int32(r0) < 0 {
@@ -0,0 +1,297 @@
+package hcs
+
+import (
+	"os"
+	"sync"
+
+	"github.com/kevpar/hcstool/internal/computecore"
+	"golang.org/x/sys/windows"
+)
+
+// ComputeCore is a Backend that calls into computecore.dll.
+type ComputeCore struct{}
+
+var _ Backend = &ComputeCore{}
+
+func NewComputeCore() *ComputeCore {
+	return &ComputeCore{}
+}
+
+func (b *ComputeCore) NewOperation() Operation {
+	return operation(computecore.NewOperation(0))
+}
+
+func (b *ComputeCore) CreateComputeSystem(id string, config string, op Operation) (System, error) {
+	s := &system{id: id}
+	if err := computecore.HcsCreateComputeSystem(id, config, op.(operation).handle(), nil, &s.handle); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *ComputeCore) OpenComputeSystem(id string) (System, error) {
+	s := &system{id: id}
+	if err := computecore.HcsOpenComputeSystem(id, windows.GENERIC_ALL, &s.handle); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (b *ComputeCore) EnumerateComputeSystems(query string, op Operation) error {
+	return computecore.HcsEnumerateComputeSystems(query, op.(operation).handle())
+}
+
+func (b *ComputeCore) GetServiceProperties(query string) (string, error) {
+	return computecore.GetServiceProperties(query)
+}
+
+func (b *ComputeCore) ModifyServiceSettings(settings string) error {
+	return computecore.ModifyServiceSettings(settings)
+}
+
+func (b *ComputeCore) GrantVmAccess(vmID string, path string) error {
+	return computecore.HcsGrantVmAccess(vmID, path)
+}
+
+type system struct {
+	id      string
+	handle  computecore.HCS_SYSTEM
+	context uintptr
+}
+
+func (s *system) ID() string { return s.id }
+
+func (s *system) Close() {
+	computecore.HcsCloseComputeSystem(s.handle)
+	s.handle = 0
+	if s.context != 0 {
+		unregisterCallback(s.context)
+	}
+}
+
+func (s *system) Start(op Operation, options string) error {
+	return computecore.HcsStartComputeSystem(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) Shutdown(op Operation, options string) error {
+	return computecore.HcsShutDownComputeSystem(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) Terminate(op Operation, options string) error {
+	return computecore.HcsTerminateComputeSystem(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) Crash(op Operation, options string) error {
+	return computecore.HcsCrashComputeSystem(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) Pause(op Operation, options string) error {
+	return computecore.HcsPauseComputeSystem(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) Resume(op Operation, options string) error {
+	return computecore.HcsResumeComputeSystem(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) Save(op Operation, options string) error {
+	return computecore.HcsSaveComputeSystem(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) GetProperties(op Operation, query string) error {
+	return computecore.HcsGetComputeSystemProperties(s.handle, op.(operation).handle(), query)
+}
+
+func (s *system) Modify(op Operation, config string) error {
+	return computecore.HcsModifyComputeSystem(s.handle, op.(operation).handle(), config, 0)
+}
+
+func (s *system) InitializeLiveMigrationOnSource(op Operation, options string) error {
+	return computecore.HcsInitializeLiveMigrationOnSource(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) StartLiveMigrationOnSource(op Operation, options string) error {
+	return computecore.HcsStartLiveMigrationOnSource(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) StartLiveMigrationTransfer(op Operation, options string) error {
+	return computecore.HcsStartLiveMigrationTransfer(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) FinalizeLiveMigration(op Operation, options string) error {
+	return computecore.HcsFinalizeLiveMigration(s.handle, op.(operation).handle(), options)
+}
+
+func (s *system) SetCallback(callback func(Event)) error {
+	context := registerCallback(callback)
+	if err := computecore.HcsSetComputeSystemCallback(s.handle, computecore.HcsEventOptionNone, context, eventCallback); err != nil {
+		unregisterCallback(context)
+		return err
+	}
+	s.context = context
+	return nil
+}
+
+func (s *system) CreateProcess(op Operation, params string) (Process, error) {
+	p := &process{}
+	if err := computecore.HcsCreateProcess(s.handle, params, op.(operation).handle(), nil, &p.handle); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *system) OpenProcess(pid uint32) (Process, error) {
+	p := &process{}
+	if err := computecore.HcsOpenProcess(s.handle, pid, windows.GENERIC_ALL, &p.handle); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+type process struct {
+	handle  computecore.HCS_PROCESS
+	context uintptr
+}
+
+func (p *process) Close() {
+	computecore.HcsCloseProcess(p.handle)
+	p.handle = 0
+	if p.context != 0 {
+		unregisterCallback(p.context)
+	}
+}
+
+func (p *process) Signal(op Operation, options string) error {
+	return computecore.HcsSignalProcess(p.handle, op.(operation).handle(), options)
+}
+
+func (p *process) Terminate(op Operation, options string) error {
+	return computecore.HcsTerminateProcess(p.handle, op.(operation).handle(), options)
+}
+
+func (p *process) Modify(op Operation, settings string) error {
+	return computecore.HcsModifyProcess(p.handle, op.(operation).handle(), settings)
+}
+
+func (p *process) GetProperties(op Operation, query string) error {
+	return computecore.HcsGetProcessProperties(p.handle, op.(operation).handle(), query)
+}
+
+func (p *process) SetCallback(callback func(Event)) error {
+	context := registerCallback(callback)
+	if err := computecore.HcsSetProcessCallback(p.handle, computecore.HcsEventOptionNone, context, eventCallback); err != nil {
+		unregisterCallback(context)
+		return err
+	}
+	p.context = context
+	return nil
+}
+
+// Callbacks from HCS all go through a single native callback, which looks up
+// the Go function to call using the callback context.
+var (
+	callbackMu   sync.Mutex
+	callbacks    = make(map[uintptr]func(Event))
+	nextCallback uintptr
+
+	eventCallback = windows.NewCallback(func(event *computecore.Event, context uintptr) uintptr {
+		callbackMu.Lock()
+		callback := callbacks[context]
+		callbackMu.Unlock()
+		if callback != nil {
+			callback(Event{Type: EventType(event.Type), Data: windows.UTF16PtrToString(event.EventData)})
+		}
+		return 0
+	})
+
+	// Operations are values rather than pointers, so the callback context for
+	// each is tracked here to be unregistered when the operation is closed.
+	operationContexts = make(map[computecore.HCS_OPERATION]uintptr)
+
+	operationCallback = windows.NewCallback(func(op computecore.HCS_OPERATION, context uintptr) uintptr {
+		callbackMu.Lock()
+		callback := callbacks[context]
+		callbackMu.Unlock()
+		if callback != nil {
+			callback(Event{Type: EventTypeOperationCallback})
+		}
+		return 0
+	})
+)
+
+func registerCallback(callback func(Event)) uintptr {
+	callbackMu.Lock()
+	defer callbackMu.Unlock()
+	nextCallback++
+	callbacks[nextCallback] = callback
+	return nextCallback
+}
+
+func unregisterCallback(context uintptr) {
+	callbackMu.Lock()
+	defer callbackMu.Unlock()
+	delete(callbacks, context)
+}
+
+type operation computecore.HCS_OPERATION
+
+func (op operation) handle() computecore.HCS_OPERATION { return computecore.HCS_OPERATION(op) }
+
+func (op operation) Close() {
+	op.handle().Close()
+	callbackMu.Lock()
+	context, ok := operationContexts[op.handle()]
+	delete(operationContexts, op.handle())
+	callbackMu.Unlock()
+	if ok {
+		unregisterCallback(context)
+	}
+}
+
+func (op operation) ID() uint64          { return op.handle().ID() }
+func (op operation) Type() OperationType { return OperationType(op.handle().Type()) }
+
+func (op operation) AddResource(typ ResourceType, uri string, handle uintptr) error {
+	return computecore.HcsAddResourceToOperation(op.handle(), computecore.HCS_RESOURCE_TYPE(typ), uri, handle)
+}
+
+func (op operation) SetCallback(callback func(Event)) error {
+	context := registerCallback(callback)
+	if err := computecore.HcsSetOperationCallback(op.handle(), context, operationCallback); err != nil {
+		unregisterCallback(context)
+		return err
+	}
+	callbackMu.Lock()
+	operationContexts[op.handle()] = context
+	callbackMu.Unlock()
+	return nil
+}
+
+func (op operation) Cancel() error {
+	return computecore.HcsCancelOperation(op.handle())
+}
+
+func (op operation) Result() (string, error) {
+	return op.handle().Result()
+}
+
+func (op operation) WaitResult(timeoutMS uint32) (string, error) {
+	return op.handle().WaitResult(timeoutMS)
+}
+
+func (op operation) WaitResultAndProcessInfo(timeoutMS uint32) (string, *ProcessInfo, error) {
+	result, pi, err := op.handle().WaitResultAndProcessInfo(timeoutMS)
+	if err != nil {
+		return "", nil, err
+	}
+	info := &ProcessInfo{ProcessID: pi.ProcessId}
+	if pi.StdInput != 0 {
+		info.Stdin = os.NewFile(uintptr(pi.StdInput), "stdin")
+	}
+	if pi.StdOutput != 0 {
+		info.Stdout = os.NewFile(uintptr(pi.StdOutput), "stdout")
+	}
+	if pi.StdError != 0 {
+		info.Stderr = os.NewFile(uintptr(pi.StdError), "stderr")
+	}
+	return result, info, nil
+}
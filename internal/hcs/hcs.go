@@ -0,0 +1,204 @@
+// Package hcs defines the interface hcstool uses to talk to the Host Compute
+// Service. The interface closely mirrors the computecore API, so that commands
+// can be written against it and run either on a real host or against the
+// in-memory simulator.
+package hcs
+
+import (
+	"fmt"
+	"io"
+)
+
+// Infinite can be passed as a timeout to wait without limit.
+const Infinite uint32 = 0xffffffff
+
+// Backend is the entry point for HCS functionality that is not tied to a
+// particular compute system.
+type Backend interface {
+	NewOperation() Operation
+	CreateComputeSystem(id string, config string, op Operation) (System, error)
+	OpenComputeSystem(id string) (System, error)
+	EnumerateComputeSystems(query string, op Operation) error
+	GetServiceProperties(query string) (string, error)
+	ModifyServiceSettings(settings string) error
+	GrantVmAccess(vmID string, path string) error
+}
+
+// System is a handle to a compute system.
+type System interface {
+	ID() string
+	Close()
+	Start(op Operation, options string) error
+	Shutdown(op Operation, options string) error
+	Terminate(op Operation, options string) error
+	Crash(op Operation, options string) error
+	Pause(op Operation, options string) error
+	Resume(op Operation, options string) error
+	Save(op Operation, options string) error
+	GetProperties(op Operation, query string) error
+	Modify(op Operation, config string) error
+	CreateProcess(op Operation, params string) (Process, error)
+	OpenProcess(pid uint32) (Process, error)
+	InitializeLiveMigrationOnSource(op Operation, options string) error
+	StartLiveMigrationOnSource(op Operation, options string) error
+	StartLiveMigrationTransfer(op Operation, options string) error
+	FinalizeLiveMigration(op Operation, options string) error
+	SetCallback(callback func(Event)) error
+}
+
+// Operation tracks an asynchronous HCS call. An operation is passed to a
+// single call, and then waited on for the result.
+type Operation interface {
+	Close()
+	ID() uint64
+	Type() OperationType
+	AddResource(typ ResourceType, uri string, handle uintptr) error
+	Cancel() error
+	// SetCallback registers a callback that is called with an
+	// EventTypeOperationCallback event when the operation completes. It must
+	// be called before the operation is started.
+	SetCallback(callback func(Event)) error
+	Result() (string, error)
+	WaitResult(timeoutMS uint32) (string, error)
+	WaitResultAndProcessInfo(timeoutMS uint32) (string, *ProcessInfo, error)
+}
+
+// Process is a handle to a process running in a compute system.
+type Process interface {
+	Close()
+	Signal(op Operation, options string) error
+	Terminate(op Operation, options string) error
+	Modify(op Operation, settings string) error
+	GetProperties(op Operation, query string) error
+	SetCallback(callback func(Event)) error
+}
+
+// ProcessInfo is returned when a process is created. Stdio pipes that were
+// not requested in the process parameters are nil.
+type ProcessInfo struct {
+	ProcessID uint32
+	Stdin     io.WriteCloser
+	Stdout    io.ReadCloser
+	Stderr    io.ReadCloser
+}
+
+type EventType int
+
+const (
+	EventTypeInvalid EventType = iota
+	EventTypeSystemExited
+	EventTypeSystemCrashInitiated
+	EventTypeSystemCrashReport
+	EventTypeSystemRdpEnhancedModeStateChanged
+	EventTypeSystemSiloJobCreated
+	EventTypeSystemGuestConnectionClosed
+
+	EventTypeProcessExited     EventType = 0x00010000
+	EventTypeOperationCallback EventType = 0x01000000
+	EventTypeServiceDisconnect EventType = 0x02000000
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventTypeInvalid:
+		return "Invalid"
+	case EventTypeSystemExited:
+		return "SystemExited"
+	case EventTypeSystemCrashInitiated:
+		return "SystemCrashInitiated"
+	case EventTypeSystemCrashReport:
+		return "SystemCrashReport"
+	case EventTypeSystemRdpEnhancedModeStateChanged:
+		return "SystemRdpEnhancedModeStateChanged"
+	case EventTypeSystemSiloJobCreated:
+		return "SystemSiloJobCreated"
+	case EventTypeSystemGuestConnectionClosed:
+		return "SystemGuestConnectionClosed"
+	case EventTypeProcessExited:
+		return "ProcessExited"
+	case EventTypeOperationCallback:
+		return "OperationCallback"
+	case EventTypeServiceDisconnect:
+		return "ServiceDisconnect"
+	}
+	return fmt.Sprintf("EventType(0x%x)", int(t))
+}
+
+// Event is a notification from HCS about a system or process. Data holds the
+// event's JSON payload, if any.
+type Event struct {
+	Type EventType
+	Data string
+}
+
+type OperationType int
+
+const (
+	OperationTypeNone OperationType = -1 + iota
+	OperationTypeEnumerate
+	OperationTypeCreate
+	OperationTypeStart
+	OperationTypeShutdown
+	OperationTypePause
+	OperationTypeResume
+	OperationTypeSave
+	OperationTypeTerminate
+	OperationTypeModify
+	OperationTypeGetProperties
+	OperationTypeCreateProcess
+	OperationTypeSignalProcess
+	OperationTypeGetProcessInfo
+	OperationTypeGetProcessProperties
+	OperationTypeModifyProcess
+	OperationTypeCrash
+)
+
+func (t OperationType) String() string {
+	switch t {
+	case OperationTypeNone:
+		return "None"
+	case OperationTypeEnumerate:
+		return "Enumerate"
+	case OperationTypeCreate:
+		return "Create"
+	case OperationTypeStart:
+		return "Start"
+	case OperationTypeShutdown:
+		return "Shutdown"
+	case OperationTypePause:
+		return "Pause"
+	case OperationTypeResume:
+		return "Resume"
+	case OperationTypeSave:
+		return "Save"
+	case OperationTypeTerminate:
+		return "Terminate"
+	case OperationTypeModify:
+		return "Modify"
+	case OperationTypeGetProperties:
+		return "GetProperties"
+	case OperationTypeCreateProcess:
+		return "CreateProcess"
+	case OperationTypeSignalProcess:
+		return "SignalProcess"
+	case OperationTypeGetProcessInfo:
+		return "GetProcessInfo"
+	case OperationTypeGetProcessProperties:
+		return "GetProcessProperties"
+	case OperationTypeModifyProcess:
+		return "ModifyProcess"
+	case OperationTypeCrash:
+		return "Crash"
+	}
+	return "Unknown"
+}
+
+type ResourceType int
+
+const (
+	ResourceTypeNone ResourceType = iota
+	ResourceTypeFile
+	ResourceTypeJob
+	ResourceTypeComObject
+	ResourceTypeSocket
+)
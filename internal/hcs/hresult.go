@@ -0,0 +1,124 @@
+package hcs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+// HRESULT is an error code as returned by the HCS APIs. The simulator returns
+// errors of this type, and tests can inject them to exercise failure paths.
+type HRESULT uint32
+
+const (
+	E_INVALIDARG         HRESULT = 0x80070057
+	E_NOTIMPL            HRESULT = 0x80004001
+	E_ACCESSDENIED       HRESULT = 0x80070005
+	E_HANDLE             HRESULT = 0x80070006
+	ERROR_FILE_NOT_FOUND HRESULT = 0x80070002
+	ERROR_ALREADY_EXISTS HRESULT = 0x800700b7
+	ERROR_NOT_FOUND      HRESULT = 0x80070490
+	ERROR_TIMEOUT        HRESULT = 0x800705b4
+	ERROR_CANCELLED      HRESULT = 0x800704c7
+
+	HCS_E_INVALID_STATE               HRESULT = 0x80370105
+	HCS_E_UNEXPECTED_EXIT             HRESULT = 0x80370106
+	HCS_E_TERMINATED                  HRESULT = 0x80370107
+	HCS_E_INVALID_JSON                HRESULT = 0x8037010d
+	HCS_E_SYSTEM_NOT_FOUND            HRESULT = 0x8037010e
+	HCS_E_SYSTEM_ALREADY_EXISTS       HRESULT = 0x8037010f
+	HCS_E_SYSTEM_ALREADY_STOPPED      HRESULT = 0x80370110
+	HCS_E_OPERATION_NOT_STARTED       HRESULT = 0x80370115
+	HCS_E_OPERATION_ALREADY_STARTED   HRESULT = 0x80370116
+	HCS_E_OPERATION_PENDING           HRESULT = 0x80370117
+	HCS_E_OPERATION_TIMEOUT           HRESULT = 0x80370118
+	HCS_E_ACCESS_DENIED               HRESULT = 0x8037011b
+	HCS_E_PROCESS_INFO_NOT_AVAILABLE  HRESULT = 0x8037011d
+	HCS_E_SERVICE_DISCONNECT          HRESULT = 0x8037011e
+	HCS_E_PROCESS_ALREADY_STOPPED     HRESULT = 0x8037011f
+	HCS_E_OPERATION_ALREADY_CANCELLED HRESULT = 0x80370121
+)
+
+var hresultInfo = map[HRESULT]struct{ name, message string }{
+	E_INVALIDARG:                      {"E_INVALIDARG", "The parameter is incorrect."},
+	E_NOTIMPL:                         {"E_NOTIMPL", "Not implemented."},
+	E_ACCESSDENIED:                    {"E_ACCESSDENIED", "Access is denied."},
+	E_HANDLE:                          {"E_HANDLE", "The handle is invalid."},
+	ERROR_FILE_NOT_FOUND:              {"ERROR_FILE_NOT_FOUND", "The system cannot find the file specified."},
+	ERROR_ALREADY_EXISTS:              {"ERROR_ALREADY_EXISTS", "Cannot create a file when that file already exists."},
+	ERROR_NOT_FOUND:                   {"ERROR_NOT_FOUND", "Element not found."},
+	ERROR_TIMEOUT:                     {"ERROR_TIMEOUT", "This operation returned because the timeout period expired."},
+	ERROR_CANCELLED:                   {"ERROR_CANCELLED", "The operation was canceled by the user."},
+	HCS_E_INVALID_STATE:               {"HCS_E_INVALID_STATE", "The requested virtual machine or container operation is not valid in the current state."},
+	HCS_E_UNEXPECTED_EXIT:             {"HCS_E_UNEXPECTED_EXIT", "The virtual machine or container exited unexpectedly while starting."},
+	HCS_E_TERMINATED:                  {"HCS_E_TERMINATED", "The virtual machine or container was forcefully exited."},
+	HCS_E_INVALID_JSON:                {"HCS_E_INVALID_JSON", "The JSON document is invalid."},
+	HCS_E_SYSTEM_NOT_FOUND:            {"HCS_E_SYSTEM_NOT_FOUND", "A virtual machine or container with the specified identifier does not exist."},
+	HCS_E_SYSTEM_ALREADY_EXISTS:       {"HCS_E_SYSTEM_ALREADY_EXISTS", "A virtual machine or container with the specified identifier already exists."},
+	HCS_E_SYSTEM_ALREADY_STOPPED:      {"HCS_E_SYSTEM_ALREADY_STOPPED", "The virtual machine or container with the specified identifier is not running."},
+	HCS_E_OPERATION_NOT_STARTED:       {"HCS_E_OPERATION_NOT_STARTED", "The operation has not started."},
+	HCS_E_OPERATION_ALREADY_STARTED:   {"HCS_E_OPERATION_ALREADY_STARTED", "The operation has already started."},
+	HCS_E_OPERATION_PENDING:           {"HCS_E_OPERATION_PENDING", "The operation is still pending."},
+	HCS_E_OPERATION_TIMEOUT:           {"HCS_E_OPERATION_TIMEOUT", "The operation did not complete in time."},
+	HCS_E_ACCESS_DENIED:               {"HCS_E_ACCESS_DENIED", "The requested access is denied."},
+	HCS_E_PROCESS_INFO_NOT_AVAILABLE:  {"HCS_E_PROCESS_INFO_NOT_AVAILABLE", "The requested information is not available."},
+	HCS_E_SERVICE_DISCONNECT:          {"HCS_E_SERVICE_DISCONNECT", "The connection with the Host Compute Service was terminated."},
+	HCS_E_PROCESS_ALREADY_STOPPED:     {"HCS_E_PROCESS_ALREADY_STOPPED", "The process has already exited."},
+	HCS_E_OPERATION_ALREADY_CANCELLED: {"HCS_E_OPERATION_ALREADY_CANCELLED", "The operation has already been cancelled."},
+}
+
+func (hr HRESULT) Error() string {
+	if info, ok := hresultInfo[hr]; ok {
+		return fmt.Sprintf("%s (0x%08x)", info.message, uint32(hr))
+	}
+	return fmt.Sprintf("HRESULT 0x%08x", uint32(hr))
+}
+
+// Name returns the symbolic name of a known HRESULT, or an empty string.
+func (hr HRESULT) Name() string {
+	return hresultInfo[hr].name
+}
+
+// ParseHRESULT parses an HRESULT given either by number (e.g. 0x8037010e) or
+// by name (e.g. HCS_E_SYSTEM_NOT_FOUND).
+func ParseHRESULT(s string) (HRESULT, error) {
+	for hr, info := range hresultInfo {
+		if info.name == s {
+			return hr, nil
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid HRESULT: %s", s)
+	}
+	return HRESULT(v), nil
+}
+
+// IsTimeout reports whether err indicates that waiting for an operation
+// timed out.
+func IsTimeout(err error) bool {
+	if errors.Is(err, ErrTimedOut) {
+		return true
+	}
+	hr, ok := hresultOf(err)
+	return ok && (hr == ERROR_TIMEOUT || hr == HCS_E_OPERATION_TIMEOUT)
+}
+
+// hresultOf extracts the HRESULT from an error returned by any backend.
+func hresultOf(err error) (HRESULT, bool) {
+	var hr HRESULT
+	if errors.As(err, &hr) {
+		return hr, true
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		// The syscall wrappers strip Win32 HRESULTs (facility 7) down to
+		// their Win32 error code, so put the facility back.
+		if errno < 0x10000 {
+			return HRESULT(0x80070000 | uint32(errno)), true
+		}
+		return HRESULT(errno), true
+	}
+	return 0, false
+}
@@ -0,0 +1,55 @@
+package hcs
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestIsTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"ErrTimedOut", ErrTimedOut, true},
+		{"wrapped ErrTimedOut", fmt.Errorf("start operation %w", ErrTimedOut), true},
+		{"ErrCancelled", ErrCancelled, false},
+		{"ERROR_TIMEOUT", ERROR_TIMEOUT, true},
+		{"HCS_E_OPERATION_TIMEOUT", HCS_E_OPERATION_TIMEOUT, true},
+		{"stripped Win32 timeout", syscall.Errno(0x5B4), true},
+		{"wrapped stripped Win32 timeout", fmt.Errorf("wait: %w", syscall.Errno(0x5B4)), true},
+		{"full Win32 timeout", syscall.Errno(ERROR_TIMEOUT), true},
+		{"HCS timeout errno", syscall.Errno(HCS_E_OPERATION_TIMEOUT), true},
+		{"other Win32 error", syscall.Errno(0x5), false},
+		{"other HRESULT", HCS_E_INVALID_STATE, false},
+		{"other error", errors.New("timeout"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsTimeout(tc.err); got != tc.want {
+				t.Errorf("IsTimeout(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHresultOf(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		want   HRESULT
+		wantOK bool
+	}{
+		{HCS_E_OPERATION_PENDING, HCS_E_OPERATION_PENDING, true},
+		{syscall.Errno(0x5B4), ERROR_TIMEOUT, true},
+		{syscall.Errno(0x490), ERROR_NOT_FOUND, true},
+		{syscall.Errno(HCS_E_OPERATION_PENDING), HCS_E_OPERATION_PENDING, true},
+		{errors.New("other"), 0, false},
+	} {
+		got, ok := hresultOf(tc.err)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("hresultOf(%v) = 0x%08x, %v, want 0x%08x, %v", tc.err, uint32(got), ok, uint32(tc.want), tc.wantOK)
+		}
+	}
+}
@@ -0,0 +1,843 @@
+package hcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// Simulator is a Backend that models compute systems in memory. It follows
+// the HCS state machine closely enough to exercise hcstool without a Windows
+// host, and supports injecting failures into any call.
+type Simulator struct {
+	mu       sync.Mutex
+	systems  map[string]*simSystem
+	failures map[string][]HRESULT
+	hangs    map[string]int
+	grants   map[string][]string
+	nextOpID uint64
+	// cpuGroups are the host's CPU groups by lowercase ID, created in the
+	// order of cpuGroupOrder.
+	cpuGroups     map[string]*hcsschema.CpuGroupConfig
+	cpuGroupOrder []string
+	nextCPUGroup  uint64
+	// events queues callbacks so that they are delivered in order, without
+	// holding mu.
+	events chan func()
+
+	// Latency delays the completion of every operation, to model slow calls.
+	Latency time.Duration
+}
+
+var _ Backend = &Simulator{}
+
+func NewSimulator() *Simulator {
+	s := &Simulator{
+		systems:   make(map[string]*simSystem),
+		failures:  make(map[string][]HRESULT),
+		hangs:     make(map[string]int),
+		grants:    make(map[string][]string),
+		events:    make(chan func(), 1024),
+		cpuGroups: make(map[string]*hcsschema.CpuGroupConfig),
+	}
+	go func() {
+		for deliver := range s.events {
+			deliver()
+		}
+	}()
+	return s
+}
+
+// flushEvents waits for every queued event to be delivered. Operations flush
+// before completing, so that the events they cause are seen first, which
+// keeps scripts against the simulator deterministic.
+func (s *Simulator) flushEvents() {
+	flushed := make(chan struct{})
+	s.events <- func() { close(flushed) }
+	<-flushed
+}
+
+// Disconnect simulates the HCS service going away, by sending a
+// ServiceDisconnect event to every system callback.
+func (s *Simulator) Disconnect() {
+	s.mu.Lock()
+	for _, sys := range s.systems {
+		sys.notify(EventTypeServiceDisconnect, nil)
+	}
+	s.mu.Unlock()
+	s.flushEvents()
+}
+
+// FailNext arranges for the next call to the named Backend or System method
+// (e.g. "CreateComputeSystem" or "Start") to fail with hr. Multiple failures
+// for the same method are returned in the order they were added.
+func (s *Simulator) FailNext(method string, hr HRESULT) error {
+	if !isSimMethod(method) {
+		return fmt.Errorf("unknown method: %s", method)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = append(s.failures[method], hr)
+	return nil
+}
+
+// HangNext arranges for the operation started by the next call to the named
+// System method to never complete on its own. It completes only once the
+// system stops or the operation is cancelled.
+func (s *Simulator) HangNext(method string) error {
+	if !isSimMethod(method) {
+		return fmt.Errorf("unknown method: %s", method)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hangs[method]++
+	return nil
+}
+
+// ClearFailures removes all pending injected failures and hangs.
+func (s *Simulator) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = make(map[string][]HRESULT)
+	s.hangs = make(map[string]int)
+}
+
+// Grants returns the paths that vmID has been granted access to.
+func (s *Simulator) Grants(vmID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.grants[vmID]...)
+}
+
+func isSimMethod(method string) bool {
+	for _, t := range []reflect.Type{
+		reflect.TypeOf((*Backend)(nil)).Elem(),
+		reflect.TypeOf((*System)(nil)).Elem(),
+	} {
+		if _, ok := t.MethodByName(method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// takeFailure returns the next injected failure for method, if any.
+func (s *Simulator) takeFailure(method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.failures[method]
+	if len(q) == 0 {
+		return nil
+	}
+	s.failures[method] = q[1:]
+	return q[0]
+}
+
+// takeHang reports whether the next call to method should hang.
+func (s *Simulator) takeHang(method string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hangs[method] == 0 {
+		return false
+	}
+	s.hangs[method]--
+	return true
+}
+
+func (s *Simulator) NewOperation() Operation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextOpID++
+	return &simOperation{sim: s, id: s.nextOpID, typ: OperationTypeNone, cancelled: make(chan struct{})}
+}
+
+func (s *Simulator) CreateComputeSystem(id string, config string, op Operation) (System, error) {
+	o, err := s.operation(op)
+	if err != nil {
+		return nil, err
+	}
+	failure := s.takeFailure("CreateComputeSystem")
+	var doc hcsschema.ComputeSystem
+	if err := json.Unmarshal([]byte(config), &doc); err != nil {
+		return nil, HCS_E_INVALID_JSON
+	}
+	tree, err := DecodeTree([]byte(config))
+	if err != nil {
+		return nil, HCS_E_INVALID_JSON
+	}
+	h := &simHandle{sim: s, id: id}
+	if err := o.start(OperationTypeCreate, func() (string, error) {
+		if failure != nil {
+			return "", failure
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.systems[id]; ok {
+			return "", HCS_E_SYSTEM_ALREADY_EXISTS
+		}
+		s.systems[id] = &simSystem{
+			sim:       s,
+			id:        id,
+			config:    tree,
+			state:     "Created",
+			runtimeID: NewGUID(),
+			handles:   1,
+			stopped:   make(chan struct{}),
+			processes: make(map[uint32]*simProcess),
+			callbacks: make(map[*simHandle]func(Event)),
+		}
+		return "", nil
+	}); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (s *Simulator) OpenComputeSystem(id string) (System, error) {
+	if err := s.takeFailure("OpenComputeSystem"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sys, ok := s.systems[id]
+	if !ok {
+		return nil, HCS_E_SYSTEM_NOT_FOUND
+	}
+	sys.handles++
+	return &simHandle{sim: s, id: id}, nil
+}
+
+func (s *Simulator) EnumerateComputeSystems(query string, op Operation) error {
+	o, err := s.operation(op)
+	if err != nil {
+		return err
+	}
+	failure := s.takeFailure("EnumerateComputeSystems")
+	return o.start(OperationTypeEnumerate, func() (string, error) {
+		if failure != nil {
+			return "", failure
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ids := make([]string, 0, len(s.systems))
+		for id := range s.systems {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		systems := make([]hcsschema.Properties, 0, len(ids))
+		for _, id := range ids {
+			systems = append(systems, s.systems[id].basicProperties())
+		}
+		j, err := json.Marshal(systems)
+		if err != nil {
+			return "", err
+		}
+		return string(j), nil
+	})
+}
+
+func (s *Simulator) GetServiceProperties(query string) (string, error) {
+	if err := s.takeFailure("GetServiceProperties"); err != nil {
+		return "", err
+	}
+	return s.serviceProperties(query)
+}
+
+func (s *Simulator) GrantVmAccess(vmID string, path string) error {
+	if err := s.takeFailure("GrantVmAccess"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ERROR_FILE_NOT_FOUND
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.grants[vmID] = append(s.grants[vmID], path)
+	return nil
+}
+
+func (s *Simulator) operation(op Operation) (*simOperation, error) {
+	o, ok := op.(*simOperation)
+	if !ok || o.sim != s {
+		return nil, E_INVALIDARG
+	}
+	return o, nil
+}
+
+type simSystem struct {
+	sim       *Simulator
+	id        string
+	config    map[string]any
+	state     string
+	exitType  string
+	runtimeID string
+	started   time.Time
+	handles   int
+	migration string
+	stopped   chan struct{}
+	processes map[uint32]*simProcess
+	nextPID   uint32
+	callbacks map[*simHandle]func(Event)
+}
+
+func (sys *simSystem) systemType() string {
+	if _, ok := sys.config["VirtualMachine"]; ok {
+		return "VirtualMachine"
+	}
+	return "Container"
+}
+
+// document returns the typed form of the system's current configuration.
+func (sys *simSystem) document() (*hcsschema.ComputeSystem, error) {
+	j, err := json.Marshal(sys.config)
+	if err != nil {
+		return nil, err
+	}
+	var doc hcsschema.ComputeSystem
+	if err := json.Unmarshal(j, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (sys *simSystem) basicProperties() hcsschema.Properties {
+	props := hcsschema.Properties{
+		Id:         sys.id,
+		SystemType: sys.systemType(),
+		Name:       sys.id,
+		RuntimeId:  sys.runtimeID,
+		State:      sys.state,
+		Stopped:    sys.state == "Stopped",
+		ExitType:   sys.exitType,
+	}
+	if owner, ok := sys.config["Owner"].(string); ok {
+		props.Owner = owner
+	}
+	if sys.systemType() == "VirtualMachine" {
+		props.RuntimeOsType = "Windows"
+		if vm, ok := sys.config["VirtualMachine"].(map[string]any); ok {
+			if chipset, ok := vm["Chipset"].(map[string]any); ok {
+				if _, ok := chipset["LinuxKernelDirect"]; ok {
+					props.RuntimeOsType = "Linux"
+				}
+			}
+		}
+	}
+	return props
+}
+
+// transition moves the system from one of the states in from to the state to,
+// or fails with HCS_E_INVALID_STATE.
+func (sys *simSystem) transition(to string, from ...string) error {
+	if err := sys.require(from...); err != nil {
+		return err
+	}
+	sys.state = to
+	return nil
+}
+
+// require fails unless the system is in one of the given states.
+func (sys *simSystem) require(states ...string) error {
+	for _, s := range states {
+		if sys.state == s {
+			return nil
+		}
+	}
+	if sys.state == "Stopped" {
+		return HCS_E_SYSTEM_ALREADY_STOPPED
+	}
+	return HCS_E_INVALID_STATE
+}
+
+// stop moves the system to the Stopped state, completing any hung
+// operations.
+func (sys *simSystem) stop(exitType string) {
+	sys.state = "Stopped"
+	sys.exitType = exitType
+	sys.migration = ""
+	close(sys.stopped)
+	for _, p := range sys.processes {
+		p.terminate(137)
+	}
+	if sys.systemType() == "VirtualMachine" {
+		sys.notify(EventTypeSystemGuestConnectionClosed, nil)
+	}
+	status := HRESULT(0)
+	switch exitType {
+	case "ForcedExit":
+		status = HCS_E_TERMINATED
+	case "UnexpectedExit":
+		status = HCS_E_UNEXPECTED_EXIT
+	}
+	sys.notify(EventTypeSystemExited, hcsschema.SystemExitStatus{Status: int32(status), ExitType: exitType})
+}
+
+// notify queues an event, with data as its JSON payload, for every handle
+// with a callback. It must be called with sim.mu held.
+func (sys *simSystem) notify(typ EventType, data any) {
+	e := Event{Type: typ}
+	if data != nil {
+		j, _ := json.Marshal(data)
+		e.Data = string(j)
+	}
+	for _, callback := range sys.callbacks {
+		callback := callback
+		sys.sim.events <- func() { callback(e) }
+	}
+}
+
+// simHandle is a handle to a simulated compute system. As in HCS, each open
+// handle keeps the system alive once it has stopped.
+type simHandle struct {
+	sim    *Simulator
+	id     string
+	closed bool
+}
+
+var _ System = &simHandle{}
+
+func (h *simHandle) ID() string { return h.id }
+
+func (h *simHandle) Close() {
+	if h.closed {
+		return
+	}
+	h.closed = true
+	h.sim.mu.Lock()
+	defer h.sim.mu.Unlock()
+	sys, ok := h.sim.systems[h.id]
+	if !ok {
+		return
+	}
+	delete(sys.callbacks, h)
+	sys.handles--
+	if sys.handles > 0 {
+		return
+	}
+	if terminate, _ := sys.config["ShouldTerminateOnLastHandleClosed"].(bool); terminate || sys.state == "Stopped" {
+		delete(h.sim.systems, h.id)
+	}
+}
+
+func (h *simHandle) SetCallback(callback func(Event)) error {
+	if h.closed {
+		return E_HANDLE
+	}
+	h.sim.mu.Lock()
+	defer h.sim.mu.Unlock()
+	sys, ok := h.sim.systems[h.id]
+	if !ok {
+		return HCS_E_SYSTEM_NOT_FOUND
+	}
+	sys.callbacks[h] = callback
+	return nil
+}
+
+// do starts op as an operation of type typ, that runs fn against the system
+// once it completes.
+func (h *simHandle) do(method string, op Operation, typ OperationType, fn func(sys *simSystem, o *simOperation) (string, error)) error {
+	if h.closed {
+		return E_HANDLE
+	}
+	o, err := h.sim.operation(op)
+	if err != nil {
+		return err
+	}
+	failure := h.sim.takeFailure(method)
+	hang := h.sim.takeHang(method)
+	return o.start(typ, func() (string, error) {
+		if failure != nil {
+			return "", failure
+		}
+		if hang {
+			h.sim.mu.Lock()
+			sys, ok := h.sim.systems[h.id]
+			h.sim.mu.Unlock()
+			if ok {
+				select {
+				case <-sys.stopped:
+				case <-o.cancelled:
+					return "", ERROR_CANCELLED
+				}
+			}
+			return "", HCS_E_TERMINATED
+		}
+		h.sim.mu.Lock()
+		defer h.sim.mu.Unlock()
+		sys, ok := h.sim.systems[h.id]
+		if !ok {
+			return "", HCS_E_SYSTEM_NOT_FOUND
+		}
+		return fn(sys, o)
+	})
+}
+
+func (h *simHandle) Start(op Operation, options string) error {
+	var so hcsschema.StartOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &so); err != nil {
+			return HCS_E_INVALID_JSON
+		}
+	}
+	return h.do("Start", op, OperationTypeStart, func(sys *simSystem, o *simOperation) (string, error) {
+		if so.DestinationMigrationOptions != nil && !o.hasResource(ResourceTypeSocket) {
+			return "", E_INVALIDARG
+		}
+		if err := sys.transition("Running", "Created"); err != nil {
+			return "", err
+		}
+		sys.started = time.Now()
+		if sys.systemType() == "Container" {
+			sys.notify(EventTypeSystemSiloJobCreated, hcsschema.SiloProperties{Enabled: true, JobName: `\Container_` + sys.id})
+		}
+		return "", nil
+	})
+}
+
+func (h *simHandle) Shutdown(op Operation, options string) error {
+	var so hcsschema.ShutdownOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &so); err != nil {
+			return HCS_E_INVALID_JSON
+		}
+	}
+	return h.do("Shutdown", op, OperationTypeShutdown, func(sys *simSystem, o *simOperation) (string, error) {
+		if err := sys.require("Running"); err != nil {
+			return "", err
+		}
+		switch so.Type {
+		case "", hcsschema.ShutdownTypeShutdown, hcsschema.ShutdownTypeHibernate:
+			sys.stop("GracefulExit")
+		case hcsschema.ShutdownTypeReboot:
+			sys.started = time.Now()
+		default:
+			return "", E_INVALIDARG
+		}
+		return "", nil
+	})
+}
+
+func (h *simHandle) Terminate(op Operation, options string) error {
+	return h.do("Terminate", op, OperationTypeTerminate, func(sys *simSystem, o *simOperation) (string, error) {
+		if sys.state == "Stopped" {
+			return "", HCS_E_SYSTEM_ALREADY_STOPPED
+		}
+		sys.stop("ForcedExit")
+		return "", nil
+	})
+}
+
+func (h *simHandle) Crash(op Operation, options string) error {
+	return h.do("Crash", op, OperationTypeCrash, func(sys *simSystem, o *simOperation) (string, error) {
+		if err := sys.require("Running"); err != nil {
+			return "", err
+		}
+		// The parameters are those of a manually initiated bug check.
+		report := hcsschema.CrashReport{
+			SystemId:        sys.id,
+			ActivityId:      NewGUID(),
+			CrashParameters: []uint64{0xe2, 0, 0, 0, 0},
+		}
+		sys.notify(EventTypeSystemCrashInitiated, report)
+		report.CrashLog = "simulated crash"
+		sys.notify(EventTypeSystemCrashReport, report)
+		sys.stop("UnexpectedExit")
+		return "", nil
+	})
+}
+
+func (h *simHandle) Pause(op Operation, options string) error {
+	return h.do("Pause", op, OperationTypePause, func(sys *simSystem, o *simOperation) (string, error) {
+		return "", sys.transition("Paused", "Running")
+	})
+}
+
+func (h *simHandle) Resume(op Operation, options string) error {
+	return h.do("Resume", op, OperationTypeResume, func(sys *simSystem, o *simOperation) (string, error) {
+		return "", sys.transition("Running", "Paused")
+	})
+}
+
+func (h *simHandle) Save(op Operation, options string) error {
+	var so hcsschema.SaveOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &so); err != nil {
+			return HCS_E_INVALID_JSON
+		}
+	}
+	return h.do("Save", op, OperationTypeSave, func(sys *simSystem, o *simOperation) (string, error) {
+		switch so.SaveType {
+		case "AsTemplate":
+			return "", sys.transition("SavedAsTemplate", "Paused")
+		case "ToFile":
+			if err := sys.require("Paused"); err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(so.SaveStateFilePath, []byte(sys.runtimeID), 0644); err != nil {
+				return "", E_ACCESSDENIED
+			}
+			return "", nil
+		}
+		return "", E_INVALIDARG
+	})
+}
+
+func (h *simHandle) GetProperties(op Operation, query string) error {
+	return h.do("GetProperties", op, OperationTypeGetProperties, func(sys *simSystem, o *simOperation) (string, error) {
+		return sys.properties(query)
+	})
+}
+
+func (h *simHandle) Modify(op Operation, config string) error {
+	var req simModifyRequest
+	if err := json.Unmarshal([]byte(config), &req); err != nil {
+		return HCS_E_INVALID_JSON
+	}
+	return h.do("Modify", op, OperationTypeModify, func(sys *simSystem, o *simOperation) (string, error) {
+		switch sys.state {
+		case "Stopped":
+			return "", HCS_E_SYSTEM_ALREADY_STOPPED
+		case "SavedAsTemplate":
+			return "", HCS_E_INVALID_STATE
+		}
+		if len(req.GuestRequest) != 0 {
+			if err := sys.checkGuestRequest(req.GuestRequest); err != nil {
+				return "", err
+			}
+		}
+		if req.ResourcePath == "" {
+			return "", nil
+		}
+		if err := sys.sim.checkCPUGroup(req); err != nil {
+			return "", err
+		}
+		settings, err := DecodeValue(req.Settings)
+		if err != nil {
+			return "", HCS_E_INVALID_JSON
+		}
+		return "", ApplyModify(sys.config, req.ResourcePath, req.RequestType, settings)
+	})
+}
+
+func (h *simHandle) InitializeLiveMigrationOnSource(op Operation, options string) error {
+	return h.do("InitializeLiveMigrationOnSource", op, OperationTypeNone, func(sys *simSystem, o *simOperation) (string, error) {
+		if sys.state != "Running" || sys.migration != "" {
+			return "", HCS_E_INVALID_STATE
+		}
+		sys.migration = "Initialized"
+		return "", nil
+	})
+}
+
+func (h *simHandle) StartLiveMigrationOnSource(op Operation, options string) error {
+	return h.do("StartLiveMigrationOnSource", op, OperationTypeNone, func(sys *simSystem, o *simOperation) (string, error) {
+		if sys.migration != "Initialized" {
+			return "", HCS_E_INVALID_STATE
+		}
+		if !o.hasResource(ResourceTypeSocket) {
+			return "", E_INVALIDARG
+		}
+		sys.migration = "Started"
+		return "", nil
+	})
+}
+
+func (h *simHandle) StartLiveMigrationTransfer(op Operation, options string) error {
+	return h.do("StartLiveMigrationTransfer", op, OperationTypeNone, func(sys *simSystem, o *simOperation) (string, error) {
+		if sys.migration != "Started" {
+			return "", HCS_E_INVALID_STATE
+		}
+		sys.migration = "Transferred"
+		return "", nil
+	})
+}
+
+func (h *simHandle) FinalizeLiveMigration(op Operation, options string) error {
+	var fo hcsschema.MigrationFinalizedOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &fo); err != nil {
+			return HCS_E_INVALID_JSON
+		}
+	}
+	return h.do("FinalizeLiveMigration", op, OperationTypeNone, func(sys *simSystem, o *simOperation) (string, error) {
+		if sys.migration == "" {
+			return "", HCS_E_INVALID_STATE
+		}
+		sys.migration = ""
+		if fo.FinalizedOperation == hcsschema.MigrationFinalOperationStop {
+			sys.stop("MigratedOut")
+		}
+		return "", nil
+	})
+}
+
+// simOperation is an operation that completes on a separate goroutine, after
+// the simulator's latency has elapsed.
+type simOperation struct {
+	sim *Simulator
+	id  uint64
+
+	mu        sync.Mutex
+	typ       OperationType
+	done      chan struct{}
+	cancelled chan struct{}
+	result    string
+	err       error
+	resources []ResourceType
+	procInfo  *ProcessInfo
+	callback  func(Event)
+}
+
+func (o *simOperation) Close() {}
+
+func (o *simOperation) ID() uint64 { return o.id }
+
+func (o *simOperation) Type() OperationType {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.typ
+}
+
+func (o *simOperation) AddResource(typ ResourceType, uri string, handle uintptr) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done != nil {
+		return HCS_E_OPERATION_ALREADY_STARTED
+	}
+	o.resources = append(o.resources, typ)
+	return nil
+}
+
+func (o *simOperation) hasResource(typ ResourceType) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, r := range o.resources {
+		if r == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *simOperation) start(typ OperationType, fn func() (string, error)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done != nil {
+		return HCS_E_OPERATION_ALREADY_STARTED
+	}
+	o.typ = typ
+	o.done = make(chan struct{})
+	go func() {
+		var (
+			result string
+			err    error
+		)
+		t := time.NewTimer(o.sim.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			result, err = fn()
+			o.sim.flushEvents()
+		case <-o.cancelled:
+			err = ERROR_CANCELLED
+		}
+		o.mu.Lock()
+		o.result, o.err = result, err
+		callback := o.callback
+		o.mu.Unlock()
+		close(o.done)
+		if callback != nil {
+			callback(Event{Type: EventTypeOperationCallback})
+		}
+	}()
+	return nil
+}
+
+func (o *simOperation) SetCallback(callback func(Event)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done != nil {
+		return HCS_E_OPERATION_ALREADY_STARTED
+	}
+	o.callback = callback
+	return nil
+}
+
+// Cancel cancels the operation if it is still pending. Operations that have
+// already started running against a system finish regardless, unless they
+// were made to hang.
+func (o *simOperation) Cancel() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done == nil {
+		return HCS_E_OPERATION_NOT_STARTED
+	}
+	select {
+	case <-o.cancelled:
+		return HCS_E_OPERATION_ALREADY_CANCELLED
+	default:
+	}
+	close(o.cancelled)
+	return nil
+}
+
+func (o *simOperation) doneChan() chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.done
+}
+
+func (o *simOperation) Result() (string, error) {
+	done := o.doneChan()
+	if done == nil {
+		return "", HCS_E_OPERATION_NOT_STARTED
+	}
+	select {
+	case <-done:
+	default:
+		return "", HCS_E_OPERATION_PENDING
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.result, o.err
+}
+
+func (o *simOperation) WaitResult(timeoutMS uint32) (string, error) {
+	done := o.doneChan()
+	if done == nil {
+		return "", HCS_E_OPERATION_NOT_STARTED
+	}
+	if timeoutMS == Infinite {
+		<-done
+	} else {
+		t := time.NewTimer(time.Duration(timeoutMS) * time.Millisecond)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-t.C:
+			return "", HCS_E_OPERATION_TIMEOUT
+		}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.result, o.err
+}
+
+func (o *simOperation) WaitResultAndProcessInfo(timeoutMS uint32) (string, *ProcessInfo, error) {
+	result, err := o.WaitResult(timeoutMS)
+	if err != nil {
+		return "", nil, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.procInfo == nil {
+		return "", nil, HCS_E_PROCESS_INFO_NOT_AVAILABLE
+	}
+	return result, o.procInfo, nil
+}
@@ -0,0 +1,193 @@
+package hcs
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// The simulated host has two NUMA nodes, each with one package of four cores
+// with two SMT threads each.
+const (
+	simNodes          = 2
+	simCoresPerNode   = 4
+	simThreadsPerCore = 2
+)
+
+// simTopology returns the logical processors of the simulated host. Sibling
+// threads have adjacent indexes, as on most hosts.
+func simTopology() *hcsschema.ProcessorTopology {
+	t := &hcsschema.ProcessorTopology{}
+	for node := 0; node < simNodes; node++ {
+		for core := 0; core < simCoresPerNode; core++ {
+			for thread := 0; thread < simThreadsPerCore; thread++ {
+				lp := uint32(len(t.LogicalProcessors))
+				t.LogicalProcessors = append(t.LogicalProcessors, hcsschema.LogicalProcessor{
+					LpIndex:     lp,
+					NodeNumber:  uint8(node),
+					PackageId:   uint32(node),
+					CoreId:      uint32(node*simCoresPerNode + core),
+					RootVpIndex: int32(lp),
+				})
+			}
+		}
+	}
+	t.LogicalProcessorCount = uint32(len(t.LogicalProcessors))
+	return t
+}
+
+// cpuGroupList returns the CPU groups of the host, in the order they were
+// created. It must be called with s.mu held.
+func (s *Simulator) cpuGroupList() *hcsschema.CpuGroupConfigurations {
+	groups := &hcsschema.CpuGroupConfigurations{}
+	for _, id := range s.cpuGroupOrder {
+		groups.CpuGroups = append(groups.CpuGroups, *s.cpuGroups[id])
+	}
+	return groups
+}
+
+func (s *Simulator) ModifyServiceSettings(settings string) error {
+	if err := s.takeFailure("ModifyServiceSettings"); err != nil {
+		return err
+	}
+	var req struct {
+		PropertyType hcsschema.PropertyType
+		Settings     struct {
+			Operation        hcsschema.CPUGroupOperation
+			OperationDetails json.RawMessage
+		}
+	}
+	if err := json.Unmarshal([]byte(settings), &req); err != nil {
+		return HCS_E_INVALID_JSON
+	}
+	if req.PropertyType != hcsschema.PTCPUGroup {
+		return E_NOTIMPL
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	details := req.Settings.OperationDetails
+	switch req.Settings.Operation {
+	case hcsschema.CreateGroup:
+		var op hcsschema.CreateGroupOperation
+		if err := json.Unmarshal(details, &op); err != nil {
+			return HCS_E_INVALID_JSON
+		}
+		return s.createCPUGroup(op)
+	case hcsschema.DeleteGroup:
+		var op hcsschema.DeleteGroupOperation
+		if err := json.Unmarshal(details, &op); err != nil {
+			return HCS_E_INVALID_JSON
+		}
+		return s.deleteCPUGroup(op)
+	case hcsschema.SetProperty:
+		var op hcsschema.SetPropertyOperation
+		if err := json.Unmarshal(details, &op); err != nil {
+			return HCS_E_INVALID_JSON
+		}
+		return s.setCPUGroupProperty(op)
+	}
+	return E_INVALIDARG
+}
+
+func (s *Simulator) createCPUGroup(op hcsschema.CreateGroupOperation) error {
+	id := strings.ToLower(op.GroupId)
+	if id == "" || id == hcsschema.NullCpuGroupId || op.LogicalProcessorCount != uint32(len(op.LogicalProcessors)) {
+		return E_INVALIDARG
+	}
+	if _, ok := s.cpuGroups[id]; ok {
+		return ERROR_ALREADY_EXISTS
+	}
+	seen := make(map[uint32]bool)
+	lps := make([]int32, 0, len(op.LogicalProcessors))
+	for _, lp := range op.LogicalProcessors {
+		if lp >= simNodes*simCoresPerNode*simThreadsPerCore || seen[lp] {
+			return E_INVALIDARG
+		}
+		seen[lp] = true
+		lps = append(lps, int32(lp))
+	}
+	s.nextCPUGroup++
+	s.cpuGroups[id] = &hcsschema.CpuGroupConfig{
+		GroupId: id,
+		Affinity: &hcsschema.CpuGroupAffinity{
+			LogicalProcessorCount: int32(len(lps)),
+			LogicalProcessors:     lps,
+		},
+		HypervisorGroupId: s.nextCPUGroup,
+	}
+	s.cpuGroupOrder = append(s.cpuGroupOrder, id)
+	return nil
+}
+
+func (s *Simulator) deleteCPUGroup(op hcsschema.DeleteGroupOperation) error {
+	id := strings.ToLower(op.GroupId)
+	if _, ok := s.cpuGroups[id]; !ok {
+		return ERROR_NOT_FOUND
+	}
+	// Groups cannot be deleted while a VM is in them.
+	for _, sys := range s.systems {
+		if sys.cpuGroup() == id {
+			return HCS_E_INVALID_STATE
+		}
+	}
+	delete(s.cpuGroups, id)
+	for i, g := range s.cpuGroupOrder {
+		if g == id {
+			s.cpuGroupOrder = append(s.cpuGroupOrder[:i:i], s.cpuGroupOrder[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) setCPUGroupProperty(op hcsschema.SetPropertyOperation) error {
+	g, ok := s.cpuGroups[strings.ToLower(op.GroupId)]
+	if !ok {
+		return ERROR_NOT_FOUND
+	}
+	switch op.PropertyCode {
+	case hcsschema.CPUCapacityProperty, hcsschema.CPUSchedulingPriorityProperty, hcsschema.IdleLPReserveProperty:
+	default:
+		return E_INVALIDARG
+	}
+	for i, p := range g.GroupProperties {
+		if p.PropertyCode == op.PropertyCode {
+			g.GroupProperties[i].PropertyValue = op.PropertyValue
+			return nil
+		}
+	}
+	g.GroupProperties = append(g.GroupProperties, hcsschema.CpuGroupProperty{PropertyCode: op.PropertyCode, PropertyValue: op.PropertyValue})
+	return nil
+}
+
+// cpuGroup returns the ID of the CPU group the system is in, if any.
+func (sys *simSystem) cpuGroup() string {
+	doc, err := sys.document()
+	if err != nil || doc.VirtualMachine == nil || doc.VirtualMachine.ComputeTopology == nil {
+		return ""
+	}
+	p := doc.VirtualMachine.ComputeTopology.Processor
+	if p == nil || p.CpuGroup == nil || p.CpuGroup.Id == hcsschema.NullCpuGroupId {
+		return ""
+	}
+	return strings.ToLower(p.CpuGroup.Id)
+}
+
+// checkCPUGroup fails modify requests that would put a system in a CPU group
+// that does not exist. It must be called with s.mu held.
+func (s *Simulator) checkCPUGroup(req simModifyRequest) error {
+	if strings.Trim(req.ResourcePath, "/") != "VirtualMachine/ComputeTopology/Processor/CpuGroup" || req.RequestType == "Remove" {
+		return nil
+	}
+	var g hcsschema.CpuGroup
+	if err := json.Unmarshal(req.Settings, &g); err != nil {
+		return HCS_E_INVALID_JSON
+	}
+	if id := strings.ToLower(g.Id); id != hcsschema.NullCpuGroupId {
+		if _, ok := s.cpuGroups[id]; !ok {
+			return ERROR_NOT_FOUND
+		}
+	}
+	return nil
+}
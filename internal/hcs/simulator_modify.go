@@ -0,0 +1,36 @@
+package hcs
+
+import "encoding/json"
+
+// simModifyRequest is a ModifySettingRequest with its settings left encoded.
+type simModifyRequest struct {
+	ResourcePath string
+	RequestType  string
+	Settings     json.RawMessage
+	GuestRequest json.RawMessage
+}
+
+// checkGuestRequest checks the envelope of a request to the guest, which the
+// simulator otherwise ignores, as it has no guest. Guests only take requests
+// while they are running.
+func (sys *simSystem) checkGuestRequest(guestRequest json.RawMessage) error {
+	var env struct {
+		ResourceType string
+		RequestType  string
+	}
+	if err := json.Unmarshal(guestRequest, &env); err != nil {
+		return HCS_E_INVALID_JSON
+	}
+	switch env.RequestType {
+	case "Add", "Remove", "Update", "PreAdd":
+	default:
+		return E_INVALIDARG
+	}
+	if env.ResourceType == "" || sys.systemType() != "VirtualMachine" {
+		return E_INVALIDARG
+	}
+	if sys.state != "Running" {
+		return HCS_E_INVALID_STATE
+	}
+	return nil
+}
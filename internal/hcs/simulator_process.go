@@ -0,0 +1,426 @@
+package hcs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// simProcess is a process in a simulated compute system. Rather than running
+// anything real, it interprets a handful of shell builtins: echo, cat, sleep,
+// exit, true, false, env, pwd, hostname and whoami.
+type simProcess struct {
+	sim     *Simulator
+	sysID   string
+	pid     uint32
+	params  hcsschema.ProcessParameters
+	argv    []string
+	created time.Time
+
+	stdin  io.Reader
+	stdout io.WriteCloser
+	stderr io.WriteCloser
+	// stdinHost is the host's end of the stdin pipe, which is closed if the
+	// handle is closed through ModifyProcess.
+	stdinHost io.WriteCloser
+
+	mu          sync.Mutex
+	callbacks   []func(Event)
+	exited      bool
+	exitCode    int32
+	consoleSize hcsschema.ConsoleSize
+	kill        chan struct{}
+	killOnce    sync.Once
+	killCode    int32
+}
+
+var errProcessKilled = errors.New("process killed")
+
+func (h *simHandle) CreateProcess(op Operation, params string) (Process, error) {
+	p := &simProcess{sim: h.sim, sysID: h.id, kill: make(chan struct{})}
+	if err := json.Unmarshal([]byte(params), &p.params); err != nil {
+		return nil, HCS_E_INVALID_JSON
+	}
+	p.argv = p.params.CommandArgs
+	if len(p.argv) == 0 {
+		p.argv = splitCommandLine(p.params.CommandLine)
+	}
+	if len(p.argv) == 0 {
+		return nil, E_INVALIDARG
+	}
+	if err := h.do("CreateProcess", op, OperationTypeCreateProcess, func(sys *simSystem, o *simOperation) (string, error) {
+		if err := sys.require("Running"); err != nil {
+			return "", err
+		}
+		sys.nextPID += 4
+		p.pid = sys.nextPID
+		p.created = time.Now()
+		sys.processes[p.pid] = p
+		o.procInfo = p.setupStdio()
+		go p.run()
+		return "", nil
+	}); err != nil {
+		return nil, err
+	}
+	return &simProcessHandle{proc: p}, nil
+}
+
+func (h *simHandle) OpenProcess(pid uint32) (Process, error) {
+	if h.closed {
+		return nil, E_HANDLE
+	}
+	h.sim.mu.Lock()
+	defer h.sim.mu.Unlock()
+	sys, ok := h.sim.systems[h.id]
+	if !ok {
+		return nil, HCS_E_SYSTEM_NOT_FOUND
+	}
+	p, ok := sys.processes[pid]
+	if !ok {
+		return nil, ERROR_NOT_FOUND
+	}
+	return &simProcessHandle{proc: p}, nil
+}
+
+// setupStdio creates the pipes requested by the process parameters, and
+// returns the host side of them.
+func (p *simProcess) setupStdio() *ProcessInfo {
+	info := &ProcessInfo{ProcessID: p.pid}
+	p.stdin = strings.NewReader("")
+	p.stdout = nopWriteCloser{io.Discard}
+	p.stderr = nopWriteCloser{io.Discard}
+	if p.params.CreateStdInPipe {
+		r, w := io.Pipe()
+		p.stdin, info.Stdin, p.stdinHost = r, w, w
+		go func() {
+			<-p.kill
+			r.CloseWithError(errProcessKilled)
+		}()
+	}
+	if p.params.CreateStdOutPipe {
+		r, w := io.Pipe()
+		p.stdout, info.Stdout = w, r
+	}
+	if p.params.EmulateConsole {
+		if len(p.params.ConsoleSize) == 2 {
+			p.consoleSize = hcsschema.ConsoleSize{Height: p.params.ConsoleSize[0], Width: p.params.ConsoleSize[1]}
+		}
+		p.stderr = p.stdout
+	} else if p.params.CreateStdErrPipe {
+		r, w := io.Pipe()
+		p.stderr, info.Stderr = w, r
+	}
+	return info
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (p *simProcess) run() {
+	code := p.builtin()
+	select {
+	case <-p.kill:
+		code = p.killCode
+	default:
+	}
+	p.terminate(code)
+	p.stdout.Close()
+	p.stderr.Close()
+
+	p.sim.mu.Lock()
+	if sys, ok := p.sim.systems[p.sysID]; ok {
+		delete(sys.processes, p.pid)
+	}
+	p.sim.mu.Unlock()
+
+	p.mu.Lock()
+	p.exited = true
+	p.exitCode = code
+	callbacks := p.callbacks
+	p.mu.Unlock()
+	j, _ := json.Marshal(p.status())
+	for _, cb := range callbacks {
+		cb(Event{Type: EventTypeProcessExited, Data: string(j)})
+	}
+}
+
+// terminate kills the process with the given exit code, if it is still
+// running.
+func (p *simProcess) terminate(code int32) {
+	p.killOnce.Do(func() {
+		p.killCode = code
+		close(p.kill)
+	})
+}
+
+func (p *simProcess) builtin() int32 {
+	args := p.argv[1:]
+	switch p.argv[0] {
+	case "echo":
+		fmt.Fprintln(p.stdout, strings.Join(args, " "))
+	case "cat":
+		if _, err := io.Copy(p.stdout, p.stdin); err != nil {
+			return 1
+		}
+	case "sleep":
+		if len(args) != 1 {
+			fmt.Fprintln(p.stderr, "usage: sleep SECONDS")
+			return 1
+		}
+		secs, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			fmt.Fprintf(p.stderr, "sleep: invalid time interval: %s\n", args[0])
+			return 1
+		}
+		t := time.NewTimer(time.Duration(secs * float64(time.Second)))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-p.kill:
+		}
+	case "exit":
+		if len(args) == 0 {
+			return 0
+		}
+		code, err := strconv.ParseInt(args[0], 10, 32)
+		if err != nil {
+			fmt.Fprintf(p.stderr, "exit: invalid exit code: %s\n", args[0])
+			return 2
+		}
+		return int32(code)
+	case "true":
+	case "false":
+		return 1
+	case "env":
+		keys := make([]string, 0, len(p.params.Environment))
+		for k := range p.params.Environment {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(p.stdout, "%s=%s\n", k, p.params.Environment[k])
+		}
+	case "pwd":
+		fmt.Fprintln(p.stdout, p.params.WorkingDirectory)
+	case "hostname":
+		fmt.Fprintln(p.stdout, p.sysID)
+	case "whoami":
+		user := p.params.User
+		if user == "" {
+			user = "root"
+		}
+		fmt.Fprintln(p.stdout, user)
+	default:
+		fmt.Fprintf(p.stderr, "%s: command not found\n", p.argv[0])
+		return 127
+	}
+	return 0
+}
+
+func (p *simProcess) status() hcsschema.ProcessStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return hcsschema.ProcessStatus{
+		ProcessId: int32(p.pid),
+		Exited:    p.exited,
+		ExitCode:  p.exitCode,
+	}
+}
+
+func (p *simProcess) details() hcsschema.ProcessDetails {
+	running := int32(time.Since(p.created) / 100 / 50)
+	return hcsschema.ProcessDetails{
+		ProcessId:                    int32(p.pid),
+		ImageName:                    p.argv[0],
+		CreateTimestamp:              p.created,
+		UserTime100ns:                running * 3 / 4,
+		KernelTime100ns:              running / 4,
+		MemoryCommitBytes:            4 * 1024 * 1024,
+		MemoryWorkingSetPrivateBytes: 2 * 1024 * 1024,
+		MemoryWorkingSetSharedBytes:  1024 * 1024,
+	}
+}
+
+func (sys *simSystem) processList() []hcsschema.ProcessDetails {
+	list := make([]hcsschema.ProcessDetails, 0, len(sys.processes))
+	for _, p := range sys.processes {
+		list = append(list, p.details())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ProcessId < list[j].ProcessId })
+	return list
+}
+
+// splitCommandLine splits a command line into arguments on whitespace,
+// honoring double quotes.
+func splitCommandLine(s string) []string {
+	var (
+		args   []string
+		arg    strings.Builder
+		quoted bool
+		inArg  bool
+	)
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// simProcessHandle is a handle to a simulated process.
+type simProcessHandle struct {
+	proc   *simProcess
+	closed bool
+}
+
+var _ Process = &simProcessHandle{}
+
+func (h *simProcessHandle) Close() { h.closed = true }
+
+// do starts op as an operation of type typ, that runs fn against the process
+// once it completes.
+func (h *simProcessHandle) do(op Operation, typ OperationType, fn func(p *simProcess) (string, error)) error {
+	if h.closed {
+		return E_HANDLE
+	}
+	o, err := h.proc.sim.operation(op)
+	if err != nil {
+		return err
+	}
+	return o.start(typ, func() (string, error) { return fn(h.proc) })
+}
+
+func (p *simProcess) requireRunning() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.exited {
+		return HCS_E_PROCESS_ALREADY_STOPPED
+	}
+	return nil
+}
+
+// Signal delivers a signal to the process. Signals that would normally end a
+// process kill it, with the exit code a guest would report. Other signals are
+// ignored.
+func (h *simProcessHandle) Signal(op Operation, options string) error {
+	return h.do(op, OperationTypeSignalProcess, func(p *simProcess) (string, error) {
+		if err := p.requireRunning(); err != nil {
+			return "", err
+		}
+		var opts struct{ Signal json.RawMessage }
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return "", HCS_E_INVALID_JSON
+		}
+		var (
+			signal int
+			event  hcsschema.SignalValueWCOW
+		)
+		if err := json.Unmarshal(opts.Signal, &signal); err == nil {
+			switch signal {
+			case 1, 2, 3, 6, 9, 15:
+				p.terminate(int32(128 + signal))
+			}
+			return "", nil
+		}
+		if err := json.Unmarshal(opts.Signal, &event); err == nil {
+			switch event {
+			case hcsschema.SignalValueWCOWCtrlC, hcsschema.SignalValueWCOWCtrlBreak, hcsschema.SignalValueWCOWCtrlClose,
+				hcsschema.SignalValueWCOWCtrlLogOff, hcsschema.SignalValueWCOWCtrlShutdown:
+				// STATUS_CONTROL_C_EXIT
+				p.terminate(-1073741510)
+				return "", nil
+			}
+		}
+		return "", E_INVALIDARG
+	})
+}
+
+func (h *simProcessHandle) Terminate(op Operation, options string) error {
+	return h.do(op, OperationTypeTerminate, func(p *simProcess) (string, error) {
+		if err := p.requireRunning(); err != nil {
+			return "", err
+		}
+		p.terminate(137)
+		return "", nil
+	})
+}
+
+func (h *simProcessHandle) Modify(op Operation, settings string) error {
+	return h.do(op, OperationTypeModifyProcess, func(p *simProcess) (string, error) {
+		if err := p.requireRunning(); err != nil {
+			return "", err
+		}
+		var req hcsschema.ProcessModifyRequest
+		if err := json.Unmarshal([]byte(settings), &req); err != nil {
+			return "", HCS_E_INVALID_JSON
+		}
+		switch req.Operation {
+		case hcsschema.ModifyProcessTypeConsoleSize:
+			if !p.params.EmulateConsole || req.ConsoleSize == nil {
+				return "", E_INVALIDARG
+			}
+			p.mu.Lock()
+			p.consoleSize = *req.ConsoleSize
+			p.mu.Unlock()
+		case hcsschema.ModifyProcessTypeCloseHandle:
+			if req.CloseHandle == nil || req.CloseHandle.Handle != hcsschema.STDInHandle || p.stdinHost == nil {
+				return "", E_INVALIDARG
+			}
+			p.stdinHost.Close()
+		default:
+			return "", E_INVALIDARG
+		}
+		return "", nil
+	})
+}
+
+func (h *simProcessHandle) GetProperties(op Operation, query string) error {
+	return h.do(op, OperationTypeGetProcessProperties, func(p *simProcess) (string, error) {
+		j, err := json.Marshal(p.status())
+		if err != nil {
+			return "", err
+		}
+		return string(j), nil
+	})
+}
+
+// SetCallback registers a callback for process events. A simulated process
+// can exit before its creator gets the chance to register a callback, so if it
+// already has, the exit notification is delivered right away.
+func (h *simProcessHandle) SetCallback(callback func(Event)) error {
+	if h.closed {
+		return E_HANDLE
+	}
+	h.proc.mu.Lock()
+	exited := h.proc.exited
+	h.proc.callbacks = append(h.proc.callbacks, callback)
+	h.proc.mu.Unlock()
+	if exited {
+		j, _ := json.Marshal(h.proc.status())
+		go callback(Event{Type: EventTypeProcessExited, Data: string(j)})
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package hcs
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// simPropertyQuery accepts both the PropertyTypes and Queries forms of a
+// property query.
+type simPropertyQuery struct {
+	PropertyTypes []hcsschema.PropertyType
+	Queries       map[string]json.RawMessage
+}
+
+func parsePropertyQuery(query string) (*simPropertyQuery, error) {
+	var q simPropertyQuery
+	if query == "" {
+		return &q, nil
+	}
+	if err := json.Unmarshal([]byte(query), &q); err != nil {
+		return nil, HCS_E_INVALID_JSON
+	}
+	return &q, nil
+}
+
+func (sys *simSystem) properties(query string) (string, error) {
+	q, err := parsePropertyQuery(query)
+	if err != nil {
+		return "", err
+	}
+	for name := range q.Queries {
+		if name != "Basic" {
+			return "", E_NOTIMPL
+		}
+	}
+	doc, err := sys.document()
+	if err != nil {
+		return "", err
+	}
+	props := sys.basicProperties()
+	for _, pt := range q.PropertyTypes {
+		switch pt {
+		case hcsschema.PTMemory:
+			props.Memory = sys.memoryProperties(doc)
+		case hcsschema.PTStatistics:
+			props.Statistics = sys.statistics(doc)
+		case hcsschema.PTProcessList:
+			props.ProcessList = sys.processList()
+		case hcsschema.PTTerminateOnLastHandleClosed:
+			props.TerminateOnLastHandleClosed = doc.ShouldTerminateOnLastHandleClosed
+		default:
+			return "", E_NOTIMPL
+		}
+	}
+	j, err := json.Marshal(props)
+	if err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
+
+// memorySizeMB and processorCount return the configured size of a VM, with
+// the defaults HCS uses when they are unspecified.
+func memorySizeMB(doc *hcsschema.ComputeSystem) uint64 {
+	if vm := doc.VirtualMachine; vm != nil && vm.ComputeTopology != nil && vm.ComputeTopology.Memory != nil {
+		return vm.ComputeTopology.Memory.SizeInMB
+	}
+	if c := doc.Container; c != nil && c.Memory != nil {
+		return c.Memory.SizeInMB
+	}
+	return 1024
+}
+
+func processorCount(doc *hcsschema.ComputeSystem) int32 {
+	if vm := doc.VirtualMachine; vm != nil && vm.ComputeTopology != nil && vm.ComputeTopology.Processor != nil && vm.ComputeTopology.Processor.Count > 0 {
+		return vm.ComputeTopology.Processor.Count
+	}
+	if c := doc.Container; c != nil && c.Processor != nil && c.Processor.Count > 0 {
+		return c.Processor.Count
+	}
+	return 1
+}
+
+func (sys *simSystem) memoryProperties(doc *hcsschema.ComputeSystem) *hcsschema.MemoryInformationForVm {
+	sizeMB := memorySizeMB(doc)
+	pages := int32(sizeMB * 1024 * 1024 / 4096)
+	return &hcsschema.MemoryInformationForVm{
+		VirtualNodeCount: 1,
+		VirtualMachineMemory: &hcsschema.VmMemory{
+			AvailableMemory:       int32(sizeMB / 2),
+			AvailableMemoryBuffer: 20,
+			AssignedMemory:        uint64(pages),
+			BalancingEnabled:      true,
+		},
+		VirtualNodes: []hcsschema.VirtualNodeInfo{{
+			VirtualNodeIndex:      0,
+			PhysicalNodeNumber:    0,
+			VirtualProcessorCount: processorCount(doc),
+			MemoryUsageInPages:    pages,
+		}},
+	}
+}
+
+// statistics fabricates runtime statistics that grow steadily with uptime:
+// each virtual processor is 5% busy, and storage I/O runs at a fixed rate.
+func (sys *simSystem) statistics(doc *hcsschema.ComputeSystem) *hcsschema.Statistics {
+	now := time.Now()
+	stats := &hcsschema.Statistics{
+		Timestamp:          now,
+		ContainerStartTime: sys.started,
+		Processor:          &hcsschema.ProcessorStats{},
+		Memory:             &hcsschema.MemoryStats{},
+		Storage:            &hcsschema.StorageStats{},
+	}
+	if sys.started.IsZero() {
+		return stats
+	}
+	uptime := uint64(now.Sub(sys.started) / 100)
+	runtime := uptime * uint64(processorCount(doc)) / 20
+	sizeBytes := memorySizeMB(doc) * 1024 * 1024
+	seconds := uptime / 10000000
+	stats.Uptime100ns = uptime
+	stats.Processor = &hcsschema.ProcessorStats{
+		TotalRuntime100ns:  runtime,
+		RuntimeUser100ns:   runtime * 3 / 4,
+		RuntimeKernel100ns: runtime / 4,
+	}
+	stats.Memory = &hcsschema.MemoryStats{
+		MemoryUsageCommitBytes:            sizeBytes / 2,
+		MemoryUsageCommitPeakBytes:        sizeBytes * 3 / 4,
+		MemoryUsagePrivateWorkingSetBytes: sizeBytes * 2 / 5,
+	}
+	stats.Storage = &hcsschema.StorageStats{
+		ReadCountNormalized:  seconds * 64,
+		ReadSizeBytes:        seconds * 4 * 1024 * 1024,
+		WriteCountNormalized: seconds * 16,
+		WriteSizeBytes:       seconds * 1024 * 1024,
+	}
+	return stats
+}
+
+func (s *Simulator) serviceProperties(query string) (string, error) {
+	var q struct {
+		PropertyTypes   []string
+		PropertyQueries map[string]json.RawMessage
+	}
+	if query != "" {
+		if err := json.Unmarshal([]byte(query), &q); err != nil {
+			return "", HCS_E_INVALID_JSON
+		}
+	}
+	names := q.PropertyTypes
+	for name := range q.PropertyQueries {
+		names = append(names, name)
+	}
+	sort.Strings(names[len(q.PropertyTypes):])
+	if len(names) == 0 {
+		names = []string{"Basic"}
+	}
+	var sp hcsschema.ServiceProperties
+	for _, name := range names {
+		var p any
+		switch name {
+		case "Basic":
+			p = struct {
+				SupportedSchemaVersions []hcsschema.Version
+			}{
+				SupportedSchemaVersions: []hcsschema.Version{{Major: 2, Minor: 1}},
+			}
+		case "ProcessorCapabilities":
+			p = struct {
+				ProcessorFeatures              []string
+				XsaveStates                    []string
+				CacheLineFlushSize             uint32
+				ImplementedPhysicalAddressBits uint32
+				MaxVirtualAddressBits          uint32
+			}{
+				ProcessorFeatures:              []string{"Sse3", "Ssse3", "Sse4_1", "Sse4_2", "Popcnt", "Aes", "Avx", "Avx2"},
+				XsaveStates:                    []string{"Avx"},
+				CacheLineFlushSize:             64,
+				ImplementedPhysicalAddressBits: 46,
+				MaxVirtualAddressBits:          48,
+			}
+		case string(hcsschema.PTProcessorTopology):
+			p = simTopology()
+		case string(hcsschema.PTCPUGroup):
+			s.mu.Lock()
+			p = s.cpuGroupList()
+			s.mu.Unlock()
+		default:
+			return "", E_NOTIMPL
+		}
+		j, err := json.Marshal(p)
+		if err != nil {
+			return "", err
+		}
+		sp.Properties = append(sp.Properties, j)
+	}
+	j, err := json.Marshal(sp)
+	if err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
@@ -0,0 +1,331 @@
+package hcs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testVM = `{"SchemaVersion":{"Major":2,"Minor":1},"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024},"Processor":{"Count":2}}}}`
+
+// run starts an operation with start and waits for its result.
+func run(t *testing.T, s *Simulator, start func(op Operation) error) error {
+	t.Helper()
+	op := s.NewOperation()
+	defer op.Close()
+	if err := start(op); err != nil {
+		return err
+	}
+	_, err := op.WaitResult(Infinite)
+	return err
+}
+
+func createSystem(t *testing.T, s *Simulator, id, config string) System {
+	t.Helper()
+	var sys System
+	err := run(t, s, func(op Operation) (err error) {
+		sys, err = s.CreateComputeSystem(id, config, op)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("create %s: %s", id, err)
+	}
+	return sys
+}
+
+func simState(s *Simulator, id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sys, ok := s.systems[id]; ok {
+		return sys.state
+	}
+	return "gone"
+}
+
+// simCalls are the System calls the state machine tests make, by name.
+var simCalls = map[string]func(sys System, op Operation) error{
+	"Start":     func(sys System, op Operation) error { return sys.Start(op, "") },
+	"Shutdown":  func(sys System, op Operation) error { return sys.Shutdown(op, "") },
+	"Terminate": func(sys System, op Operation) error { return sys.Terminate(op, "") },
+	"Crash":     func(sys System, op Operation) error { return sys.Crash(op, "") },
+	"Pause":     func(sys System, op Operation) error { return sys.Pause(op, "") },
+	"Resume":    func(sys System, op Operation) error { return sys.Resume(op, "") },
+	"Template":  func(sys System, op Operation) error { return sys.Save(op, `{"SaveType":"AsTemplate"}`) },
+	"Reboot":    func(sys System, op Operation) error { return sys.Shutdown(op, `{"Type":"Reboot"}`) },
+}
+
+func TestSimulatorStateMachine(t *testing.T) {
+	type step struct {
+		call  string
+		err   error
+		state string
+	}
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{"start and shut down", []step{
+			{"Start", nil, "Running"},
+			{"Start", HCS_E_INVALID_STATE, "Running"},
+			{"Shutdown", nil, "Stopped"},
+			{"Start", HCS_E_SYSTEM_ALREADY_STOPPED, "Stopped"},
+			{"Shutdown", HCS_E_SYSTEM_ALREADY_STOPPED, "Stopped"},
+			{"Terminate", HCS_E_SYSTEM_ALREADY_STOPPED, "Stopped"},
+		}},
+		{"pause and resume", []step{
+			{"Pause", HCS_E_INVALID_STATE, "Created"},
+			{"Start", nil, "Running"},
+			{"Resume", HCS_E_INVALID_STATE, "Running"},
+			{"Pause", nil, "Paused"},
+			{"Pause", HCS_E_INVALID_STATE, "Paused"},
+			{"Shutdown", HCS_E_INVALID_STATE, "Paused"},
+			{"Resume", nil, "Running"},
+		}},
+		{"terminate before start", []step{
+			{"Shutdown", HCS_E_INVALID_STATE, "Created"},
+			{"Terminate", nil, "Stopped"},
+		}},
+		{"crash", []step{
+			{"Crash", HCS_E_INVALID_STATE, "Created"},
+			{"Start", nil, "Running"},
+			{"Crash", nil, "Stopped"},
+		}},
+		{"reboot keeps running", []step{
+			{"Start", nil, "Running"},
+			{"Reboot", nil, "Running"},
+		}},
+		{"save as template", []step{
+			{"Start", nil, "Running"},
+			{"Template", HCS_E_INVALID_STATE, "Running"},
+			{"Pause", nil, "Paused"},
+			{"Template", nil, "SavedAsTemplate"},
+			{"Resume", HCS_E_INVALID_STATE, "SavedAsTemplate"},
+			{"Terminate", nil, "Stopped"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSimulator()
+			sys := createSystem(t, s, "vm", testVM)
+			defer sys.Close()
+			if got := simState(s, "vm"); got != "Created" {
+				t.Fatalf("state after create = %s, want Created", got)
+			}
+			for i, st := range tc.steps {
+				err := run(t, s, func(op Operation) error { return simCalls[st.call](sys, op) })
+				if !errors.Is(err, st.err) {
+					t.Errorf("step %d: %s = %v, want %v", i+1, st.call, err, st.err)
+				}
+				if got := simState(s, "vm"); got != st.state {
+					t.Errorf("step %d: state after %s = %s, want %s", i+1, st.call, got, st.state)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatorCreate(t *testing.T) {
+	s := NewSimulator()
+	sys := createSystem(t, s, "vm", testVM)
+	defer sys.Close()
+	for _, tc := range []struct {
+		name, id, config string
+		err              error
+	}{
+		{"duplicate ID", "vm", testVM, HCS_E_SYSTEM_ALREADY_EXISTS},
+		{"invalid JSON", "other", `{`, HCS_E_INVALID_JSON},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := run(t, s, func(op Operation) error {
+				_, err := s.CreateComputeSystem(tc.id, tc.config, op)
+				return err
+			})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("create = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestSimulatorHandles(t *testing.T) {
+	s := NewSimulator()
+	sys := createSystem(t, s, "vm", testVM)
+	other, err := s.OpenComputeSystem("vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OpenComputeSystem("missing"); !errors.Is(err, HCS_E_SYSTEM_NOT_FOUND) {
+		t.Errorf("open missing = %v, want HCS_E_SYSTEM_NOT_FOUND", err)
+	}
+	if err := run(t, s, func(op Operation) error { return sys.Terminate(op, "") }); err != nil {
+		t.Fatal(err)
+	}
+	// A stopped system lives on until its last handle is closed.
+	sys.Close()
+	if got := simState(s, "vm"); got != "Stopped" {
+		t.Errorf("state with a handle open = %s, want Stopped", got)
+	}
+	if err := run(t, s, func(op Operation) error { return sys.Start(op, "") }); !errors.Is(err, E_HANDLE) {
+		t.Errorf("start on closed handle = %v, want E_HANDLE", err)
+	}
+	other.Close()
+	if got := simState(s, "vm"); got != "gone" {
+		t.Errorf("state with no handles open = %s, want it gone", got)
+	}
+}
+
+func TestSimulatorFailNext(t *testing.T) {
+	s := NewSimulator()
+	if err := s.FailNext("NoSuchMethod", E_INVALIDARG); err == nil {
+		t.Error("FailNext of an unknown method succeeded")
+	}
+	sys := createSystem(t, s, "vm", testVM)
+	defer sys.Close()
+
+	// Failures for a method are returned in order, then calls succeed.
+	for _, hr := range []HRESULT{E_ACCESSDENIED, HCS_E_SERVICE_DISCONNECT} {
+		if err := s.FailNext("Start", hr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []error{E_ACCESSDENIED, HCS_E_SERVICE_DISCONNECT, nil} {
+		err := run(t, s, func(op Operation) error { return sys.Start(op, "") })
+		if !errors.Is(err, want) {
+			t.Errorf("start = %v, want %v", err, want)
+		}
+	}
+	if got := simState(s, "vm"); got != "Running" {
+		t.Errorf("state = %s, want Running", got)
+	}
+
+	// Synchronous calls fail directly.
+	if err := s.FailNext("OpenComputeSystem", E_ACCESSDENIED); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OpenComputeSystem("vm"); !errors.Is(err, E_ACCESSDENIED) {
+		t.Errorf("open = %v, want E_ACCESSDENIED", err)
+	}
+
+	// Failed creates leave nothing behind.
+	if err := s.FailNext("CreateComputeSystem", HCS_E_INVALID_JSON); err != nil {
+		t.Fatal(err)
+	}
+	err := run(t, s, func(op Operation) error {
+		_, err := s.CreateComputeSystem("vm2", testVM, op)
+		return err
+	})
+	if !errors.Is(err, HCS_E_INVALID_JSON) {
+		t.Errorf("create = %v, want HCS_E_INVALID_JSON", err)
+	}
+	if got := simState(s, "vm2"); got != "gone" {
+		t.Errorf("failed create left vm2 %s", got)
+	}
+
+	s.FailNext("Pause", E_ACCESSDENIED)
+	s.HangNext("Pause")
+	s.ClearFailures()
+	if err := run(t, s, func(op Operation) error { return sys.Pause(op, "") }); err != nil {
+		t.Errorf("pause after ClearFailures = %v", err)
+	}
+}
+
+func TestSimulatorHangNext(t *testing.T) {
+	s := NewSimulator()
+	sys := createSystem(t, s, "vm", testVM)
+	defer sys.Close()
+	if err := run(t, s, func(op Operation) error { return sys.Start(op, "") }); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		s.HangNext("Pause")
+		op := s.NewOperation()
+		defer op.Close()
+		if err := sys.Pause(op, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := op.WaitResult(10); !IsTimeout(err) {
+			t.Fatalf("wait on hung operation = %v, want a timeout", err)
+		}
+		if _, err := op.Result(); !errors.Is(err, HCS_E_OPERATION_PENDING) {
+			t.Errorf("result of hung operation = %v, want HCS_E_OPERATION_PENDING", err)
+		}
+		if err := op.Cancel(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := op.WaitResult(Infinite); !errors.Is(err, ERROR_CANCELLED) {
+			t.Errorf("cancelled operation = %v, want ERROR_CANCELLED", err)
+		}
+		if err := op.Cancel(); !errors.Is(err, HCS_E_OPERATION_ALREADY_CANCELLED) {
+			t.Errorf("second cancel = %v, want HCS_E_OPERATION_ALREADY_CANCELLED", err)
+		}
+		if got := simState(s, "vm"); got != "Running" {
+			t.Errorf("state = %s, want Running", got)
+		}
+	})
+
+	t.Run("context deadline", func(t *testing.T) {
+		s.HangNext("Pause")
+		op := s.NewOperation()
+		defer op.Close()
+		if err := sys.Pause(op, ""); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := WaitResult(ctx, op); !errors.Is(err, ErrTimedOut) {
+			t.Errorf("WaitResult = %v, want ErrTimedOut", err)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		s.HangNext("Pause")
+		op := s.NewOperation()
+		defer op.Close()
+		if err := sys.Pause(op, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := run(t, s, func(op Operation) error { return sys.Terminate(op, "") }); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := op.WaitResult(Infinite); !errors.Is(err, HCS_E_TERMINATED) {
+			t.Errorf("hung operation after stop = %v, want HCS_E_TERMINATED", err)
+		}
+	})
+}
+
+func TestSimulatorModify(t *testing.T) {
+	s := NewSimulator()
+	sys := createSystem(t, s, "vm", testVM)
+	defer sys.Close()
+	modify := func(req string) error {
+		return run(t, s, func(op Operation) error { return sys.Modify(op, req) })
+	}
+	for _, tc := range []struct {
+		name, req string
+		err       error
+	}{
+		{"update", `{"ResourcePath":"VirtualMachine/ComputeTopology/Memory/SizeInMB","RequestType":"Update","Settings":2048}`, nil},
+		{"add", `{"ResourcePath":"VirtualMachine/Devices/Scsi/0","RequestType":"Add","Settings":{}}`, nil},
+		{"add existing", `{"ResourcePath":"virtualmachine/devices/scsi/0","RequestType":"Add","Settings":{}}`, ERROR_ALREADY_EXISTS},
+		{"remove, ignoring case", `{"ResourcePath":"virtualmachine/devices/scsi/0","RequestType":"Remove"}`, nil},
+		{"remove missing", `{"ResourcePath":"VirtualMachine/Devices/Scsi/0","RequestType":"Remove"}`, ERROR_NOT_FOUND},
+		{"bad request type", `{"ResourcePath":"VirtualMachine/Devices","RequestType":"Replace"}`, E_INVALIDARG},
+		{"guest request before start", `{"GuestRequest":{"ResourceType":"MappedVirtualDisk","RequestType":"Add"}}`, HCS_E_INVALID_STATE},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := modify(tc.req); !errors.Is(err, tc.err) {
+				t.Errorf("modify = %v, want %v", err, tc.err)
+			}
+		})
+	}
+	s.mu.Lock()
+	doc, err := s.systems["vm"].document()
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.VirtualMachine.ComputeTopology.Memory.SizeInMB; got != 2048 {
+		t.Errorf("SizeInMB = %d, want 2048", got)
+	}
+}
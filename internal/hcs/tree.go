@@ -0,0 +1,174 @@
+package hcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// The functions in this file work on a compute system document decoded into
+// generic maps and slices, as the simulator keeps each system's configuration
+// and as hcstool tracks the configuration of the systems it changes.
+
+// listResources are the resources that HCS keeps as lists, and so are added
+// to element by element even when the document did not have them.
+var listResources = map[string]bool{
+	"Shares": true,
+}
+
+// DecodeTree decodes a JSON document into generic maps, preserving numbers
+// exactly.
+func DecodeTree(j []byte) (map[string]any, error) {
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var tree map[string]any
+	if err := d.Decode(&tree); err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		tree = make(map[string]any)
+	}
+	return tree, nil
+}
+
+// DecodeValue decodes any JSON value the way DecodeTree decodes a document.
+// An empty value decodes to nil.
+func DecodeValue(j []byte) (any, error) {
+	if len(bytes.TrimSpace(j)) == 0 {
+		return nil, nil
+	}
+	t, err := DecodeTree([]byte(`{"v":` + string(j) + `}`))
+	if err != nil {
+		return nil, err
+	}
+	return t["v"], nil
+}
+
+// LookupTree returns the value at a resource path of a document tree, e.g.
+// VirtualMachine/Devices/Scsi/0/Attachments/1. Names are matched regardless
+// of case, as HCS does.
+func LookupTree(tree map[string]any, path string) (any, bool) {
+	var v any = tree
+	for _, e := range strings.Split(strings.Trim(path, "/"), "/") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[treeKey(m, e)]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// ApplyModify applies a ModifySettingRequest to a document tree the way HCS
+// applies it to a system. Adding to or removing from a list adds or removes a
+// single element, and updating an object sets only the fields given.
+func ApplyModify(tree map[string]any, path, requestType string, settings any) error {
+	switch requestType {
+	case "Add", "Remove", "Update":
+	default:
+		return E_INVALIDARG
+	}
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+	parent := tree
+	for _, e := range elems[:len(elems)-1] {
+		key := treeKey(parent, e)
+		next, ok := parent[key].(map[string]any)
+		if !ok {
+			if requestType == "Remove" {
+				return ERROR_NOT_FOUND
+			}
+			next = make(map[string]any)
+			parent[key] = next
+		}
+		parent = next
+	}
+	key := treeKey(parent, elems[len(elems)-1])
+	existing, exists := parent[key]
+	switch requestType {
+	case "Add":
+		if list, ok := existing.([]any); ok {
+			parent[key] = append(list, settings)
+		} else if exists {
+			return ERROR_ALREADY_EXISTS
+		} else if listResources[key] {
+			parent[key] = []any{settings}
+		} else {
+			parent[key] = settings
+		}
+	case "Remove":
+		if !exists {
+			return ERROR_NOT_FOUND
+		}
+		if list, ok := existing.([]any); ok && settings != nil {
+			for i, item := range list {
+				if SameElement(item, settings) {
+					parent[key] = append(list[:i:i], list[i+1:]...)
+					return nil
+				}
+			}
+			return ERROR_NOT_FOUND
+		}
+		delete(parent, key)
+	case "Update":
+		em, ok1 := existing.(map[string]any)
+		sm, ok2 := settings.(map[string]any)
+		if ok1 && ok2 {
+			for k, v := range sm {
+				em[treeKey(em, k)] = v
+			}
+		} else {
+			parent[key] = settings
+		}
+	}
+	return nil
+}
+
+// SameElement reports whether a list element matches the settings of a
+// Remove request. Elements with a Name are matched by name.
+func SameElement(item, settings any) bool {
+	im, ok1 := item.(map[string]any)
+	sm, ok2 := settings.(map[string]any)
+	if ok1 && ok2 {
+		if name, ok := sm["Name"]; ok {
+			return im["Name"] == name
+		}
+	}
+	return reflect.DeepEqual(item, settings)
+}
+
+// CloneTree returns a deep copy of a value of a document tree.
+func CloneTree(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = CloneTree(e)
+		}
+		return m
+	case []any:
+		l := make([]any, len(v))
+		for i, e := range v {
+			l[i] = CloneTree(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// treeKey returns the key of m that matches name regardless of case, or name
+// if there is none.
+func treeKey(m map[string]any, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
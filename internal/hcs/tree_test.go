@@ -0,0 +1,164 @@
+package hcs
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApplyModify(t *testing.T) {
+	const doc = `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024,"EnableHotHint":true}},"Devices":{"Plan9":{"Shares":[{"Name":"a","Port":564},{"Name":"b","Port":564}]}}}}`
+	for _, tc := range []struct {
+		name, path, requestType, settings string
+		err                               error
+		// want is the document after the request, if it succeeds.
+		want string
+	}{
+		{
+			name: "update a value", path: "VirtualMachine/ComputeTopology/Memory/SizeInMB", requestType: "Update", settings: `2048`,
+			want: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":2048,"EnableHotHint":true}},"Devices":{"Plan9":{"Shares":[{"Name":"a","Port":564},{"Name":"b","Port":564}]}}}}`,
+		},
+		{
+			name: "update an object sets only the fields given", path: "virtualmachine/computetopology/memory", requestType: "Update", settings: `{"enableHotHint":false,"EnableColdHint":true}`,
+			want: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024,"EnableHotHint":false,"EnableColdHint":true}},"Devices":{"Plan9":{"Shares":[{"Name":"a","Port":564},{"Name":"b","Port":564}]}}}}`,
+		},
+		{
+			name: "add creates the parents", path: "VirtualMachine/Devices/Scsi/0", requestType: "Add", settings: `{}`,
+			want: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024,"EnableHotHint":true}},"Devices":{"Scsi":{"0":{}},"Plan9":{"Shares":[{"Name":"a","Port":564},{"Name":"b","Port":564}]}}}}`,
+		},
+		{
+			name: "add to a list", path: "VirtualMachine/Devices/Plan9/Shares", requestType: "Add", settings: `{"Name":"c"}`,
+			want: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024,"EnableHotHint":true}},"Devices":{"Plan9":{"Shares":[{"Name":"a","Port":564},{"Name":"b","Port":564},{"Name":"c"}]}}}}`,
+		},
+		{
+			name: "add a list resource", path: "VirtualMachine/Devices/VirtualSmb/Shares", requestType: "Add", settings: `{"Name":"c"}`,
+			want: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024,"EnableHotHint":true}},"Devices":{"VirtualSmb":{"Shares":[{"Name":"c"}]},"Plan9":{"Shares":[{"Name":"a","Port":564},{"Name":"b","Port":564}]}}}}`,
+		},
+		{
+			name: "add existing", path: "VirtualMachine/ComputeTopology/Memory", requestType: "Add", settings: `{}`,
+			err: ERROR_ALREADY_EXISTS,
+		},
+		{
+			name: "remove a list element by name", path: "VirtualMachine/Devices/Plan9/Shares", requestType: "Remove", settings: `{"Name":"a"}`,
+			want: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024,"EnableHotHint":true}},"Devices":{"Plan9":{"Shares":[{"Name":"b","Port":564}]}}}}`,
+		},
+		{
+			name: "remove a missing list element", path: "VirtualMachine/Devices/Plan9/Shares", requestType: "Remove", settings: `{"Name":"z"}`,
+			err: ERROR_NOT_FOUND,
+		},
+		{
+			name: "remove, ignoring case", path: "virtualmachine/computetopology", requestType: "Remove",
+			want: `{"VirtualMachine":{"Devices":{"Plan9":{"Shares":[{"Name":"a","Port":564},{"Name":"b","Port":564}]}}}}`,
+		},
+		{
+			name: "remove missing", path: "VirtualMachine/Devices/Scsi", requestType: "Remove",
+			err: ERROR_NOT_FOUND,
+		},
+		{
+			name: "remove under a missing parent", path: "VirtualMachine/Devices/Scsi/0", requestType: "Remove",
+			err: ERROR_NOT_FOUND,
+		},
+		{
+			name: "unknown request type", path: "VirtualMachine", requestType: "Replace",
+			err: E_INVALIDARG,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tree, err := DecodeTree([]byte(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			settings, err := DecodeValue([]byte(tc.settings))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ApplyModify(tree, tc.path, tc.requestType, settings)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("ApplyModify = %v, want %v", err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			want, err := DecodeTree([]byte(tc.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(tree)
+			wantJSON, _ := json.Marshal(want)
+			if string(got) != string(wantJSON) {
+				t.Errorf("document = %s\nwant %s", got, wantJSON)
+			}
+		})
+	}
+}
+
+func TestLookupTree(t *testing.T) {
+	tree, err := DecodeTree([]byte(`{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024}},"Devices":{"Scsi":{"0":{}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path  string
+		want  string
+		found bool
+	}{
+		{"VirtualMachine/ComputeTopology/Memory/SizeInMB", "1024", true},
+		{"/virtualmachine/computetopology/memory/sizeinmb/", "1024", true},
+		{"VirtualMachine/Devices/Scsi/0", "{}", true},
+		{"VirtualMachine/Devices/Scsi/1", "", false},
+		{"VirtualMachine/ComputeTopology/Memory/SizeInMB/Value", "", false},
+	} {
+		v, found := LookupTree(tree, tc.path)
+		if found != tc.found {
+			t.Errorf("LookupTree(%s) found = %v, want %v", tc.path, found, tc.found)
+			continue
+		}
+		if !found {
+			continue
+		}
+		if got, _ := json.Marshal(v); string(got) != tc.want {
+			t.Errorf("LookupTree(%s) = %s, want %s", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestCloneTree(t *testing.T) {
+	tree, err := DecodeTree([]byte(`{"a":{"b":[1,{"c":2}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := CloneTree(tree).(map[string]any)
+	if err := ApplyModify(clone, "a/b", "Add", json.Number("3")); err != nil {
+		t.Fatal(err)
+	}
+	clone["a"].(map[string]any)["b"].([]any)[1].(map[string]any)["c"] = json.Number("4")
+	if got, _ := json.Marshal(tree); string(got) != `{"a":{"b":[1,{"c":2}]}}` {
+		t.Errorf("changing the clone changed the original to %s", got)
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		err      bool
+	}{
+		{"", "null", false},
+		{"  ", "null", false},
+		{"12345678901234567890", "12345678901234567890", false},
+		{`{"a":[1.50]}`, `{"a":[1.50]}`, false},
+		{`{"a":`, "", true},
+	} {
+		v, err := DecodeValue([]byte(tc.in))
+		if (err != nil) != tc.err {
+			t.Errorf("DecodeValue(%q) error = %v, want error %v", tc.in, err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// Numbers are kept as written.
+		if got, _ := json.Marshal(v); string(got) != tc.want {
+			t.Errorf("DecodeValue(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
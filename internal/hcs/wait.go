@@ -0,0 +1,90 @@
+package hcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrCancelled is returned when a wait is abandoned because its context
+	// was cancelled.
+	ErrCancelled = errors.New("cancelled")
+	// ErrTimedOut is returned when a wait is abandoned because its context
+	// deadline passed.
+	ErrTimedOut = errors.New("timed out")
+)
+
+// pollInterval is how often a wait checks whether its context is done.
+const pollInterval = 100 * time.Millisecond
+
+// WaitResult waits for op to complete, like Operation.WaitResult, but gives up
+// once ctx is done. In that case the operation is cancelled, and an error
+// wrapping ErrCancelled or ErrTimedOut is returned.
+func WaitResult(ctx context.Context, op Operation) (string, error) {
+	var result string
+	err := wait(ctx, op, func(timeoutMS uint32) (err error) {
+		result, err = op.WaitResult(timeoutMS)
+		return err
+	})
+	return result, err
+}
+
+// WaitResultAndProcessInfo is the context-aware form of
+// Operation.WaitResultAndProcessInfo.
+func WaitResultAndProcessInfo(ctx context.Context, op Operation) (string, *ProcessInfo, error) {
+	var (
+		result string
+		info   *ProcessInfo
+	)
+	err := wait(ctx, op, func(timeoutMS uint32) (err error) {
+		result, info, err = op.WaitResultAndProcessInfo(timeoutMS)
+		return err
+	})
+	return result, info, err
+}
+
+// wait calls waitFn in short slices until the operation completes or ctx is
+// done. The operation handle is never waited on after wait returns, so it is
+// safe for the caller to close it even if HCS does not honor the cancellation.
+func wait(ctx context.Context, op Operation, waitFn func(timeoutMS uint32) error) error {
+	for {
+		if ctx.Err() != nil {
+			return cancel(ctx, op)
+		}
+		timeout := pollInterval
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = max(time.Until(deadline), 0)
+		}
+		err := waitFn(uint32(timeout.Milliseconds()))
+		// A completed operation can itself fail with a timeout, so make sure
+		// it is really still pending before waiting again.
+		if !IsTimeout(err) || !isPending(op) {
+			return err
+		}
+	}
+}
+
+func isPending(op Operation) bool {
+	_, err := op.Result()
+	hr, ok := hresultOf(err)
+	return ok && hr == HCS_E_OPERATION_PENDING
+}
+
+// ContextError returns ErrTimedOut if ctx is done because its deadline passed,
+// and ErrCancelled otherwise.
+func ContextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrTimedOut
+	}
+	return ErrCancelled
+}
+
+func cancel(ctx context.Context, op Operation) error {
+	reason := ContextError(ctx)
+	if err := op.Cancel(); err != nil {
+		return fmt.Errorf("%s operation %w (cancelling it failed: %s)", op.Type(), reason, err)
+	}
+	return fmt.Errorf("%s operation %w", op.Type(), reason)
+}
@@ -13,3 +13,7 @@ package hcsschema
 type CpuGroup struct {
 	Id string `json:"Id,omitempty"`
 }
+
+// NullCpuGroupId is the CPU group of VMs that are not in one. This is not
+// generated by swagger, and was added manually.
+const NullCpuGroupId = "00000000-0000-0000-0000-000000000000"
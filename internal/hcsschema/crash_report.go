@@ -0,0 +1,52 @@
+/*
+ * HCS API
+ *
+ * No description provided (generated by Swagger Codegen https://github.com/swagger-api/swagger-codegen)
+ *
+ * API version: 2.1
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package hcsschema
+
+//  Document provided in the EventData parameter of an HcsEventSystemCrashInitiated or HcsEventSystemCrashReport HCS_EVENT.
+type CrashReport struct {
+	//  Compute system id the CrashReport is for.
+	SystemId string `json:"SystemId,omitempty"`
+
+	//  Trace correlation activity Id.
+	ActivityId string `json:"ActivityId,omitempty"`
+
+	//  Additional Windows specific crash report information. This information is only present if the guest is a Windows OS.
+	WindowsCrashInfo *WindowsCrashReport `json:"WindowsCrashInfo,omitempty"`
+
+	//  Crash parameters as reported by the guest OS. For Windows these correspond to the bug check code followed by 4 bug check code specific values.
+	CrashParameters []uint64 `json:"CrashParameters,omitempty"`
+
+	//  Text log from the guest, if configured.
+	CrashLog string `json:"CrashLog,omitempty"`
+
+	//  Status of the crash dump operation (HRESULT).
+	Status int32 `json:"Status,omitempty"`
+
+	//  Opaque guest OS reported ID.
+	PreOSId string `json:"PreOSId,omitempty"`
+}
+
+//  Windows specific crash information
+type WindowsCrashReport struct {
+	//  Path to a Windows memory dump file, if one was written.
+	DumpFile string `json:"DumpFile,omitempty"`
+
+	//  Major version reported by the guest OS.
+	OsMajorVersion int32 `json:"OsMajorVersion,omitempty"`
+
+	//  Minor version reported by the guest OS.
+	OsMinorVersion int32 `json:"OsMinorVersion,omitempty"`
+
+	//  Build number reported by the guest OS.
+	OsBuildNumber int32 `json:"OsBuildNumber,omitempty"`
+
+	//  Last phase the crash dump reached, such as "Complete".
+	FinalPhase string `json:"FinalPhase,omitempty"`
+}
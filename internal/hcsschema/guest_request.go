@@ -0,0 +1,127 @@
+package hcsschema
+
+// The types in this file are not generated by swagger. They are the settings
+// that the guest compute service (GCS) accepts in the GuestRequest field of a
+// ModifySettingRequest, and were added manually.
+
+// GuestResourceType is the kind of guest resource a GuestModificationRequest
+// changes.
+type GuestResourceType string
+
+const (
+	GuestResourceTypeMappedVirtualDisk GuestResourceType = "MappedVirtualDisk"
+	GuestResourceTypeMappedDirectory   GuestResourceType = "MappedDirectory"
+	GuestResourceTypeCombinedLayers    GuestResourceType = "CombinedLayers"
+	GuestResourceTypeNetwork           GuestResourceType = "Network"
+	GuestResourceTypeVPMemDevice       GuestResourceType = "VPMemDevice"
+)
+
+// GuestModificationRequest is the envelope of a request to the guest.
+type GuestModificationRequest struct {
+	ResourceType GuestResourceType `json:"ResourceType,omitempty"`
+
+	RequestType string `json:"RequestType,omitempty"`
+
+	Settings interface{} `json:"Settings,omitempty"`
+}
+
+// LCOWMappedVirtualDisk mounts a SCSI disk in a Linux guest.
+type LCOWMappedVirtualDisk struct {
+	MountPath string `json:"MountPath,omitempty"`
+
+	Lun uint8 `json:"Lun,omitempty"`
+
+	Controller uint8 `json:"Controller,omitempty"`
+
+	ReadOnly bool `json:"ReadOnly,omitempty"`
+
+	Options []string `json:"Options,omitempty"`
+}
+
+// WCOWMappedVirtualDisk mounts a SCSI disk in a Windows guest.
+type WCOWMappedVirtualDisk struct {
+	ContainerPath string `json:"ContainerPath,omitempty"`
+
+	Lun int32 `json:"Lun,omitempty"`
+}
+
+// LCOWMappedDirectory mounts a Plan9 share in a Linux guest. Windows guests
+// take a MappedDirectory.
+type LCOWMappedDirectory struct {
+	MountPath string `json:"MountPath,omitempty"`
+
+	Port int32 `json:"Port,omitempty"`
+
+	// The share's access name. Empty mounts the share without one.
+	ShareName string `json:"ShareName,omitempty"`
+
+	ReadOnly bool `json:"ReadOnly,omitempty"`
+}
+
+// LCOWCombinedLayers overlays read-only layers and a scratch layer to form a
+// container's root in a Linux guest.
+type LCOWCombinedLayers struct {
+	ContainerId string `json:"ContainerId,omitempty"`
+
+	ContainerRootPath string `json:"ContainerRootPath,omitempty"`
+
+	Layers []Layer `json:"Layers,omitempty"`
+
+	ScratchPath string `json:"ScratchPath,omitempty"`
+}
+
+// WCOWCombinedLayers is the Windows guest form of LCOWCombinedLayers.
+type WCOWCombinedLayers struct {
+	ContainerRootPath string `json:"ContainerRootPath,omitempty"`
+
+	Layers []Layer `json:"Layers,omitempty"`
+
+	ScratchPath string `json:"ScratchPath,omitempty"`
+}
+
+// LCOWNetworkAdapter configures a network adapter inside a Linux guest.
+type LCOWNetworkAdapter struct {
+	NamespaceID string `json:",omitempty"`
+
+	ID string `json:",omitempty"`
+
+	MacAddress string `json:",omitempty"`
+
+	IPAddress string `json:",omitempty"`
+
+	PrefixLength uint8 `json:",omitempty"`
+
+	GatewayAddress string `json:",omitempty"`
+
+	DNSSuffix string `json:",omitempty"`
+
+	// Comma-separated DNS server addresses.
+	DNSServerList string `json:",omitempty"`
+}
+
+// WCOWNetworkModifyRequest configures a network adapter inside a Windows
+// guest.
+type WCOWNetworkModifyRequest struct {
+	AdapterId string `json:"AdapterId,omitempty"`
+
+	RequestType string `json:"RequestType,omitempty"`
+
+	Settings *NetworkAdapter `json:"Settings,omitempty"`
+}
+
+// LCOWMappedVPMemDevice mounts a VPMem device, or an image mapped into one,
+// in a Linux guest.
+type LCOWMappedVPMemDevice struct {
+	DeviceNumber uint32 `json:"DeviceNumber,omitempty"`
+
+	MountPath string `json:"MountPath,omitempty"`
+
+	// Where the image is in the device, for devices with several images.
+	MappingInfo *LCOWVPMemMappingInfo `json:"MappingInfo,omitempty"`
+}
+
+type LCOWVPMemMappingInfo struct {
+	DeviceOffsetInBytes uint64 `json:"DeviceOffsetInBytes,omitempty"`
+
+	DeviceSizeInBytes uint64 `json:"DeviceSizeInBytes,omitempty"`
+}
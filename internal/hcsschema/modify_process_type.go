@@ -0,0 +1,21 @@
+/*
+ * HCS API
+ *
+ * No description provided (generated by Swagger Codegen https://github.com/swagger-api/swagger-codegen)
+ *
+ * API version: 2.1
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package hcsschema
+
+//  Values for ProcessModifyRequest.Operation
+const (
+	ModifyProcessTypeConsoleSize = "ConsoleSize"
+	ModifyProcessTypeCloseHandle = "CloseHandle"
+)
+
+//  Values for CloseHandle.Handle
+const (
+	STDInHandle = "StdIn"
+)
@@ -16,6 +16,10 @@ type Processor2 struct {
 
 	Weight int32 `json:"Weight,omitempty"`
 
+	Reservation uint64 `json:"Reservation,omitempty"` // This field is not generated by swagger. This was added manually.
+
+	MaximumFrequencyMHz uint32 `json:"MaximumFrequencyMHz,omitempty"` // This field is not generated by swagger. This was added manually.
+
 	ExposeVirtualizationExtensions bool `json:"ExposeVirtualizationExtensions,omitempty"`
 
 	// An optional object that configures the CPU Group to which a Virtual Machine is going to bind to.
@@ -0,0 +1,41 @@
+/*
+ * HCS API
+ *
+ * No description provided (generated by Swagger Codegen https://github.com/swagger-api/swagger-codegen)
+ *
+ * API version: 2.4
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package hcsschema
+
+type ShutdownMechanism string
+
+const (
+	ShutdownMechanismGuestConnection    ShutdownMechanism = "GuestConnection"
+	ShutdownMechanismIntegrationService ShutdownMechanism = "IntegrationService"
+)
+
+type ShutdownType string
+
+const (
+	ShutdownTypeShutdown  ShutdownType = "Shutdown"
+	ShutdownTypeHibernate ShutdownType = "Hibernate"
+	ShutdownTypeReboot    ShutdownType = "Reboot"
+)
+
+//  Options for HcsShutDownComputeSystem
+type ShutdownOptions struct {
+
+	//  The mechanism used to request the shutdown from the guest.
+	Mechanism ShutdownMechanism `json:"Mechanism,omitempty"`
+
+	//  The type of shutdown to perform.
+	Type ShutdownType `json:"Type,omitempty"`
+
+	//  If set, the guest is not given the chance to cancel the shutdown.
+	Force bool `json:"Force,omitempty"`
+
+	//  A reason for the shutdown, reported to the guest.
+	Reason string `json:"Reason,omitempty"`
+}
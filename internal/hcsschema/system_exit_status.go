@@ -0,0 +1,19 @@
+/*
+ * HCS API
+ *
+ * No description provided (generated by Swagger Codegen https://github.com/swagger-api/swagger-codegen)
+ *
+ * API version: 2.1
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package hcsschema
+
+//  Document provided in the EventData parameter of an HcsEventSystemExited HCS_EVENT.
+type SystemExitStatus struct {
+	//  Exit status (HRESULT) for the system.
+	Status int32 `json:"Status,omitempty"`
+
+	//  Exit type for the system.
+	ExitType string `json:"ExitType,omitempty"`
+}
@@ -15,4 +15,7 @@ type VirtualPMemDevice struct {
 	ReadOnly bool `json:"ReadOnly,omitempty"`
 
 	ImageFormat string `json:"ImageFormat,omitempty"`
+
+	// Mappings of images into the device, by byte offset. Only used when HostPath is empty.
+	Mappings map[uint64]VirtualPMemMapping `json:"Mappings,omitempty"` // This field is not generated by swagger. This was added manually.
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+)
+
+// job is an HCS operation running in the background. Its fields other than
+// done are only safe to read from the completion callback, or once done is
+// closed.
+type job struct {
+	id        int
+	cs        string
+	cmd       string
+	op        hcs.Operation
+	started   time.Time
+	done      chan struct{}
+	finished  time.Time
+	result    string
+	err       error
+	notified  bool
+	timedOut  atomic.Bool
+	cancelled atomic.Bool
+	// timer cancels the operation when its timeout passes, if it has one.
+	timer atomic.Pointer[time.Timer]
+	// onSuccess, if set, is called once the job is seen to have succeeded,
+	// on the REPL's goroutine rather than the completion callback's.
+	onSuccess func()
+}
+
+func setupBackgroundFlag(cf *commonFlags, fs *flag.FlagSet) {
+	cf.bg = fs.Bool("bg", false, "Run the operation as a background job. A trailing & does the same.")
+}
+
+// background reports whether the command should run as a job.
+func background(cf *commonFlags, fs *flag.FlagSet) bool {
+	return *cf.bg || (fs.NArg() > 0 && fs.Arg(fs.NArg()-1) == "&")
+}
+
+// runOp starts an operation on the compute system id with start, and waits
+// for it to complete. If the command was asked to run in the background, it
+// instead returns once the operation has started, and tracks it as a job.
+func runOp(state *state, cf *commonFlags, fs *flag.FlagSet, id string, start func(op hcs.Operation) error) (string, error) {
+	if background(cf, fs) {
+		_, err := startJob(state, cf, id, fs.Name(), start)
+		return "", err
+	}
+	ctx, cancel := opContext(state, cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := start(op); err != nil {
+		return "", err
+	}
+	return hcs.WaitResult(ctx, op)
+}
+
+func startJob(state *state, cf *commonFlags, id, cmd string, start func(op hcs.Operation) error) (*job, error) {
+	op := state.hcs.NewOperation()
+	j := &job{cs: id, cmd: cmd, op: op, done: make(chan struct{})}
+	if err := op.SetCallback(func(hcs.Event) { j.complete() }); err != nil {
+		op.Close()
+		return nil, err
+	}
+	j.started = time.Now()
+	if err := start(op); err != nil {
+		op.Close()
+		return nil, err
+	}
+	if timeout := opTimeout(state, cf); timeout > 0 {
+		j.timer.Store(time.AfterFunc(timeout, func() {
+			if !j.isDone() {
+				j.timedOut.Store(true)
+				op.Cancel()
+			}
+		}))
+		// The operation can complete before the timer is stored.
+		if j.isDone() {
+			j.stopTimer()
+		}
+	}
+	state.nextJob++
+	j.id = state.nextJob
+	state.jobs[j.id] = j
+	fmt.Printf("[%d] started %s operation %d\n", j.id, op.Type(), op.ID())
+	return j, nil
+}
+
+func (j *job) complete() {
+	j.stopTimer()
+	j.finished = time.Now()
+	j.result, j.err = j.op.Result()
+	if j.err != nil {
+		if j.timedOut.Load() {
+			j.err = fmt.Errorf("%s operation %w", j.op.Type(), hcs.ErrTimedOut)
+		} else if j.cancelled.Load() {
+			j.err = fmt.Errorf("%s operation %w", j.op.Type(), hcs.ErrCancelled)
+		}
+	}
+	close(j.done)
+}
+
+// settle marks a finished job as reported, calling its onSuccess the first
+// time.
+func (j *job) settle() {
+	if j.notified {
+		return
+	}
+	j.notified = true
+	if j.err == nil && j.onSuccess != nil {
+		j.onSuccess()
+	}
+}
+
+func (j *job) stopTimer() {
+	if t := j.timer.Load(); t != nil {
+		t.Stop()
+	}
+}
+
+func (j *job) isDone() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (j *job) elapsed() time.Duration {
+	if j.isDone() {
+		return j.finished.Sub(j.started).Round(time.Millisecond)
+	}
+	return time.Since(j.started).Round(time.Millisecond)
+}
+
+func (j *job) status() string {
+	if !j.isDone() {
+		return "running"
+	}
+	if j.err != nil {
+		return j.err.Error()
+	}
+	return "ok"
+}
+
+func getJob(state *state, arg string) (*job, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid job: %s", arg)
+	}
+	j, ok := state.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("no such job: %d", id)
+	}
+	return j, nil
+}
+
+func removeJob(state *state, j *job) {
+	j.op.Close()
+	delete(state.jobs, j.id)
+}
+
+func sortedJobs(state *state) []*job {
+	jobs := make([]*job, 0, len(state.jobs))
+	for _, j := range state.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].id < jobs[b].id })
+	return jobs
+}
+
+// notifyJobs prints a line for each job that has finished since the last
+// prompt.
+func notifyJobs(state *state) {
+	for _, j := range sortedJobs(state) {
+		if j.notified || !j.isDone() {
+			continue
+		}
+		fmt.Printf("[%d] %s %s: %s\n", j.id, j.cmd, j.cs, j.status())
+		j.settle()
+	}
+}
+
+type jobsCommand struct{ clear *bool }
+
+func (c *jobsCommand) Name() string        { return "jobs" }
+func (c *jobsCommand) Description() string { return "Lists background jobs." }
+func (c *jobsCommand) ArgHelp() string     { return "" }
+func (c *jobsCommand) SetupFlags(fs *flag.FlagSet) {
+	c.clear = fs.Bool("clear", false, "Remove finished jobs after listing them.")
+}
+
+func (c *jobsCommand) Execute(state *state, fs *flag.FlagSet) error {
+	jobs := sortedJobs(state)
+	if err := printTable(
+		[]colInfo{{"JOB", "%d"}, {"OPID", "%d"}, {"TYPE", "%s"}, {"CS", "%s"}, {"COMMAND", "%s"}, {"ELAPSED", "%s"}, {"RESULT", "%s"}},
+		jobs,
+		func(j *job) []any {
+			return []any{j.id, j.op.ID(), j.op.Type(), j.cs, j.cmd, j.elapsed(), j.status()}
+		},
+	); err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		if j.isDone() {
+			j.settle()
+			if *c.clear {
+				removeJob(state, j)
+			}
+		}
+	}
+	return nil
+}
+
+type waitCommand struct{ cf commonFlags }
+
+func (c *waitCommand) Name() string { return "wait" }
+func (c *waitCommand) Description() string {
+	return "Waits for a background job to finish, and shows its result."
+}
+func (c *waitCommand) ArgHelp() string             { return "JOB" }
+func (c *waitCommand) SetupFlags(fs *flag.FlagSet) { setupTimeoutFlag(&c.cf, fs) }
+
+func (c *waitCommand) Execute(state *state, fs *flag.FlagSet) error {
+	j, err := getJob(state, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		// Giving up on the wait leaves the job running.
+		return fmt.Errorf("wait for job %d %w", j.id, hcs.ContextError(ctx))
+	}
+	fmt.Printf("[%d] %s operation %d finished in %s\n", j.id, j.op.Type(), j.op.ID(), j.elapsed())
+	j.settle()
+	removeJob(state, j)
+	if j.err != nil {
+		return j.err
+	}
+	if j.result != "" {
+		fmt.Printf("%s\n", j.result)
+	}
+	return nil
+}
+
+type cancelCommand struct{}
+
+func (c *cancelCommand) Name() string                { return "cancel" }
+func (c *cancelCommand) Description() string         { return "Cancels a background job." }
+func (c *cancelCommand) ArgHelp() string             { return "JOB" }
+func (c *cancelCommand) SetupFlags(fs *flag.FlagSet) {}
+
+func (c *cancelCommand) Execute(state *state, fs *flag.FlagSet) error {
+	j, err := getJob(state, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if j.isDone() {
+		return fmt.Errorf("job %d has already finished", j.id)
+	}
+	j.cancelled.Store(true)
+	return j.op.Cancel()
+}
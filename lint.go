@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+type lintSeverity int
+
+const (
+	lintWarning lintSeverity = iota
+	lintError
+)
+
+func (s lintSeverity) String() string {
+	if s == lintError {
+		return "error"
+	}
+	return "warning"
+}
+
+// lintRule is a check for a mistake that the schema alone cannot catch, and
+// that HCS would only reject late, if at all.
+type lintRule struct {
+	id          string
+	severity    lintSeverity
+	description string
+	check       func(doc *hcsschema.ComputeSystem, report reportFunc)
+}
+
+// reportFunc reports a finding at the JSON pointer path.
+type reportFunc func(path, format string, args ...any)
+
+type lintFinding struct {
+	rule *lintRule
+	path string
+	msg  string
+}
+
+var lintRules = []*lintRule{
+	{"memory-size", lintError, "VM memory size is a multiple of 2 MB.", lintMemorySize},
+	{"scsi-lun", lintError, "SCSI LUNs are numbers from 0 to 63, used once per controller.", lintSCSILUN},
+	{"scsi-lun-reuse", lintError, "Each SCSI LUN is used on only one controller.", lintSCSILUNReuse},
+	{"scsi-path-reuse", lintError, "Each SCSI disk is attached only once.", lintSCSIPathReuse},
+	{"nic-mac", lintError, "Network adapter MAC addresses are unicast, in the form 00-15-5D-00-00-00.", lintNICMAC},
+	{"nic-mac-reuse", lintError, "Each network adapter has its own MAC address.", lintNICMACReuse},
+	{"vpmem-count", lintError, "VPMem devices fit within the controller's MaximumCount.", lintVPMemCount},
+	{"shared-memory-overlap", lintError, "Shared memory regions are not empty, and do not overlap.", lintSharedMemory},
+	{"kernel-file", lintError, "LinuxKernelDirect names a kernel, and its files exist.", lintKernelFile},
+	{"restore-stop-on-reset", lintWarning, "VMs restored from saved state set StopOnReset.", lintRestoreStopOnReset},
+	{"container-storage", lintError, "Containers have storage layers and a scratch path.", lintContainerStorage},
+	{"container-mapped-directory", lintError, "Container mapped directories have both a host and a container path.", lintContainerMappedDirectory},
+	{"container-device", lintError, "Container assigned devices have the identifier their type needs.", lintContainerDevice},
+}
+
+// lintDocument runs every rule that is not suppressed against doc.
+func lintDocument(doc *hcsschema.ComputeSystem, suppress map[string]bool) []lintFinding {
+	var findings []lintFinding
+	for _, r := range lintRules {
+		if suppress[r.id] {
+			continue
+		}
+		r := r
+		r.check(doc, func(path, format string, args ...any) {
+			findings = append(findings, lintFinding{r, path, fmt.Sprintf(format, args...)})
+		})
+	}
+	sort.SliceStable(findings, func(a, b int) bool { return findings[a].path < findings[b].path })
+	return findings
+}
+
+func lintMemorySize(doc *hcsschema.ComputeSystem, report reportFunc) {
+	vm := doc.VirtualMachine
+	if vm == nil || vm.ComputeTopology == nil || vm.ComputeTopology.Memory == nil {
+		return
+	}
+	if size := vm.ComputeTopology.Memory.SizeInMB; size%2 != 0 {
+		report("/VirtualMachine/ComputeTopology/Memory/SizeInMB", "%d MB is not a multiple of 2 MB", size)
+	}
+}
+
+func lintSCSILUN(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Devices == nil {
+		return
+	}
+	for _, c := range sortedKeys(doc.VirtualMachine.Devices.Scsi) {
+		seen := make(map[uint64]string)
+		for _, lun := range sortedKeys(doc.VirtualMachine.Devices.Scsi[c].Attachments) {
+			path := "/VirtualMachine/Devices/Scsi/" + escapePointer(c) + "/Attachments/" + escapePointer(lun)
+			n, err := strconv.ParseUint(lun, 10, 8)
+			if err != nil || n > 63 {
+				report(path, "LUN %q is not a number from 0 to 63", lun)
+				continue
+			}
+			if other, ok := seen[n]; ok {
+				report(path, "LUN %q is the same LUN as %q", lun, other)
+				continue
+			}
+			seen[n] = lun
+		}
+	}
+}
+
+func lintSCSILUNReuse(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Devices == nil {
+		return
+	}
+	first := make(map[string]string)
+	for _, c := range sortedKeys(doc.VirtualMachine.Devices.Scsi) {
+		for _, lun := range sortedKeys(doc.VirtualMachine.Devices.Scsi[c].Attachments) {
+			if other, ok := first[lun]; ok {
+				report("/VirtualMachine/Devices/Scsi/"+escapePointer(c)+"/Attachments/"+escapePointer(lun), "LUN %s is also used on controller %s", lun, other)
+				continue
+			}
+			first[lun] = c
+		}
+	}
+}
+
+func lintSCSIPathReuse(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Devices == nil {
+		return
+	}
+	first := make(map[string]string)
+	for _, c := range sortedKeys(doc.VirtualMachine.Devices.Scsi) {
+		attachments := doc.VirtualMachine.Devices.Scsi[c].Attachments
+		for _, lun := range sortedKeys(attachments) {
+			p := attachments[lun].Path
+			if p == "" {
+				continue
+			}
+			path := "/VirtualMachine/Devices/Scsi/" + escapePointer(c) + "/Attachments/" + escapePointer(lun)
+			// Windows paths are not case sensitive.
+			k := strings.ToLower(p)
+			if other, ok := first[k]; ok {
+				report(path+"/Path", "%s is already attached at %s", p, other)
+				continue
+			}
+			first[k] = path
+		}
+	}
+}
+
+// parseMAC parses a MAC address in the hyphenated form HCS uses.
+func parseMAC(s string) (net.HardwareAddr, error) {
+	if strings.Contains(s, ":") {
+		return nil, fmt.Errorf("MAC address %s must use hyphens, not colons", s)
+	}
+	mac, err := net.ParseMAC(s)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("%q is not a MAC address", s)
+	}
+	return mac, nil
+}
+
+func lintNICMAC(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Devices == nil {
+		return
+	}
+	for _, k := range sortedKeys(doc.VirtualMachine.Devices.NetworkAdapters) {
+		s := doc.VirtualMachine.Devices.NetworkAdapters[k].MacAddress
+		if s == "" {
+			continue
+		}
+		path := "/VirtualMachine/Devices/NetworkAdapters/" + escapePointer(k) + "/MacAddress"
+		mac, err := parseMAC(s)
+		if err != nil {
+			report(path, "%s", err)
+		} else if mac[0]&1 != 0 {
+			report(path, "%s is a multicast address", s)
+		}
+	}
+}
+
+func lintNICMACReuse(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Devices == nil {
+		return
+	}
+	first := make(map[string]string)
+	for _, k := range sortedKeys(doc.VirtualMachine.Devices.NetworkAdapters) {
+		mac, err := parseMAC(doc.VirtualMachine.Devices.NetworkAdapters[k].MacAddress)
+		if err != nil {
+			continue
+		}
+		if other, ok := first[mac.String()]; ok {
+			report("/VirtualMachine/Devices/NetworkAdapters/"+escapePointer(k)+"/MacAddress", "MAC address is also used by adapter %s", other)
+			continue
+		}
+		first[mac.String()] = k
+	}
+}
+
+func lintVPMemCount(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Devices == nil || doc.VirtualMachine.Devices.VirtualPMem == nil {
+		return
+	}
+	pmem := doc.VirtualMachine.Devices.VirtualPMem
+	if n := len(pmem.Devices); uint64(n) > uint64(pmem.MaximumCount) {
+		report("/VirtualMachine/Devices/VirtualPMem/MaximumCount", "%d devices do not fit in a MaximumCount of %d", n, pmem.MaximumCount)
+	}
+	for _, k := range sortedKeys(pmem.Devices) {
+		n, err := strconv.ParseUint(k, 10, 32)
+		if err != nil || n >= uint64(pmem.MaximumCount) {
+			report("/VirtualMachine/Devices/VirtualPMem/Devices/"+escapePointer(k), "device %q is not a number below MaximumCount (%d)", k, pmem.MaximumCount)
+		}
+	}
+}
+
+func lintSharedMemory(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Devices == nil || doc.VirtualMachine.Devices.SharedMemory == nil {
+		return
+	}
+	regions := doc.VirtualMachine.Devices.SharedMemory.Regions
+	for i, r := range regions {
+		path := "/VirtualMachine/Devices/SharedMemory/Regions/" + strconv.Itoa(i)
+		if r.StartOffset < 0 || r.Length <= 0 {
+			report(path, "region %q has offset %d and length %d", r.SectionName, r.StartOffset, r.Length)
+			continue
+		}
+		for j, o := range regions[:i] {
+			if o.StartOffset < 0 || o.Length <= 0 {
+				continue
+			}
+			if int64(r.StartOffset) < int64(o.StartOffset)+int64(o.Length) && int64(o.StartOffset) < int64(r.StartOffset)+int64(r.Length) {
+				report(path, "region %q overlaps region %d (%q)", r.SectionName, j, o.SectionName)
+			}
+		}
+	}
+}
+
+func lintKernelFile(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.VirtualMachine == nil || doc.VirtualMachine.Chipset == nil || doc.VirtualMachine.Chipset.LinuxKernelDirect == nil {
+		return
+	}
+	lkd := doc.VirtualMachine.Chipset.LinuxKernelDirect
+	const path = "/VirtualMachine/Chipset/LinuxKernelDirect"
+	if lkd.KernelFilePath == "" {
+		report(path, "KernelFilePath is not set")
+	} else if _, err := os.Stat(lkd.KernelFilePath); err != nil {
+		report(path+"/KernelFilePath", "%s", err)
+	}
+	if lkd.InitRdPath != "" {
+		if _, err := os.Stat(lkd.InitRdPath); err != nil {
+			report(path+"/InitRdPath", "%s", err)
+		}
+	}
+}
+
+func lintRestoreStopOnReset(doc *hcsschema.ComputeSystem, report reportFunc) {
+	vm := doc.VirtualMachine
+	if vm == nil || vm.RestoreState == nil || vm.StopOnReset {
+		return
+	}
+	if vm.RestoreState.SaveStateFilePath != "" || vm.RestoreState.TemplateSystemId != "" {
+		report("/VirtualMachine/StopOnReset", "restored VM does not set StopOnReset, so a guest reset will boot from scratch instead of stopping")
+	}
+}
+
+func lintContainerStorage(doc *hcsschema.ComputeSystem, report reportFunc) {
+	c := doc.Container
+	if c == nil {
+		return
+	}
+	if c.Storage == nil {
+		report("/Container", "Storage is not set")
+		return
+	}
+	if len(c.Storage.Layers) == 0 {
+		report("/Container/Storage", "no Layers are set")
+	}
+	for i, l := range c.Storage.Layers {
+		if l.Path == "" {
+			report("/Container/Storage/Layers/"+strconv.Itoa(i), "layer %q has no Path", l.Id)
+		}
+	}
+	if c.Storage.Path == "" {
+		report("/Container/Storage", "scratch Path is not set")
+	}
+}
+
+func lintContainerMappedDirectory(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.Container == nil {
+		return
+	}
+	for i, md := range doc.Container.MappedDirectories {
+		path := "/Container/MappedDirectories/" + strconv.Itoa(i)
+		if md.HostPath == "" {
+			report(path, "HostPath is not set")
+		}
+		if md.ContainerPath == "" {
+			report(path, "ContainerPath is not set")
+		}
+	}
+}
+
+func lintContainerDevice(doc *hcsschema.ComputeSystem, report reportFunc) {
+	if doc.Container == nil {
+		return
+	}
+	for i, d := range doc.Container.AssignedDevices {
+		path := "/Container/AssignedDevices/" + strconv.Itoa(i)
+		switch d.Type {
+		case hcsschema.ClassGUID, "":
+			if d.InterfaceClassGuid == "" {
+				report(path, "a ClassGuid device needs InterfaceClassGuid")
+			}
+		case hcsschema.DeviceInstanceID, hcsschema.GPUMirror:
+			if d.LocationPath == "" {
+				report(path, "a %s device needs LocationPath", d.Type)
+			}
+		}
+	}
+}
+
+// parseSuppress parses a comma separated list of rule IDs.
+func parseSuppress(s string) (map[string]bool, error) {
+	suppress := make(map[string]bool)
+	if s == "" {
+		return suppress, nil
+	}
+	for _, id := range strings.Split(s, ",") {
+		found := false
+		for _, r := range lintRules {
+			found = found || r.id == id
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown lint rule: %s", id)
+		}
+		suppress[id] = true
+	}
+	return suppress, nil
+}
+
+type lintCommand struct {
+	suppress *string
+	rules    *bool
+}
+
+func (c *lintCommand) Name() string { return "lint" }
+func (c *lintCommand) Description() string {
+	return "Checks a compute system document for semantic mistakes."
+}
+func (c *lintCommand) ArgHelp() string { return "PATH" }
+func (c *lintCommand) SetupFlags(fs *flag.FlagSet) {
+	c.suppress = fs.String("suppress", "", "Comma separated IDs of rules to skip.")
+	c.rules = fs.Bool("rules", false, "List the rules instead of checking a document.")
+}
+
+func (c *lintCommand) Execute(state *state, fs *flag.FlagSet) error {
+	if *c.rules {
+		return printTable(
+			[]colInfo{{"RULE", "%s"}, {"SEVERITY", "%s"}, {"DESCRIPTION", "%s"}},
+			lintRules,
+			func(r *lintRule) []any { return []any{r.id, r.severity, r.description} },
+		)
+	}
+	suppress, err := parseSuppress(*c.suppress)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// The rules work on the decoded document, so it has to match the schema
+	// first.
+	if err := preflight(b, documentTypes["ComputeSystem"]); err != nil {
+		return err
+	}
+	var doc hcsschema.ComputeSystem
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	errs, warnings := 0, 0
+	for _, f := range lintDocument(&doc, suppress) {
+		fmt.Printf("%s: %s [%s %s]\n", f.path, f.msg, f.rule.severity, f.rule.id)
+		if f.rule.severity == lintError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d error(s), %d warning(s)", errs, warnings)
+	}
+	if warnings > 0 {
+		fmt.Printf("%d warning(s)\n", warnings)
+	}
+	return nil
+}
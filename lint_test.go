@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+func TestLintDocument(t *testing.T) {
+	kernel := filepath.Join(t.TempDir(), "vmlinux")
+	if err := os.WriteFile(kernel, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	kernelJSON, _ := json.Marshal(kernel)
+	for _, tc := range []struct {
+		name     string
+		doc      string
+		suppress string
+		// want are the findings expected, as "rule path".
+		want []string
+	}{
+		{
+			name: "clean VM",
+			doc: `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024}},"Devices":{
+				"Scsi":{"0":{"Attachments":{"0":{"Path":"a.vhdx"},"1":{"Path":"b.vhdx"}}},"1":{"Attachments":{"2":{"Path":"c.vhdx"}}}},
+				"NetworkAdapters":{"a":{"MacAddress":"00-15-5D-00-00-01"},"b":{"MacAddress":"00-15-5D-00-00-02"}},
+				"VirtualPMem":{"MaximumCount":2,"Devices":{"0":{},"1":{}}},
+				"SharedMemory":{"Regions":[{"SectionName":"a","StartOffset":0,"Length":4096},{"SectionName":"b","StartOffset":4096,"Length":4096}]}}}}`,
+		},
+		{
+			name: "memory-size",
+			doc:  `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1023}}}}`,
+			want: []string{"memory-size /VirtualMachine/ComputeTopology/Memory/SizeInMB"},
+		},
+		{
+			name: "scsi-lun",
+			doc:  `{"VirtualMachine":{"Devices":{"Scsi":{"0":{"Attachments":{"64":{},"x":{},"1":{},"01":{}}}}}}}`,
+			want: []string{
+				"scsi-lun /VirtualMachine/Devices/Scsi/0/Attachments/1",
+				"scsi-lun /VirtualMachine/Devices/Scsi/0/Attachments/64",
+				"scsi-lun /VirtualMachine/Devices/Scsi/0/Attachments/x",
+			},
+		},
+		{
+			name: "scsi-lun-reuse",
+			doc:  `{"VirtualMachine":{"Devices":{"Scsi":{"0":{"Attachments":{"0":{}}},"1":{"Attachments":{"0":{}}}}}}}`,
+			want: []string{"scsi-lun-reuse /VirtualMachine/Devices/Scsi/1/Attachments/0"},
+		},
+		{
+			name: "scsi-path-reuse ignores case",
+			doc:  `{"VirtualMachine":{"Devices":{"Scsi":{"0":{"Attachments":{"0":{"Path":"C:\\a.vhdx"},"1":{"Path":"c:\\A.VHDX"}}}}}}}`,
+			want: []string{"scsi-path-reuse /VirtualMachine/Devices/Scsi/0/Attachments/1/Path"},
+		},
+		{
+			name: "nic-mac",
+			doc:  `{"VirtualMachine":{"Devices":{"NetworkAdapters":{"a":{"MacAddress":"00:15:5D:00:00:01"},"b":{"MacAddress":"01-00-5E-00-00-01"},"c":{"MacAddress":"nope"}}}}}`,
+			want: []string{
+				"nic-mac /VirtualMachine/Devices/NetworkAdapters/a/MacAddress",
+				"nic-mac /VirtualMachine/Devices/NetworkAdapters/b/MacAddress",
+				"nic-mac /VirtualMachine/Devices/NetworkAdapters/c/MacAddress",
+			},
+		},
+		{
+			name: "nic-mac-reuse ignores case",
+			doc:  `{"VirtualMachine":{"Devices":{"NetworkAdapters":{"a":{"MacAddress":"00-15-5D-00-00-0A"},"b":{"MacAddress":"00-15-5d-00-00-0a"}}}}}`,
+			want: []string{"nic-mac-reuse /VirtualMachine/Devices/NetworkAdapters/b/MacAddress"},
+		},
+		{
+			name: "vpmem-count",
+			doc:  `{"VirtualMachine":{"Devices":{"VirtualPMem":{"MaximumCount":1,"Devices":{"0":{},"1":{}}}}}}`,
+			want: []string{
+				"vpmem-count /VirtualMachine/Devices/VirtualPMem/Devices/1",
+				"vpmem-count /VirtualMachine/Devices/VirtualPMem/MaximumCount",
+			},
+		},
+		{
+			name: "shared-memory-overlap",
+			doc:  `{"VirtualMachine":{"Devices":{"SharedMemory":{"Regions":[{"SectionName":"a","StartOffset":0,"Length":8192},{"SectionName":"b","StartOffset":4096,"Length":4096},{"SectionName":"c","StartOffset":0}]}}}}`,
+			want: []string{
+				"shared-memory-overlap /VirtualMachine/Devices/SharedMemory/Regions/1",
+				"shared-memory-overlap /VirtualMachine/Devices/SharedMemory/Regions/2",
+			},
+		},
+		{
+			name: "kernel-file",
+			doc:  `{"VirtualMachine":{"Chipset":{"LinuxKernelDirect":{"KernelFilePath":` + string(kernelJSON) + `,"InitRdPath":"/does/not/exist"}}}}`,
+			want: []string{"kernel-file /VirtualMachine/Chipset/LinuxKernelDirect/InitRdPath"},
+		},
+		{
+			name: "kernel-file not set",
+			doc:  `{"VirtualMachine":{"Chipset":{"LinuxKernelDirect":{}}}}`,
+			want: []string{"kernel-file /VirtualMachine/Chipset/LinuxKernelDirect"},
+		},
+		{
+			name: "restore-stop-on-reset",
+			doc:  `{"VirtualMachine":{"RestoreState":{"SaveStateFilePath":"a.vmrs"}}}`,
+			want: []string{"restore-stop-on-reset /VirtualMachine/StopOnReset"},
+		},
+		{
+			name: "restore with StopOnReset",
+			doc:  `{"VirtualMachine":{"StopOnReset":true,"RestoreState":{"SaveStateFilePath":"a.vmrs"}}}`,
+		},
+		{
+			name: "container-storage",
+			doc:  `{"Container":{"Storage":{"Layers":[{"Id":"a"}]}}}`,
+			want: []string{
+				"container-storage /Container/Storage",
+				"container-storage /Container/Storage/Layers/0",
+			},
+		},
+		{
+			name: "container-mapped-directory",
+			doc:  `{"Container":{"Storage":{"Layers":[{"Path":"a"}],"Path":"s"},"MappedDirectories":[{"HostPath":"a"},{"ContainerPath":"b"}]}}`,
+			want: []string{
+				"container-mapped-directory /Container/MappedDirectories/0",
+				"container-mapped-directory /Container/MappedDirectories/1",
+			},
+		},
+		{
+			name: "container-device",
+			doc:  `{"Container":{"Storage":{"Layers":[{"Path":"a"}],"Path":"s"},"AssignedDevices":[{},{"Type":"DeviceInstance"},{"Type":"DeviceInstance","LocationPath":"p"}]}}`,
+			want: []string{
+				"container-device /Container/AssignedDevices/0",
+				"container-device /Container/AssignedDevices/1",
+			},
+		},
+		{
+			name:     "suppressed",
+			doc:      `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1023}},"Devices":{"Scsi":{"0":{"Attachments":{"0":{}}},"1":{"Attachments":{"0":{}}}}}}}`,
+			suppress: "memory-size,scsi-lun-reuse",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var doc hcsschema.ComputeSystem
+			if err := json.Unmarshal([]byte(tc.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			suppress, err := parseSuppress(tc.suppress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range lintDocument(&doc, suppress) {
+				got = append(got, f.rule.id+" "+f.path)
+			}
+			if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseSuppress(t *testing.T) {
+	if _, err := parseSuppress("memory-size,nope"); err == nil {
+		t.Error("parseSuppress accepted an unknown rule")
+	}
+	suppress, err := parseSuppress("memory-size,nic-mac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suppress) != 2 || !suppress["memory-size"] || !suppress["nic-mac"] {
+		t.Errorf("parseSuppress = %v", suppress)
+	}
+}
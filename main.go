@@ -2,16 +2,45 @@ package main
 
 import (
 	"context"
+	"flag"
+	"time"
 
+	"github.com/kevpar/hcstool/internal/hcs"
 	"github.com/kevpar/repl-go"
 )
 
 func main() {
-	if err := run(context.Background()); err != nil {
+	sim := flag.Bool("sim", false, "Use the in-memory HCS simulator instead of the real HCS.")
+	timeout := flag.Duration("timeout", 0, "Default time to wait for HCS operations before cancelling them. 0 waits forever.")
+	flag.Parse()
+	backend := defaultBackend()
+	if *sim {
+		backend = hcs.NewSimulator()
+	}
+	if err := run(context.Background(), backend, *timeout); err != nil {
 		panic(err)
 	}
 }
 
-func run(ctx context.Context) error {
-	return repl.Run(&state{systems: make(map[string]*cs)}, allCommands(), func(state *state) string { return state.def })
+func run(ctx context.Context, backend hcs.Backend, timeout time.Duration) error {
+	stdin, err := newStdinRouter()
+	if err != nil {
+		return err
+	}
+	s := &state{
+		ctx:       ctx,
+		interrupt: newInterruptHandler(),
+		timeout:   timeout,
+		hcs:       backend,
+		stdin:     stdin,
+		systems:   make(map[string]*cs),
+		processes: make(map[string]*proc),
+		jobs:      make(map[int]*job),
+		events:    newEventLog(),
+	}
+	return repl.Run(s, allCommands(), func(state *state) string {
+		notifyJobs(state)
+		notifyEvents(state)
+		return state.def
+	})
 }
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var errNoMigrationSocket = errors.New("live migration sockets are only supported on Windows")
+
+func dial(addr netip.AddrPort) (uintptr, error) {
+	return 0, errNoMigrationSocket
+}
+
+func listen(addr netip.AddrPort) (uintptr, error) {
+	return 0, errNoMigrationSocket
+}
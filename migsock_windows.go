@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/sys/windows"
+)
+
+func dial(addr netip.AddrPort) (_ windows.Handle, err error) {
+	conn, err := windows.Socket(windows.AF_INET, windows.SOCK_STREAM, windows.IPPROTO_TCP)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			windows.Closesocket(conn)
+		}
+	}()
+	fmt.Printf("connecting...\n")
+	if err := windows.Connect(conn, &windows.SockaddrInet4{Port: int(addr.Port()), Addr: addr.Addr().As4()}); err != nil {
+		return 0, err
+	}
+	fmt.Printf("connected\n")
+	return conn, nil
+}
+
+func listen(addr netip.AddrPort) (_ windows.Handle, err error) {
+	l, err := windows.Socket(windows.AF_INET, windows.SOCK_STREAM, windows.IPPROTO_TCP)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.Closesocket(l)
+	if err := windows.Bind(l, &windows.SockaddrInet4{Port: int(addr.Port()), Addr: addr.Addr().As4()}); err != nil {
+		return 0, err
+	}
+	conn, err := windows.Socket(windows.AF_INET, windows.SOCK_STREAM, windows.IPPROTO_TCP)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			windows.Closesocket(conn)
+		}
+	}()
+	if err := windows.Listen(l, 1); err != nil {
+		return 0, err
+	}
+	var buf [64]byte
+	var recvd uint32
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(event)
+	overlapped := windows.Overlapped{HEvent: event}
+	if err := windows.AcceptEx(l, conn, &buf[0], 0, 32, 32, &recvd, &overlapped); err != nil && err != windows.ERROR_IO_PENDING {
+		return 0, err
+	}
+	fmt.Printf("connecting...\n")
+	if _, err := windows.WaitForSingleObject(event, windows.INFINITE); err != nil {
+		return 0, err
+	}
+	fmt.Printf("connected\n")
+	return conn, nil
+}
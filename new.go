@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// listFlag collects a flag that can be repeated.
+type listFlag []string
+
+func (f *listFlag) String() string     { return strings.Join(*f, " ") }
+func (f *listFlag) Set(s string) error { *f = append(*f, s); return nil }
+
+// splitOptions splits a flag value of the form VALUE[,OPTION[=X]...] into
+// its value and options. Options without a value map to "".
+func splitOptions(s string) (string, map[string]string) {
+	parts := strings.Split(s, ",")
+	opts := make(map[string]string)
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		opts[strings.ToLower(k)] = v
+	}
+	return parts[0], opts
+}
+
+// checkOptions returns an error if opts has any option not in known.
+func checkOptions(flagName string, opts map[string]string, known ...string) error {
+	for k := range opts {
+		found := false
+		for _, kk := range known {
+			found = found || k == kk
+		}
+		if !found {
+			return fmt.Errorf("-%s: unknown option %q", flagName, k)
+		}
+	}
+	return nil
+}
+
+// oneOf returns the entry of values that matches s, ignoring case.
+func oneOf(flagName, s string, values ...string) (string, error) {
+	for _, v := range values {
+		if strings.EqualFold(s, v) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("-%s: %q is not one of %s", flagName, s, strings.Join(values, ", "))
+}
+
+// absPath returns the absolute form of a host path given to flagName. HCS
+// opens files from its own working directory, not hcstool's, so relative
+// paths would name the wrong file. Empty paths stay empty.
+func absPath(flagName, path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("-%s: %w", flagName, err)
+	}
+	return abs, nil
+}
+
+type newCommand struct {
+	cf         commonFlags
+	out        *string
+	setDefault *bool
+	noGrant    *bool
+
+	owner            *string
+	schema           *string
+	terminateOnClose *bool
+	stopOnReset      *bool
+
+	memory          *uint64
+	overcommit      *bool
+	hotHint         *bool
+	coldHint        *bool
+	coldDiscardHint *bool
+	deferredCommit  *bool
+	epf             *bool
+	lowMMIOGap      *uint64
+	highMMIOBase    *uint64
+	highMMIOGap     *uint64
+
+	cpus      *int
+	cpuLimit  *int
+	cpuWeight *int
+	nested    *bool
+
+	kernel     *string
+	initrd     *string
+	cmdline    *string
+	secureBoot *string
+	console    *string
+	bootDevice *string
+	bootPath   *string
+	bootDisk   *int
+	bootVmbFs  *string
+	uefiDebug  *bool
+
+	disks listFlag
+	nics  listFlag
+	coms  listFlag
+
+	hvsock     *bool
+	hvsockSDDL *string
+
+	vmgs         *string
+	vmgsType     *string
+	runtimeState *string
+	transient    *bool
+}
+
+func (c *newCommand) Name() string { return "new" }
+func (c *newCommand) Description() string {
+	return "Builds a compute system document from flags, and writes it out or creates the system."
+}
+func (c *newCommand) ArgHelp() string { return "vm [ID]" }
+func (c *newCommand) SetupFlags(fs *flag.FlagSet) {
+	setupTimeoutFlag(&c.cf, fs)
+	c.out = fs.String("o", "", "Write the document to this file, or - for the terminal, instead of creating the system.")
+	c.setDefault = fs.Bool("def", false, "Set the new compute system as the default.")
+	c.noGrant = fs.Bool("nogrant", false, "Do not grant the VM access to the host files the document references.")
+
+	c.owner = fs.String("owner", "hcstool", "Owner of the compute system.")
+	c.schema = fs.String("schema", "2.1", "Schema version of the document, as MAJOR.MINOR.")
+	c.terminateOnClose = fs.Bool("terminateonclose", false, "Terminate the system when its last handle is closed.")
+	c.stopOnReset = fs.Bool("stoponreset", false, "Stop the VM instead of restarting it when the guest resets.")
+
+	c.memory = fs.Uint64("memory", 1024, "Memory size in MB.")
+	c.overcommit = fs.Bool("overcommit", false, "Back guest memory with virtual memory, allowing overcommit.")
+	c.hotHint = fs.Bool("hothint", false, "Enable hot hinting.")
+	c.coldHint = fs.Bool("coldhint", false, "Enable cold hinting.")
+	c.coldDiscardHint = fs.Bool("colddiscardhint", false, "Enable cold discard hinting.")
+	c.deferredCommit = fs.Bool("deferredcommit", false, "Commit guest memory as it is touched, rather than up front.")
+	c.epf = fs.Bool("epf", false, "Enable enlightened page faults.")
+	c.lowMMIOGap = fs.Uint64("lowmmiogap", 0, "Size in MB of the MMIO gap below 4GB, for 32-bit BARs of vPCI devices.")
+	c.highMMIOBase = fs.Uint64("highmmiobase", 0, "Base in MB of the MMIO gap above 4GB, for 64-bit BARs of vPCI devices.")
+	c.highMMIOGap = fs.Uint64("highmmiogap", 0, "Size in MB of the MMIO gap above 4GB.")
+
+	c.cpus = fs.Int("cpus", 2, "Number of virtual processors.")
+	c.cpuLimit = fs.Int("cpulimit", 0, "Processor limit, in hundredths of a percent of the VM's processors (0-100000).")
+	c.cpuWeight = fs.Int("cpuweight", 0, "Processor weight relative to other VMs (0-10000).")
+	c.nested = fs.Bool("nested", false, "Expose virtualization extensions to the guest.")
+
+	c.kernel = fs.String("kernel", "", "Boot this Linux kernel directly instead of using UEFI.")
+	c.initrd = fs.String("initrd", "", "Initial ramdisk for -kernel.")
+	c.cmdline = fs.String("cmdline", "", "Kernel command line for -kernel.")
+	c.secureBoot = fs.String("secureboot", "", "UEFI secure boot template ID to apply.")
+	c.console = fs.String("console", "", "UEFI console: Default, ComPort1, ComPort2 or None.")
+	c.bootDevice = fs.String("bootdevice", "", "UEFI boot device type: ScsiDrive, VmbFs, Network or File.")
+	c.bootPath = fs.String("bootpath", "", "UEFI boot device path, such as the EFI application to run.")
+	c.bootDisk = fs.Int("bootdisk", 0, "UEFI boot disk number, for ScsiDrive.")
+	c.bootVmbFs = fs.String("bootvmbfs", "", "UEFI VmbFs root path, for VmbFs.")
+	c.uefiDebug = fs.Bool("uefidebug", false, "Enable the UEFI debugger.")
+
+	c.disks = nil
+	fs.Var(&c.disks, "disk", "SCSI disk, as PATH[,ro][,type=VirtualDisk|Iso|PassThru][,cache=MODE][,controller=N][,lun=N]. Can be repeated.")
+	c.nics = nil
+	fs.Var(&c.nics, "nic", "Network adapter, as ENDPOINTID[,mac=MAC]. Can be repeated.")
+	c.coms = nil
+	fs.Var(&c.coms, "com", "COM port, as PIPE[,port=N][,debugger]. Ports are numbered from 0 if not given. Can be repeated.")
+
+	c.hvsock = fs.Bool("hvsock", false, "Add an HvSocket device.")
+	c.hvsockSDDL = fs.String("hvsocksddl", "", "Default bind and connect security descriptor for HvSocket, in SDDL. Implies -hvsock.")
+
+	c.vmgs = fs.String("vmgs", "", "Guest state file path.")
+	c.vmgsType = fs.String("vmgstype", "", "Guest state file type: Default, FileMode or BlockStorage.")
+	c.runtimeState = fs.String("runtimestate", "", "Runtime state file path.")
+	c.transient = fs.Bool("transient", false, "Keep guest state in memory only.")
+}
+
+func (c *newCommand) Execute(state *state, fs *flag.FlagSet) error {
+	if fs.Arg(0) != "vm" {
+		return fmt.Errorf("unsupported system kind: %q", fs.Arg(0))
+	}
+	id := fs.Arg(1)
+	if *c.out == "" {
+		if id == "" {
+			return fmt.Errorf("an ID is required to create the system")
+		}
+		if _, ok := state.systems[id]; ok {
+			return fmt.Errorf("compute system already open: %s", id)
+		}
+	}
+	doc, err := c.build()
+	if err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return err
+	}
+	switch *c.out {
+	case "":
+		if !*c.noGrant {
+			if err := grantHostPaths(state, id, doc, false); err != nil {
+				return err
+			}
+		}
+		return createSystem(state, &c.cf, id, string(j), *c.setDefault)
+	case "-":
+		fmt.Printf("%s\n", j)
+		return nil
+	default:
+		return os.WriteFile(*c.out, append(j, '\n'), 0644)
+	}
+}
+
+func (c *newCommand) build() (*hcsschema.ComputeSystem, error) {
+	major, minor, ok := strings.Cut(*c.schema, ".")
+	majorN, err1 := strconv.ParseInt(major, 10, 32)
+	minorN, err2 := strconv.ParseInt(minor, 10, 32)
+	if !ok || err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("-schema: invalid version %q", *c.schema)
+	}
+	vm := &hcsschema.VirtualMachine{
+		StopOnReset: *c.stopOnReset,
+		ComputeTopology: &hcsschema.Topology{
+			Memory: &hcsschema.Memory2{
+				SizeInMB:              *c.memory,
+				AllowOvercommit:       *c.overcommit,
+				EnableHotHint:         *c.hotHint,
+				EnableColdHint:        *c.coldHint,
+				EnableColdDiscardHint: *c.coldDiscardHint,
+				EnableDeferredCommit:  *c.deferredCommit,
+				EnableEpf:             *c.epf,
+				LowMMIOGapInMB:        *c.lowMMIOGap,
+				HighMMIOBaseInMB:      *c.highMMIOBase,
+				HighMMIOGapInMB:       *c.highMMIOGap,
+			},
+			Processor: &hcsschema.Processor2{
+				Count:                          int32(*c.cpus),
+				Limit:                          int32(*c.cpuLimit),
+				Weight:                         int32(*c.cpuWeight),
+				ExposeVirtualizationExtensions: *c.nested,
+			},
+		},
+		Devices: &hcsschema.Devices{},
+	}
+	var err error
+	if vm.Chipset, err = c.chipset(); err != nil {
+		return nil, err
+	}
+	if vm.Devices.Scsi, err = c.scsi(); err != nil {
+		return nil, err
+	}
+	if vm.Devices.NetworkAdapters, err = c.networkAdapters(); err != nil {
+		return nil, err
+	}
+	if vm.Devices.ComPorts, err = c.comPorts(); err != nil {
+		return nil, err
+	}
+	if *c.hvsockSDDL != "" {
+		if err := checkSDDL(*c.hvsockSDDL); err != nil {
+			return nil, fmt.Errorf("-hvsocksddl: %w", err)
+		}
+	}
+	if *c.hvsock || *c.hvsockSDDL != "" {
+		vm.Devices.HvSocket = &hcsschema.HvSocket2{
+			HvSocketConfig: &hcsschema.HvSocketSystemConfig{
+				DefaultBindSecurityDescriptor:    *c.hvsockSDDL,
+				DefaultConnectSecurityDescriptor: *c.hvsockSDDL,
+			},
+		}
+	}
+	if vm.GuestState, err = c.guestState(); err != nil {
+		return nil, err
+	}
+	return &hcsschema.ComputeSystem{
+		Owner:                             *c.owner,
+		SchemaVersion:                     &hcsschema.Version{Major: int32(majorN), Minor: int32(minorN)},
+		VirtualMachine:                    vm,
+		ShouldTerminateOnLastHandleClosed: *c.terminateOnClose,
+	}, nil
+}
+
+func (c *newCommand) chipset() (*hcsschema.Chipset, error) {
+	if *c.kernel != "" {
+		kernel, err := absPath("kernel", *c.kernel)
+		if err != nil {
+			return nil, err
+		}
+		initrd, err := absPath("initrd", *c.initrd)
+		if err != nil {
+			return nil, err
+		}
+		return &hcsschema.Chipset{
+			LinuxKernelDirect: &hcsschema.LinuxKernelDirect{
+				KernelFilePath: kernel,
+				InitRdPath:     initrd,
+				KernelCmdLine:  *c.cmdline,
+			},
+		}, nil
+	}
+	if *c.initrd != "" || *c.cmdline != "" {
+		return nil, fmt.Errorf("-initrd and -cmdline require -kernel")
+	}
+	uefi := &hcsschema.Uefi{EnableDebugger: *c.uefiDebug}
+	if *c.secureBoot != "" {
+		uefi.ApplySecureBootTemplate = "Apply"
+		uefi.SecureBootTemplateId = *c.secureBoot
+	}
+	if *c.console != "" {
+		console, err := oneOf("console", *c.console, fieldEnums["Uefi.Console"]...)
+		if err != nil {
+			return nil, err
+		}
+		uefi.Console = console
+	}
+	if *c.bootDevice != "" {
+		device, err := oneOf("bootdevice", *c.bootDevice, fieldEnums["UefiBootEntry.DeviceType"]...)
+		if err != nil {
+			return nil, err
+		}
+		// The device path is within the boot device, but the VmbFs root is
+		// a host directory.
+		vmbFs, err := absPath("bootvmbfs", *c.bootVmbFs)
+		if err != nil {
+			return nil, err
+		}
+		uefi.BootThis = &hcsschema.UefiBootEntry{
+			DeviceType:    device,
+			DevicePath:    *c.bootPath,
+			DiskNumber:    int32(*c.bootDisk),
+			VmbFsRootPath: vmbFs,
+		}
+	}
+	return &hcsschema.Chipset{Uefi: uefi}, nil
+}
+
+// scsi places each disk on the LUN it asks for, or else on the next free LUN
+// of its controller.
+func (c *newCommand) scsi() (map[string]hcsschema.Scsi, error) {
+	if len(c.disks) == 0 {
+		return nil, nil
+	}
+	scsi := make(map[string]hcsschema.Scsi)
+	for _, d := range c.disks {
+		path, opts := splitOptions(d)
+		if err := checkOptions("disk", opts, "ro", "type", "cache", "controller", "lun"); err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return nil, fmt.Errorf("-disk: missing path in %q", d)
+		}
+		a := hcsschema.Attachment{Type_: "VirtualDisk", Path: path}
+		if strings.EqualFold(filepath.Ext(path), ".iso") {
+			a.Type_ = "Iso"
+		}
+		if t, ok := opts["type"]; ok {
+			var err error
+			if a.Type_, err = oneOf("disk", t, fieldEnums["Attachment.Type"]...); err != nil {
+				return nil, err
+			}
+		}
+		// Pass-through disks are named by device path, not file.
+		if a.Type_ != "PassThru" {
+			var err error
+			if a.Path, err = absPath("disk", path); err != nil {
+				return nil, err
+			}
+		}
+		if m, ok := opts["cache"]; ok {
+			var err error
+			if a.CachingMode, err = oneOf("disk", m, fieldEnums["Attachment.CachingMode"]...); err != nil {
+				return nil, err
+			}
+		}
+		_, a.ReadOnly = opts["ro"]
+		controller := "0"
+		if n, ok := opts["controller"]; ok {
+			if _, err := strconv.ParseUint(n, 10, 8); err != nil {
+				return nil, fmt.Errorf("-disk: invalid controller %q", n)
+			}
+			controller = n
+		}
+		ctrl, ok := scsi[controller]
+		if !ok {
+			ctrl = hcsschema.Scsi{Attachments: make(map[string]hcsschema.Attachment)}
+			scsi[controller] = ctrl
+		}
+		lun, ok := opts["lun"]
+		if ok {
+			if _, err := strconv.ParseUint(lun, 10, 8); err != nil {
+				return nil, fmt.Errorf("-disk: invalid LUN %q", lun)
+			}
+			if _, ok := ctrl.Attachments[lun]; ok {
+				return nil, fmt.Errorf("-disk: LUN %s of controller %s is already used", lun, controller)
+			}
+		} else {
+			for n := 0; ; n++ {
+				lun = strconv.Itoa(n)
+				if _, ok := ctrl.Attachments[lun]; !ok {
+					break
+				}
+			}
+		}
+		ctrl.Attachments[lun] = a
+	}
+	return scsi, nil
+}
+
+func (c *newCommand) networkAdapters() (map[string]hcsschema.NetworkAdapter, error) {
+	if len(c.nics) == 0 {
+		return nil, nil
+	}
+	nics := make(map[string]hcsschema.NetworkAdapter)
+	for _, n := range c.nics {
+		endpoint, opts := splitOptions(n)
+		if err := checkOptions("nic", opts, "mac"); err != nil {
+			return nil, err
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("-nic: missing endpoint ID in %q", n)
+		}
+		if _, ok := nics[endpoint]; ok {
+			return nil, fmt.Errorf("-nic: endpoint %s is used twice", endpoint)
+		}
+		nics[endpoint] = hcsschema.NetworkAdapter{EndpointId: endpoint, MacAddress: opts["mac"]}
+	}
+	return nics, nil
+}
+
+func (c *newCommand) comPorts() (map[string]hcsschema.ComPort, error) {
+	if len(c.coms) == 0 {
+		return nil, nil
+	}
+	ports := make(map[string]hcsschema.ComPort)
+	next := 0
+	for _, p := range c.coms {
+		pipe, opts := splitOptions(p)
+		if err := checkOptions("com", opts, "port", "debugger"); err != nil {
+			return nil, err
+		}
+		port := strconv.Itoa(next)
+		if n, ok := opts["port"]; ok {
+			i, err := strconv.ParseUint(n, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("-com: invalid port %q", n)
+			}
+			port = n
+			next = int(i)
+		}
+		next++
+		if _, ok := ports[port]; ok {
+			return nil, fmt.Errorf("-com: port %s is used twice", port)
+		}
+		_, debugger := opts["debugger"]
+		ports[port] = hcsschema.ComPort{NamedPipe: pipe, OptimizeForDebugger: debugger}
+	}
+	return ports, nil
+}
+
+func (c *newCommand) guestState() (*hcsschema.GuestState, error) {
+	if *c.vmgs == "" && *c.vmgsType == "" && *c.runtimeState == "" && !*c.transient {
+		return nil, nil
+	}
+	vmgs, err := absPath("vmgs", *c.vmgs)
+	if err != nil {
+		return nil, err
+	}
+	runtimeState, err := absPath("runtimestate", *c.runtimeState)
+	if err != nil {
+		return nil, err
+	}
+	gs := &hcsschema.GuestState{
+		GuestStateFilePath:   vmgs,
+		RuntimeStateFilePath: runtimeState,
+		ForceTransientState:  *c.transient,
+	}
+	if *c.vmgsType != "" {
+		if gs.GuestStateFileType, err = oneOf("vmgstype", *c.vmgsType, fieldEnums["GuestState.GuestStateFileType"]...); err != nil {
+			return nil, err
+		}
+	}
+	return gs, nil
+}
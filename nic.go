@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// defaultMACPrefix is the Hyper-V organizationally unique identifier.
+const defaultMACPrefix = "00-15-5D"
+
+type nicCommand struct {
+	cf            commonFlags
+	mac           *string
+	macPrefix     *string
+	offloadWeight *uint
+	queuePairs    *uint
+	intMod        *string
+}
+
+func (c *nicCommand) Name() string { return "nic" }
+func (c *nicCommand) Description() string {
+	return "Hot-adds, removes, updates or lists network adapters of a VM."
+}
+func (c *nicCommand) ArgHelp() string { return "add|remove|update ENDPOINTID|list" }
+func (c *nicCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.mac = fs.String("mac", "", "MAC address of the adapter. Defaults to a free address with the -macprefix prefix.")
+	c.macPrefix = fs.String("macprefix", defaultMACPrefix, "Prefix of allocated MAC addresses, as 1 to 5 hyphenated bytes.")
+	c.offloadWeight = fs.Uint("offloadweight", 0, "IOV offload weight. 0 disables IOV offloading.")
+	c.queuePairs = fs.Uint("queuepairs", 0, "Number of IOV queue pairs requested.")
+	c.intMod = fs.String("intmod", "", "IOV interrupt moderation mode: Default, Adaptive, Off, Low, Medium or High, or its numeric value.")
+}
+
+func (c *nicCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sub, err := parseSubcommand(fs, "add", "remove", "update", "list")
+	if err != nil {
+		return err
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	vm, err := vmConfig(id, cs)
+	if err != nil {
+		return err
+	}
+	if sub == "list" {
+		printTracked(id)
+		return c.list(vm)
+	}
+	endpoint := fs.Arg(0)
+	if endpoint == "" {
+		return fmt.Errorf("an endpoint ID is required")
+	}
+	existing, exists := vm.Devices.NetworkAdapters[endpoint]
+	switch sub {
+	case "add":
+		if exists {
+			return fmt.Errorf("%s already has an adapter for endpoint %s", id, endpoint)
+		}
+		nic := hcsschema.NetworkAdapter{EndpointId: endpoint}
+		if nic.MacAddress, err = c.pickMAC(state); err != nil {
+			return err
+		}
+		if nic.IovSettings, err = c.iovSettings(fs, nil); err != nil {
+			return err
+		}
+		if err := modifySystem(state, &c.cf, cs, "Add", nicResourcePath(endpoint), nic); err != nil {
+			return err
+		}
+		if vm.Devices.NetworkAdapters == nil {
+			vm.Devices.NetworkAdapters = make(map[string]hcsschema.NetworkAdapter)
+		}
+		vm.Devices.NetworkAdapters[endpoint] = nic
+		fmt.Printf("added adapter for %s with MAC address %s\n", endpoint, nic.MacAddress)
+	case "remove":
+		if !exists {
+			return fmt.Errorf("%s has no adapter for endpoint %s", id, endpoint)
+		}
+		if err := modifySystem(state, &c.cf, cs, "Remove", nicResourcePath(endpoint), nil); err != nil {
+			return err
+		}
+		delete(vm.Devices.NetworkAdapters, endpoint)
+	case "update":
+		if !exists {
+			return fmt.Errorf("%s has no adapter for endpoint %s", id, endpoint)
+		}
+		if *c.mac != "" {
+			return fmt.Errorf("the MAC address of an adapter cannot be changed")
+		}
+		iov, err := c.iovSettings(fs, existing.IovSettings)
+		if err != nil {
+			return err
+		}
+		if iov == nil {
+			return fmt.Errorf("nothing to update; set -offloadweight, -queuepairs or -intmod")
+		}
+		nic := hcsschema.NetworkAdapter{EndpointId: endpoint, IovSettings: iov}
+		if err := modifySystem(state, &c.cf, cs, "Update", nicResourcePath(endpoint), nic); err != nil {
+			return err
+		}
+		existing.IovSettings = iov
+		vm.Devices.NetworkAdapters[endpoint] = existing
+	}
+	return nil
+}
+
+// iovSettings applies the IOV flags that were set to a copy of base. It
+// returns nil if none were set.
+func (c *nicCommand) iovSettings(fs *flag.FlagSet, base *hcsschema.IovSettings) (*hcsschema.IovSettings, error) {
+	var iov hcsschema.IovSettings
+	if base != nil {
+		iov = *base
+	}
+	set := false
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "offloadweight":
+			w := uint32(*c.offloadWeight)
+			iov.OffloadWeight = &w
+		case "queuepairs":
+			n := uint32(*c.queuePairs)
+			iov.QueuePairsRequested = &n
+		case "intmod":
+			var mode hcsschema.InterruptModerationName
+			if mode, err = parseInterruptModeration(*c.intMod); err == nil {
+				iov.InterruptModeration = &mode
+			}
+		default:
+			return
+		}
+		set = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !set {
+		return nil, nil
+	}
+	return &iov, nil
+}
+
+// parseInterruptModeration accepts an interrupt moderation mode by name, or by
+// the value HCS reports it as.
+func parseInterruptModeration(s string) (hcsschema.InterruptModerationName, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		name, ok := hcsschema.InterruptModerationValueToName[hcsschema.InterruptModerationValue(n)]
+		if !ok {
+			return "", fmt.Errorf("unknown interrupt moderation value: %d", n)
+		}
+		return name, nil
+	}
+	for _, name := range hcsschema.InterruptModerationValueToName {
+		if strings.EqualFold(s, string(name)) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown interrupt moderation mode: %s", s)
+}
+
+// pickMAC returns the -mac flag, or else a random address with the -macprefix
+// prefix. Either way, the address must not be used by any open system.
+func (c *nicCommand) pickMAC(state *state) (string, error) {
+	used := make(map[string]string)
+	for id, cs := range state.systems {
+		if cs.config == nil || cs.config.VirtualMachine == nil || cs.config.VirtualMachine.Devices == nil {
+			continue
+		}
+		for _, nic := range cs.config.VirtualMachine.Devices.NetworkAdapters {
+			if mac, err := parseMAC(nic.MacAddress); err == nil {
+				used[mac.String()] = id
+			}
+		}
+	}
+	if *c.mac != "" {
+		mac, err := parseMAC(*c.mac)
+		if err != nil {
+			return "", err
+		}
+		if id, ok := used[mac.String()]; ok {
+			return "", fmt.Errorf("MAC address %s is already used by %s", *c.mac, id)
+		}
+		return formatMAC(mac), nil
+	}
+	prefix, err := parseMACPrefix(*c.macPrefix)
+	if err != nil {
+		return "", err
+	}
+	// Random addresses keep clear of VMs that hcstool does not know about,
+	// better than counting up from the prefix would.
+	for tries := 0; tries < 100; tries++ {
+		mac := make(net.HardwareAddr, 6)
+		copy(mac, prefix)
+		if _, err := rand.Read(mac[len(prefix):]); err != nil {
+			return "", err
+		}
+		if _, ok := used[mac.String()]; !ok {
+			return formatMAC(mac), nil
+		}
+	}
+	return "", fmt.Errorf("no free MAC address with prefix %s", *c.macPrefix)
+}
+
+func parseMACPrefix(s string) ([]byte, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 1 || len(parts) > 5 {
+		return nil, fmt.Errorf("MAC prefix %s must have 1 to 5 bytes", s)
+	}
+	prefix := make([]byte, len(parts))
+	for i, p := range parts {
+		b, err := strconv.ParseUint(p, 16, 8)
+		if err != nil || len(p) != 2 {
+			return nil, fmt.Errorf("invalid MAC prefix: %s", s)
+		}
+		prefix[i] = byte(b)
+	}
+	if prefix[0]&1 != 0 {
+		return nil, fmt.Errorf("MAC prefix %s is a multicast prefix", s)
+	}
+	return prefix, nil
+}
+
+// formatMAC formats mac the way HCS does.
+func formatMAC(mac net.HardwareAddr) string {
+	return strings.ToUpper(strings.ReplaceAll(mac.String(), ":", "-"))
+}
+
+func (c *nicCommand) list(vm *hcsschema.VirtualMachine) error {
+	endpoints := sortedKeys(vm.Devices.NetworkAdapters)
+	return printTable(
+		[]colInfo{{"ENDPOINT", "%s"}, {"MAC", "%s"}, {"OFFLOADWEIGHT", "%s"}, {"QUEUEPAIRS", "%s"}, {"INTMOD", "%s"}},
+		endpoints,
+		func(e string) []any {
+			nic := vm.Devices.NetworkAdapters[e]
+			weight, pairs, mode := "", "", ""
+			if iov := nic.IovSettings; iov != nil {
+				if iov.OffloadWeight != nil {
+					weight = strconv.FormatUint(uint64(*iov.OffloadWeight), 10)
+				}
+				if iov.QueuePairsRequested != nil {
+					pairs = strconv.FormatUint(uint64(*iov.QueuePairsRequested), 10)
+				}
+				if iov.InterruptModeration != nil {
+					mode = string(*iov.InterruptModeration)
+				}
+			}
+			return []any{e, nic.MacAddress, weight, pairs, mode}
+		},
+	)
+}
+
+func nicResourcePath(endpoint string) string {
+	return "VirtualMachine/Devices/NetworkAdapters/" + endpoint
+}
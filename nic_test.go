@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseMACPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []byte
+		err  bool
+	}{
+		{in: "00", want: []byte{0x00}},
+		{in: "00-15-5D", want: []byte{0x00, 0x15, 0x5d}},
+		{in: "00-15-5d-a0-FF", want: []byte{0x00, 0x15, 0x5d, 0xa0, 0xff}},
+		{in: "02-00", want: []byte{0x02, 0x00}},
+		{in: "", err: true},
+		{in: "00-15-5D-00-00-00", err: true},
+		{in: "00:15:5D", err: true},
+		{in: "0-15", err: true},
+		{in: "000-15", err: true},
+		{in: "00-1G", err: true},
+		{in: "00-", err: true},
+		{in: "01-00-5E", err: true},
+	} {
+		prefix, err := parseMACPrefix(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("parseMACPrefix(%q) error = %v, want error %v", tc.in, err, tc.err)
+			continue
+		}
+		if !bytes.Equal(prefix, tc.want) {
+			t.Errorf("parseMACPrefix(%q) = %x, want %x", tc.in, prefix, tc.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// Placement policies for choosing the logical processors of a CPU group.
+const (
+	// placePack puts every logical processor on one NUMA node, choosing the
+	// node that the group fits most tightly, to keep larger nodes free.
+	placePack = "pack"
+	// placeSpread takes logical processors from each node in turn.
+	placeSpread = "spread"
+)
+
+var placementPolicies = []string{placePack, placeSpread}
+
+// placementCore is a core of the host and its free logical processors.
+type placementCore struct {
+	id  uint32
+	lps []uint32
+}
+
+// placementNode is a NUMA node of the host and its cores that have free
+// logical processors.
+type placementNode struct {
+	number uint8
+	cores  []*placementCore
+}
+
+// free returns the free logical processors of n, in the order they should be
+// taken: with noSMT, only the first thread of each core; else the first
+// thread of every core, before any second thread.
+func (n *placementNode) free(noSMT bool) []uint32 {
+	var lps []uint32
+	for thread := 0; ; thread++ {
+		added := false
+		for _, c := range n.cores {
+			if thread < len(c.lps) {
+				lps = append(lps, c.lps[thread])
+				added = true
+			}
+		}
+		if !added || noSMT {
+			return lps
+		}
+	}
+}
+
+// placementNodes groups the logical processors of the host by node and core,
+// leaving out those in used.
+func placementNodes(topology *hcsschema.ProcessorTopology, used map[uint32]bool) []*placementNode {
+	nodes := make(map[uint8]*placementNode)
+	cores := make(map[[2]uint32]*placementCore)
+	lps := append([]hcsschema.LogicalProcessor(nil), topology.LogicalProcessors...)
+	sort.Slice(lps, func(i, j int) bool { return lps[i].LpIndex < lps[j].LpIndex })
+	for _, lp := range lps {
+		if used[lp.LpIndex] {
+			continue
+		}
+		n, ok := nodes[lp.NodeNumber]
+		if !ok {
+			n = &placementNode{number: lp.NodeNumber}
+			nodes[lp.NodeNumber] = n
+		}
+		// Core IDs are only unique within a package.
+		key := [2]uint32{lp.PackageId, lp.CoreId}
+		c, ok := cores[key]
+		if !ok {
+			c = &placementCore{id: lp.CoreId}
+			cores[key] = c
+			n.cores = append(n.cores, c)
+		}
+		c.lps = append(c.lps, lp.LpIndex)
+	}
+	var result []*placementNode
+	for _, n := range nodes {
+		result = append(result, n)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].number < result[j].number })
+	return result
+}
+
+// placeLPs chooses count logical processors of the host under policy. With
+// noSMT, no two of them share a core.
+func placeLPs(topology *hcsschema.ProcessorTopology, used map[uint32]bool, count int, policy string, noSMT bool) ([]uint32, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("the logical processor count must be more than 0")
+	}
+	nodes := placementNodes(topology, used)
+	var lps []uint32
+	switch policy {
+	case placePack:
+		var best []uint32
+		for _, n := range nodes {
+			free := n.free(noSMT)
+			if len(free) >= count && (best == nil || len(free) < len(best)) {
+				best = free
+			}
+		}
+		if best == nil {
+			return nil, fmt.Errorf("no NUMA node has %d free logical processors%s", count, smtNote(noSMT))
+		}
+		lps = best[:count]
+	case placeSpread:
+		free := make([][]uint32, len(nodes))
+		for i, n := range nodes {
+			free[i] = n.free(noSMT)
+		}
+		for len(lps) < count {
+			added := false
+			for i := range free {
+				if len(free[i]) > 0 && len(lps) < count {
+					lps = append(lps, free[i][0])
+					free[i] = free[i][1:]
+					added = true
+				}
+			}
+			if !added {
+				return nil, fmt.Errorf("the host has only %d free logical processors%s", len(lps), smtNote(noSMT))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown placement policy: %s", policy)
+	}
+	sort.Slice(lps, func(i, j int) bool { return lps[i] < lps[j] })
+	return lps, nil
+}
+
+func smtNote(noSMT bool) string {
+	if noSMT {
+		return " on separate cores"
+	}
+	return ""
+}
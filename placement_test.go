@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+func TestPlaceLPs(t *testing.T) {
+	// Node 0 has two cores of two threads, LPs 0-3. Node 1 has one core of
+	// two threads, LPs 4-5, with the same core ID as node 0's first core but
+	// in another package.
+	topology := &hcsschema.ProcessorTopology{}
+	for _, lp := range []struct {
+		index     uint32
+		node      uint8
+		pkg, core uint32
+	}{
+		{0, 0, 0, 0}, {1, 0, 0, 0}, {2, 0, 0, 1}, {3, 0, 0, 1},
+		{4, 1, 1, 0}, {5, 1, 1, 0},
+	} {
+		topology.LogicalProcessors = append(topology.LogicalProcessors, hcsschema.LogicalProcessor{
+			LpIndex: lp.index, NodeNumber: lp.node, PackageId: lp.pkg, CoreId: lp.core,
+		})
+	}
+	for _, tc := range []struct {
+		name   string
+		used   []uint32
+		count  int
+		policy string
+		noSMT  bool
+		want   []uint32
+		err    bool
+	}{
+		{name: "pack into the tightest node", count: 2, policy: placePack, want: []uint32{4, 5}},
+		{name: "pack takes a thread of each core first", count: 3, policy: placePack, want: []uint32{0, 1, 2}},
+		{name: "pack without SMT", count: 2, policy: placePack, noSMT: true, want: []uint32{0, 2}},
+		{name: "pack around used LPs", used: []uint32{4}, count: 1, policy: placePack, want: []uint32{5}},
+		{name: "pack beyond a node", count: 5, policy: placePack, err: true},
+		{name: "pack without SMT beyond a node", count: 3, policy: placePack, noSMT: true, err: true},
+		{name: "spread across nodes", count: 3, policy: placeSpread, want: []uint32{0, 2, 4}},
+		{name: "spread everything", count: 6, policy: placeSpread, want: []uint32{0, 1, 2, 3, 4, 5}},
+		{name: "spread without SMT", count: 3, policy: placeSpread, noSMT: true, want: []uint32{0, 2, 4}},
+		{name: "spread around used LPs", used: []uint32{0, 4}, count: 2, policy: placeSpread, want: []uint32{1, 5}},
+		{name: "spread beyond the host", count: 7, policy: placeSpread, err: true},
+		{name: "spread without SMT beyond the host", count: 4, policy: placeSpread, noSMT: true, err: true},
+		{name: "no LPs", count: 0, policy: placePack, err: true},
+		{name: "unknown policy", count: 1, policy: "random", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			used := make(map[uint32]bool)
+			for _, lp := range tc.used {
+				used[lp] = true
+			}
+			lps, err := placeLPs(topology, used, tc.count, tc.policy, tc.noSMT)
+			if (err != nil) != tc.err {
+				t.Fatalf("placeLPs error = %v, want error %v", err, tc.err)
+			}
+			if !reflect.DeepEqual(lps, tc.want) {
+				t.Errorf("placeLPs = %v, want %v", lps, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// pmemAlignment is the alignment of mapping offsets picked by pmem map.
+const pmemAlignment = 2 << 20
+
+type pmemCommand struct {
+	cf      commonFlags
+	device  *int
+	offset  *int64
+	format  *string
+	ro      *bool
+	noGrant *bool
+}
+
+func (c *pmemCommand) Name() string { return "pmem" }
+func (c *pmemCommand) Description() string {
+	return "Hot-adds, removes, maps or lists virtual PMem devices of a VM."
+}
+func (c *pmemCommand) ArgHelp() string { return "add [PATH]|remove|map PATH|list" }
+func (c *pmemCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.device = fs.Int("device", -1, "Number of the device. Defaults to the first free device when adding.")
+	c.offset = fs.Int64("offset", -1, "Byte offset of a mapping. Defaults to the end of the last mapping when mapping, rounded up to 2MB.")
+	c.format = fs.String("format", "", "Image format: Vhd1 or Vhdx. Defaults to Vhdx for .vhdx files, else Vhd1.")
+	c.ro = fs.Bool("ro", false, "Attach the device read-only. Devices without a path, which hold mappings, are always read-only.")
+	c.noGrant = fs.Bool("nogrant", false, "Do not grant the VM access to the image.")
+}
+
+func (c *pmemCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sub, err := parseSubcommand(fs, "add", "remove", "map", "list")
+	if err != nil {
+		return err
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	vm, err := vmConfig(id, cs)
+	if err != nil {
+		return err
+	}
+	ctrl := vm.Devices.VirtualPMem
+	if ctrl == nil {
+		// The controller cannot be hot-added.
+		return fmt.Errorf("%s has no VPMem controller", id)
+	}
+	switch sub {
+	case "add":
+		return c.add(state, id, cs, ctrl, fs.Arg(0))
+	case "remove":
+		return c.remove(state, cs, ctrl)
+	case "map":
+		return c.mapImage(state, id, cs, ctrl, fs.Arg(0))
+	default:
+		printTracked(id)
+		return c.list(ctrl)
+	}
+}
+
+func (c *pmemCommand) add(state *state, id string, cs *cs, ctrl *hcsschema.VirtualPMemController, path string) error {
+	device, err := c.pickDevice(ctrl)
+	if err != nil {
+		return err
+	}
+	// A device without an image holds mappings, which must be read-only.
+	d := hcsschema.VirtualPMemDevice{ReadOnly: true, ImageFormat: "Vhd1"}
+	if path != "" {
+		format, err := c.imageFormat(path)
+		if err != nil {
+			return err
+		}
+		var size uint64
+		path, size, err = image(path)
+		if err != nil {
+			return err
+		}
+		if err := c.place(state, id, ctrl, path, size, 0); err != nil {
+			return err
+		}
+		d = hcsschema.VirtualPMemDevice{HostPath: path, ReadOnly: *c.ro, ImageFormat: format}
+	}
+	if err := modifySystem(state, &c.cf, cs, "Add", pmemResourcePath(device), d); err != nil {
+		return err
+	}
+	if ctrl.Devices == nil {
+		ctrl.Devices = make(map[string]hcsschema.VirtualPMemDevice)
+	}
+	ctrl.Devices[device] = d
+	if path == "" {
+		fmt.Printf("added device %s for mappings\n", device)
+	} else {
+		fmt.Printf("added %s as device %s\n", path, device)
+	}
+	return nil
+}
+
+// pickDevice returns the -device flag, or else the first free device number.
+// Either way, it must be below the controller's MaximumCount.
+func (c *pmemCommand) pickDevice(ctrl *hcsschema.VirtualPMemController) (string, error) {
+	if *c.device >= 0 {
+		if uint64(*c.device) >= uint64(ctrl.MaximumCount) {
+			return "", fmt.Errorf("device %d is not below the controller's MaximumCount of %d", *c.device, ctrl.MaximumCount)
+		}
+		device := strconv.Itoa(*c.device)
+		if d, ok := ctrl.Devices[device]; ok {
+			return "", fmt.Errorf("device %s is already used by %s", device, pmemDescription(d))
+		}
+		return device, nil
+	}
+	for n := uint32(0); n < ctrl.MaximumCount; n++ {
+		if _, ok := ctrl.Devices[strconv.FormatUint(uint64(n), 10)]; !ok {
+			return strconv.FormatUint(uint64(n), 10), nil
+		}
+	}
+	return "", fmt.Errorf("all %d devices of the controller are in use", ctrl.MaximumCount)
+}
+
+// image returns the absolute path and size of the image at path.
+func image(path string) (string, uint64, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", 0, err
+	}
+	size, err := fileSize(path)
+	return path, size, err
+}
+
+// place checks that an image of size bytes fits the controller when placed at
+// offset, and grants the VM access to it.
+func (c *pmemCommand) place(state *state, id string, ctrl *hcsschema.VirtualPMemController, path string, size, offset uint64) error {
+	if ctrl.MaximumSizeBytes != 0 && offset+size > ctrl.MaximumSizeBytes {
+		if offset == 0 {
+			return fmt.Errorf("%s is %d bytes, more than the controller's MaximumSizeBytes of %d", path, size, ctrl.MaximumSizeBytes)
+		}
+		return fmt.Errorf("%s is %d bytes and does not fit at offset %d within the controller's MaximumSizeBytes of %d", path, size, offset, ctrl.MaximumSizeBytes)
+	}
+	if !*c.noGrant {
+		if err := state.hcs.GrantVmAccess(id, path); err != nil {
+			return fmt.Errorf("grant access to %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (c *pmemCommand) imageFormat(path string) (string, error) {
+	if *c.format != "" {
+		return oneOf("format", *c.format, fieldEnums["VirtualPMemDevice.ImageFormat"]...)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".vhdx") {
+		return "Vhdx", nil
+	}
+	return "Vhd1", nil
+}
+
+func (c *pmemCommand) mapImage(state *state, id string, cs *cs, ctrl *hcsschema.VirtualPMemController, path string) error {
+	if path == "" {
+		return fmt.Errorf("an image path is required")
+	}
+	if *c.device < 0 {
+		return fmt.Errorf("-device is required to map an image")
+	}
+	device := strconv.Itoa(*c.device)
+	d, ok := ctrl.Devices[device]
+	if !ok {
+		return fmt.Errorf("no device %s", device)
+	}
+	if d.HostPath != "" {
+		return fmt.Errorf("device %s holds %s, not mappings", device, d.HostPath)
+	}
+	format, err := c.imageFormat(path)
+	if err != nil {
+		return err
+	}
+	path, size, err := image(path)
+	if err != nil {
+		return err
+	}
+	offset, err := c.pickOffset(d, size)
+	if err != nil {
+		return err
+	}
+	if err := c.place(state, id, ctrl, path, size, offset); err != nil {
+		return err
+	}
+	m := hcsschema.VirtualPMemMapping{HostPath: path, ImageFormat: format}
+	if err := modifySystem(state, &c.cf, cs, "Add", pmemMappingResourcePath(device, offset), m); err != nil {
+		return err
+	}
+	if d.Mappings == nil {
+		d.Mappings = make(map[uint64]hcsschema.VirtualPMemMapping)
+		ctrl.Devices[device] = d
+	}
+	d.Mappings[offset] = m
+	fmt.Printf("mapped %s into device %s at offset %d\n", path, device, offset)
+	return nil
+}
+
+// pickOffset returns the -offset flag, or else the aligned end of the last
+// mapping of d. Either way, an image of size bytes there must not overlap an
+// existing mapping.
+func (c *pmemCommand) pickOffset(d hcsschema.VirtualPMemDevice, size uint64) (uint64, error) {
+	type span struct {
+		path       string
+		start, end uint64
+	}
+	var spans []span
+	var end uint64
+	for _, offset := range pmemOffsets(d) {
+		m := d.Mappings[offset]
+		n, err := fileSize(m.HostPath)
+		if err != nil {
+			return 0, err
+		}
+		spans = append(spans, span{m.HostPath, offset, offset + n})
+		end = offset + n
+	}
+	offset := (end + pmemAlignment - 1) / pmemAlignment * pmemAlignment
+	if *c.offset >= 0 {
+		offset = uint64(*c.offset)
+	}
+	for _, s := range spans {
+		if offset < s.end && s.start < offset+size {
+			return 0, fmt.Errorf("%d bytes at offset %d overlap the mapping of %s at offset %d", size, offset, s.path, s.start)
+		}
+	}
+	return offset, nil
+}
+
+func (c *pmemCommand) remove(state *state, cs *cs, ctrl *hcsschema.VirtualPMemController) error {
+	if *c.device < 0 {
+		return fmt.Errorf("-device is required to remove a device or mapping")
+	}
+	device := strconv.Itoa(*c.device)
+	d, ok := ctrl.Devices[device]
+	if !ok {
+		return fmt.Errorf("no device %s", device)
+	}
+	if *c.offset < 0 {
+		if err := modifySystem(state, &c.cf, cs, "Remove", pmemResourcePath(device), nil); err != nil {
+			return err
+		}
+		delete(ctrl.Devices, device)
+		return nil
+	}
+	offset := uint64(*c.offset)
+	if _, ok := d.Mappings[offset]; !ok {
+		return fmt.Errorf("device %s has no mapping at offset %d", device, offset)
+	}
+	if err := modifySystem(state, &c.cf, cs, "Remove", pmemMappingResourcePath(device, offset), nil); err != nil {
+		return err
+	}
+	delete(d.Mappings, offset)
+	return nil
+}
+
+func (c *pmemCommand) list(ctrl *hcsschema.VirtualPMemController) error {
+	type entry struct {
+		device, offset, format, flags, path string
+	}
+	var entries []entry
+	devices := sortedKeys(ctrl.Devices)
+	sort.Slice(devices, func(i, j int) bool { return numericLess(devices[i], devices[j]) })
+	for _, k := range devices {
+		d := ctrl.Devices[k]
+		flags := ""
+		if d.ReadOnly {
+			flags = "ro"
+		}
+		entries = append(entries, entry{k, "", d.ImageFormat, flags, d.HostPath})
+		for _, offset := range pmemOffsets(d) {
+			m := d.Mappings[offset]
+			entries = append(entries, entry{k, strconv.FormatUint(offset, 10), m.ImageFormat, "", m.HostPath})
+		}
+	}
+	return printTable(
+		[]colInfo{{"DEVICE", "%s"}, {"OFFSET", "%s"}, {"FORMAT", "%s"}, {"FLAGS", "%s"}, {"PATH", "%s"}},
+		entries,
+		func(e entry) []any { return []any{e.device, e.offset, e.format, e.flags, e.path} },
+	)
+}
+
+// pmemOffsets returns the offsets of the mappings of d, in order.
+func pmemOffsets(d hcsschema.VirtualPMemDevice) []uint64 {
+	offsets := make([]uint64, 0, len(d.Mappings))
+	for offset := range d.Mappings {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
+
+func pmemDescription(d hcsschema.VirtualPMemDevice) string {
+	if d.HostPath == "" {
+		return fmt.Sprintf("%d mappings", len(d.Mappings))
+	}
+	return d.HostPath
+}
+
+func fileSize(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fi.Size()), nil
+}
+
+func pmemResourcePath(device string) string {
+	return "VirtualMachine/Devices/VirtualPMem/Devices/" + device
+}
+
+func pmemMappingResourcePath(device string, offset uint64) string {
+	return fmt.Sprintf("VirtualMachine/Devices/VirtualPMem/Devices/%s/Mappings/%d", device, offset)
+}
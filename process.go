@@ -0,0 +1,670 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// proc is an entry in the local process handle table.
+type proc struct {
+	csID   string
+	pid    uint32
+	cmd    string
+	p      hcs.Process
+	stdin  io.WriteCloser
+	exited chan struct{}
+	relays sync.WaitGroup
+}
+
+func getProc(state *state, name string) (*proc, error) {
+	if name == "" {
+		return nil, fmt.Errorf("must specify a process")
+	}
+	p, ok := state.processes[name]
+	if !ok {
+		return nil, fmt.Errorf("process not opened: %s", name)
+	}
+	return p, nil
+}
+
+// addProc adds p to the handle table under name, or the next free short name
+// if name is empty.
+func addProc(state *state, name string, p *proc) (string, error) {
+	if name == "" {
+		for {
+			state.nextProc++
+			name = fmt.Sprintf("p%d", state.nextProc)
+			if _, ok := state.processes[name]; !ok {
+				break
+			}
+		}
+	} else if _, ok := state.processes[name]; ok {
+		return "", fmt.Errorf("process already open: %s", name)
+	}
+	state.processes[name] = p
+	return name, nil
+}
+
+func closeProc(state *state, name string) {
+	p := state.processes[name]
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	p.p.Close()
+	delete(state.processes, name)
+}
+
+// watchExit arranges for p.exited to be closed when the process exits.
+func (p *proc) watchExit() error {
+	var once sync.Once
+	return p.p.SetCallback(func(e hcs.Event) {
+		if e.Type == hcs.EventTypeProcessExited {
+			once.Do(func() { close(p.exited) })
+		}
+	})
+}
+
+func (p *proc) hasExited() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// relay copies r to w in the background, if r is not nil.
+func (p *proc) relay(w io.Writer, r io.ReadCloser) {
+	if r == nil {
+		return
+	}
+	p.relays.Add(1)
+	go func() {
+		defer p.relays.Done()
+		defer r.Close()
+		io.Copy(w, r)
+	}()
+}
+
+type execCommand struct {
+	cf     commonFlags
+	name   *string
+	detach *bool
+	argv   *bool
+	user   *string
+	cwd    *string
+	env    envFlag
+	stdin  *bool
+	stdout *bool
+	stderr *bool
+	tty    *bool
+}
+
+func (c *execCommand) Name() string        { return "exec" }
+func (c *execCommand) Description() string { return "Runs a process in a compute system." }
+func (c *execCommand) ArgHelp() string     { return "COMMAND [ARGS...]" }
+func (c *execCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.name = fs.String("name", "", "Name for the process in the handle table, if detached. Defaults to the next free short name.")
+	c.detach = fs.Bool("detach", false, "Return once the process starts, and keep it in the handle table. Output is still relayed.")
+	c.argv = fs.Bool("argv", false, "Also pass the command as an argument array (CommandArgs). Only supported by Linux guests.")
+	c.user = fs.String("user", "", "User to run the process as.")
+	c.cwd = fs.String("cwd", "", "Working directory for the process.")
+	c.env = make(envFlag)
+	fs.Var(c.env, "env", "Environment variable for the process, as KEY=VALUE. Can be repeated.")
+	c.stdin = fs.Bool("stdin", false, "Relay terminal input to the process until EOF. If detached, only creates the pipe.")
+	c.stdout = fs.Bool("stdout", true, "Relay the process's stdout.")
+	c.stderr = fs.Bool("stderr", true, "Relay the process's stderr.")
+	c.tty = fs.Bool("tty", false, "Emulate a console for the process. Stderr is merged into stdout.")
+}
+
+func (c *execCommand) Execute(state *state, fs *flag.FlagSet) error {
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("must specify a command")
+	}
+	if _, ok := state.processes[*c.name]; ok {
+		return fmt.Errorf("process already open: %s", *c.name)
+	}
+	params := hcsschema.ProcessParameters{
+		CommandLine:      joinCommandLine(fs.Args()),
+		User:             *c.user,
+		WorkingDirectory: *c.cwd,
+		Environment:      c.env,
+		EmulateConsole:   *c.tty,
+		CreateStdInPipe:  *c.stdin,
+		CreateStdOutPipe: *c.stdout,
+		CreateStdErrPipe: *c.stderr && !*c.tty,
+	}
+	if *c.argv {
+		params.CommandArgs = fs.Args()
+	}
+	j, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	p, err := cs.sys.CreateProcess(op, string(j))
+	if err != nil {
+		return err
+	}
+	pr := &proc{csID: id, cmd: params.CommandLine, p: p, exited: make(chan struct{})}
+	if err := pr.watchExit(); err != nil {
+		p.Close()
+		return err
+	}
+	_, info, err := hcs.WaitResultAndProcessInfo(ctx, op)
+	if err != nil {
+		p.Close()
+		return err
+	}
+	pr.pid = info.ProcessID
+	pr.relay(os.Stdout, info.Stdout)
+	pr.relay(os.Stderr, info.Stderr)
+
+	if *c.detach {
+		pr.stdin = info.Stdin
+		name, err := addProc(state, *c.name, pr)
+		if err != nil {
+			// Nothing would close the process or its pipes.
+			if info.Stdin != nil {
+				info.Stdin.Close()
+			}
+			if info.Stdout != nil {
+				info.Stdout.Close()
+			}
+			if info.Stderr != nil {
+				info.Stderr.Close()
+			}
+			p.Close()
+			return err
+		}
+		fmt.Printf("started process %s (pid %d)\n", name, pr.pid)
+		return nil
+	}
+
+	defer p.Close()
+	if info.Stdin != nil {
+		state.stdin.attach(info.Stdin)
+	}
+	var waitErr error
+	select {
+	case <-pr.exited:
+	case <-ctx.Done():
+		// Don't leave the process running once nothing relays its output.
+		waitErr = fmt.Errorf("process %d %w", pr.pid, hcs.ContextError(ctx))
+		if err := c.terminate(state, p); err != nil {
+			waitErr = fmt.Errorf("%w, and terminating it failed: %s", waitErr, err)
+		}
+	}
+	if info.Stdin != nil {
+		state.stdin.detach()
+		info.Stdin.Close()
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+	pr.relays.Wait()
+
+	status, err := processStatus(ctx, state, p)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("process %d exited with code %d\n", pr.pid, status.ExitCode)
+	return nil
+}
+
+// terminate terminates a process after the command's own context is done.
+func (c *execCommand) terminate(state *state, p hcs.Process) error {
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := p.Terminate(op, ""); err != nil {
+		return err
+	}
+	if _, err := hcs.WaitResult(ctx, op); err != nil {
+		return err
+	}
+	return nil
+}
+
+type psCommand struct {
+	cf      commonFlags
+	handles *bool
+}
+
+func (c *psCommand) Name() string        { return "ps" }
+func (c *psCommand) Description() string { return "Lists processes in a compute system." }
+func (c *psCommand) ArgHelp() string     { return "" }
+func (c *psCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.handles = fs.Bool("handles", false, "List the local process handle table instead.")
+}
+
+func (c *psCommand) Execute(state *state, fs *flag.FlagSet) error {
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	if *c.handles {
+		return listProcs(ctx, state)
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	query, err := json.Marshal(hcsschema.PropertyQuery{
+		PropertyTypes: []hcsschema.PropertyType{hcsschema.PTProcessList},
+	})
+	if err != nil {
+		return err
+	}
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := cs.sys.GetProperties(op, string(query)); err != nil {
+		return err
+	}
+	result, err := hcs.WaitResult(ctx, op)
+	if err != nil {
+		return err
+	}
+	var props hcsschema.Properties
+	if err := json.Unmarshal([]byte(result), &props); err != nil {
+		return err
+	}
+	return printProcessList(props.ProcessList, processNames(state, id))
+}
+
+// processNames returns the names of the processes of compute system id that
+// hcstool started, by PID.
+func processNames(state *state, id string) map[int32]string {
+	names := make(map[int32]string)
+	for name, p := range state.processes {
+		if p.csID == id {
+			names[int32(p.pid)] = name
+		}
+	}
+	return names
+}
+
+// printProcessList prints a ProcessList property as a table, naming the
+// processes in names.
+func printProcessList(list []hcsschema.ProcessDetails, names map[int32]string) error {
+	return printTable(
+		[]colInfo{
+			{header: "PID", format: "%d"},
+			{header: "NAME", format: "%s"},
+			{header: "IMAGE", format: "%s"},
+			{header: "CREATED", format: "%s"},
+			{header: "CPU", format: "%s"},
+			{header: "COMMIT(KB)", format: "%d"},
+			{header: "PRIVATE(KB)", format: "%d"},
+			{header: "SHARED(KB)", format: "%d"},
+		},
+		list,
+		func(pd hcsschema.ProcessDetails) []any {
+			cpu := time.Duration(int64(pd.UserTime100ns)+int64(pd.KernelTime100ns)) * 100
+			return []any{
+				pd.ProcessId,
+				names[pd.ProcessId],
+				pd.ImageName,
+				pd.CreateTimestamp.Local().Format(time.TimeOnly),
+				cpu.Round(time.Millisecond),
+				pd.MemoryCommitBytes / 1024,
+				pd.MemoryWorkingSetPrivateBytes / 1024,
+				pd.MemoryWorkingSetSharedBytes / 1024,
+			}
+		},
+	)
+}
+
+func listProcs(ctx context.Context, state *state) error {
+	names := make([]string, 0, len(state.processes))
+	for name := range state.processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return printTable(
+		[]colInfo{
+			{header: "NAME", format: "%s"},
+			{header: "CS", format: "%s"},
+			{header: "PID", format: "%d"},
+			{header: "STATE", format: "%s"},
+			{header: "COMMAND", format: "%s"},
+		},
+		names,
+		func(name string) []any {
+			p := state.processes[name]
+			s := "running"
+			if p.hasExited() {
+				s = "exited"
+				if status, err := processStatus(ctx, state, p.p); err == nil {
+					s = fmt.Sprintf("exited (%d)", status.ExitCode)
+				}
+			}
+			return []any{name, p.csID, p.pid, s, p.cmd}
+		},
+	)
+}
+
+type popenCommand struct {
+	cf   commonFlags
+	name *string
+}
+
+func (c *popenCommand) Name() string { return "popen" }
+func (c *popenCommand) Description() string {
+	return "Opens an existing process into the handle table."
+}
+func (c *popenCommand) ArgHelp() string { return "PID" }
+func (c *popenCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.name = fs.String("name", "", "Name for the process. Defaults to the next free short name.")
+}
+
+func (c *popenCommand) Execute(state *state, fs *flag.FlagSet) error {
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.ParseUint(fs.Arg(0), 10, 32)
+	if err != nil {
+		return err
+	}
+	if _, ok := state.processes[*c.name]; ok {
+		return fmt.Errorf("process already open: %s", *c.name)
+	}
+	p, err := cs.sys.OpenProcess(uint32(pid))
+	if err != nil {
+		return err
+	}
+	pr := &proc{csID: id, pid: uint32(pid), p: p, exited: make(chan struct{})}
+	if err := pr.watchExit(); err != nil {
+		p.Close()
+		return err
+	}
+	name, err := addProc(state, *c.name, pr)
+	if err != nil {
+		p.Close()
+		return err
+	}
+	fmt.Printf("opened process %s (pid %d)\n", name, pid)
+	return nil
+}
+
+type pcloseCommand struct{}
+
+func (c *pcloseCommand) Name() string                { return "pclose" }
+func (c *pcloseCommand) Description() string         { return "Closes a process handle." }
+func (c *pcloseCommand) ArgHelp() string             { return "PROC" }
+func (c *pcloseCommand) SetupFlags(fs *flag.FlagSet) {}
+
+func (c *pcloseCommand) Execute(state *state, fs *flag.FlagSet) error {
+	if _, err := getProc(state, fs.Arg(0)); err != nil {
+		return err
+	}
+	closeProc(state, fs.Arg(0))
+	return nil
+}
+
+type signalCommand struct{ cf commonFlags }
+
+func (c *signalCommand) Name() string { return "signal" }
+func (c *signalCommand) Description() string {
+	return "Sends a signal to a process. Linux guests take a number or name such as TERM, Windows guests CtrlC, CtrlBreak, CtrlClose, CtrlLogOff or CtrlShutdown."
+}
+func (c *signalCommand) ArgHelp() string             { return "PROC SIGNAL" }
+func (c *signalCommand) SetupFlags(fs *flag.FlagSet) { setupTimeoutFlag(&c.cf, fs) }
+
+func (c *signalCommand) Execute(state *state, fs *flag.FlagSet) error {
+	p, err := getProc(state, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	options, err := signalOptions(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := p.p.Signal(op, string(j)); err != nil {
+		return err
+	}
+	if _, err := hcs.WaitResult(ctx, op); err != nil {
+		return err
+	}
+	return nil
+}
+
+var linuxSignals = map[string]int{
+	"HUP":  1,
+	"INT":  2,
+	"QUIT": 3,
+	"ABRT": 6,
+	"KILL": 9,
+	"USR1": 10,
+	"SEGV": 11,
+	"USR2": 12,
+	"PIPE": 13,
+	"ALRM": 14,
+	"TERM": 15,
+	"CONT": 18,
+	"STOP": 19,
+}
+
+// signalOptions returns the HcsSignalProcess options for a signal given by
+// number, Linux signal name, or Windows console control event.
+func signalOptions(s string) (any, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return hcsschema.SignalProcessOptionsLCOW{Signal: n}, nil
+	}
+	for _, v := range []hcsschema.SignalValueWCOW{
+		hcsschema.SignalValueWCOWCtrlC,
+		hcsschema.SignalValueWCOWCtrlBreak,
+		hcsschema.SignalValueWCOWCtrlClose,
+		hcsschema.SignalValueWCOWCtrlLogOff,
+		hcsschema.SignalValueWCOWCtrlShutdown,
+	} {
+		if strings.EqualFold(s, string(v)) {
+			return hcsschema.SignalProcessOptionsWCOW{Signal: v}, nil
+		}
+	}
+	if n, ok := linuxSignals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return hcsschema.SignalProcessOptionsLCOW{Signal: n}, nil
+	}
+	return nil, fmt.Errorf("unrecognized signal: %s", s)
+}
+
+type killCommand struct{ cf commonFlags }
+
+func (c *killCommand) Name() string                { return "kill" }
+func (c *killCommand) Description() string         { return "Terminates a process." }
+func (c *killCommand) ArgHelp() string             { return "PROC" }
+func (c *killCommand) SetupFlags(fs *flag.FlagSet) { setupTimeoutFlag(&c.cf, fs) }
+
+func (c *killCommand) Execute(state *state, fs *flag.FlagSet) error {
+	p, err := getProc(state, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := p.p.Terminate(op, ""); err != nil {
+		return err
+	}
+	if _, err := hcs.WaitResult(ctx, op); err != nil {
+		return err
+	}
+	return nil
+}
+
+type ttySizeCommand struct{ cf commonFlags }
+
+func (c *ttySizeCommand) Name() string { return "ttysize" }
+func (c *ttySizeCommand) Description() string {
+	return "Resizes the console of a process started with -tty."
+}
+func (c *ttySizeCommand) ArgHelp() string             { return "PROC HEIGHT WIDTH" }
+func (c *ttySizeCommand) SetupFlags(fs *flag.FlagSet) { setupTimeoutFlag(&c.cf, fs) }
+
+func (c *ttySizeCommand) Execute(state *state, fs *flag.FlagSet) error {
+	p, err := getProc(state, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	height, err := strconv.ParseUint(fs.Arg(1), 10, 16)
+	if err != nil {
+		return err
+	}
+	width, err := strconv.ParseUint(fs.Arg(2), 10, 16)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	return modifyProcess(ctx, state, p, hcsschema.ProcessModifyRequest{
+		Operation:   hcsschema.ModifyProcessTypeConsoleSize,
+		ConsoleSize: &hcsschema.ConsoleSize{Height: int32(height), Width: int32(width)},
+	})
+}
+
+type closeStdinCommand struct{ cf commonFlags }
+
+func (c *closeStdinCommand) Name() string                { return "closestdin" }
+func (c *closeStdinCommand) Description() string         { return "Closes the stdin of a process." }
+func (c *closeStdinCommand) ArgHelp() string             { return "PROC" }
+func (c *closeStdinCommand) SetupFlags(fs *flag.FlagSet) { setupTimeoutFlag(&c.cf, fs) }
+
+func (c *closeStdinCommand) Execute(state *state, fs *flag.FlagSet) error {
+	p, err := getProc(state, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opContext(state, &c.cf)
+	defer cancel()
+	if err := modifyProcess(ctx, state, p, hcsschema.ProcessModifyRequest{
+		Operation:   hcsschema.ModifyProcessTypeCloseHandle,
+		CloseHandle: &hcsschema.CloseHandle{Handle: hcsschema.STDInHandle},
+	}); err != nil {
+		return err
+	}
+	if p.stdin != nil {
+		p.stdin.Close()
+		p.stdin = nil
+	}
+	return nil
+}
+
+func modifyProcess(ctx context.Context, state *state, p *proc, req hcsschema.ProcessModifyRequest) error {
+	j, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := p.p.Modify(op, string(j)); err != nil {
+		return err
+	}
+	if _, err := hcs.WaitResult(ctx, op); err != nil {
+		return err
+	}
+	return nil
+}
+
+func processStatus(ctx context.Context, state *state, p hcs.Process) (*hcsschema.ProcessStatus, error) {
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := p.GetProperties(op, ""); err != nil {
+		return nil, err
+	}
+	result, err := hcs.WaitResult(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	var status hcsschema.ProcessStatus
+	if err := json.Unmarshal([]byte(result), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// envFlag collects repeated KEY=VALUE flags into an environment block.
+type envFlag map[string]string
+
+func (f envFlag) String() string {
+	var kvs []string
+	for k, v := range f {
+		kvs = append(kvs, k+"="+v)
+	}
+	return strings.Join(kvs, ",")
+}
+
+func (f envFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("must be in the form KEY=VALUE: %s", s)
+	}
+	f[k] = v
+	return nil
+}
+
+// joinCommandLine builds a command line from arguments, quoting them so that
+// they split back apart the same way under the Windows argument rules.
+func joinCommandLine(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		quoted = append(quoted, quoteArg(a))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			slashes++
+		case '"':
+			// Backslashes preceding a quote must be escaped, as must the quote.
+			b.WriteString(strings.Repeat("\\", slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteByte(s[i])
+	}
+	// As must any backslashes before the closing quote.
+	b.WriteString(strings.Repeat("\\", slashes))
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// propertyTypes are the property types that can be queried with
+// PropertyQuery.PropertyTypes.
+var propertyTypes = []hcsschema.PropertyType{
+	hcsschema.PTMemory,
+	hcsschema.PTGuestMemory,
+	hcsschema.PTStatistics,
+	hcsschema.PTProcessList,
+	hcsschema.PTTerminateOnLastHandleClosed,
+	hcsschema.PTSharedMemoryRegion,
+	hcsschema.PTContainerCredentialGuard,
+	hcsschema.PTGuestConnection,
+	hcsschema.PTICHeartbeatStatus,
+}
+
+// parsePropertyTypes parses a comma separated list of property types,
+// ignoring case.
+func parsePropertyTypes(s string) ([]hcsschema.PropertyType, error) {
+	names := make([]string, len(propertyTypes))
+	for i, pt := range propertyTypes {
+		names[i] = string(pt)
+	}
+	var types []hcsschema.PropertyType
+	for _, t := range strings.Split(s, ",") {
+		name, err := oneOf("types", t, names...)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, hcsschema.PropertyType(name))
+	}
+	return types, nil
+}
+
+// basicPropertyNames are the fields of Properties that HCS returns for every
+// query.
+var basicPropertyNames = []string{
+	"Id", "Name", "SystemType", "RuntimeOsType", "Owner", "RuntimeId", "RuntimeTemplateId",
+	"HostingSystemId", "State", "Stopped", "ExitType",
+}
+
+// field is a named value of a section of properties.
+type field struct {
+	name, value string
+}
+
+// printSection prints a section of properties, leaving out empty values.
+func printSection(title string, fields []field) {
+	width := 0
+	for _, f := range fields {
+		if f.value != "" {
+			width = max(width, len(f.name))
+		}
+	}
+	fmt.Printf("%s:\n", title)
+	for _, f := range fields {
+		if f.value != "" {
+			fmt.Printf("  %-*s  %s\n", width, f.name, f.value)
+		}
+	}
+}
+
+// printProperties renders the response to a property query for types in
+// sections. Properties that have no rendering of their own are printed as
+// JSON.
+func printProperties(result string, types []hcsschema.PropertyType, names map[int32]string) error {
+	var props hcsschema.Properties
+	if err := json.Unmarshal([]byte(result), &props); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(result), &raw); err != nil {
+		return err
+	}
+	// The Queries form of a query returns the basic properties under their
+	// own name.
+	if basic, ok := raw["Basic"]; ok {
+		if err := json.Unmarshal(basic, &props); err == nil {
+			delete(raw, "Basic")
+		}
+	}
+	stopped := ""
+	if props.Stopped {
+		stopped = "yes"
+	}
+	printSection("Basic", []field{
+		{"Id", props.Id},
+		{"Name", props.Name},
+		{"SystemType", props.SystemType},
+		{"RuntimeOsType", props.RuntimeOsType},
+		{"Owner", props.Owner},
+		{"RuntimeId", props.RuntimeId},
+		{"RuntimeTemplateId", props.RuntimeTemplateId},
+		{"HostingSystemId", props.HostingSystemId},
+		{"State", props.State},
+		{"Stopped", stopped},
+		{"ExitType", props.ExitType},
+	})
+	for _, name := range basicPropertyNames {
+		delete(raw, name)
+	}
+	if props.Memory != nil {
+		fmt.Printf("\n")
+		if err := printMemory(props.Memory); err != nil {
+			return err
+		}
+		delete(raw, "Memory")
+	}
+	if props.Statistics != nil {
+		fmt.Printf("\n")
+		printStatistics(props.Statistics)
+		delete(raw, "Statistics")
+	}
+	// An empty process list is left out of the response.
+	if _, ok := raw["ProcessList"]; ok || slices.Contains(types, hcsschema.PTProcessList) {
+		fmt.Printf("\nProcessList:\n")
+		if err := printProcessList(props.ProcessList, names); err != nil {
+			return err
+		}
+		delete(raw, "ProcessList")
+	}
+	others := make([]string, 0, len(raw))
+	for name := range raw {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		j, err := json.MarshalIndent(raw[name], "  ", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n%s:\n  %s\n", name, j)
+	}
+	return nil
+}
+
+func printMemory(m *hcsschema.MemoryInformationForVm) error {
+	var fields []field
+	if vm := m.VirtualMachineMemory; vm != nil {
+		var flags []string
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"SlpActive", vm.SlpActive},
+			{"BalancingEnabled", vm.BalancingEnabled},
+			{"DmOperationInProgress", vm.DmOperationInProgress},
+		} {
+			if f.set {
+				flags = append(flags, f.name)
+			}
+		}
+		fields = append(fields,
+			field{"AssignedMemory", fmt.Sprintf("%d pages (%dMB)", vm.AssignedMemory, vm.AssignedMemory*pageSize>>20)},
+			field{"AvailableMemory", fmt.Sprintf("%dMB", vm.AvailableMemory)},
+			field{"AvailableMemoryBuffer", fmt.Sprintf("%d%%", vm.AvailableMemoryBuffer)},
+			field{"ReservedMemory", fmt.Sprintf("%d", vm.ReservedMemory)},
+			field{"Flags", strings.Join(flags, ", ")},
+		)
+	}
+	fields = append(fields, field{"VirtualNodeCount", fmt.Sprint(m.VirtualNodeCount)})
+	printSection("Memory", fields)
+	if len(m.VirtualNodes) == 0 {
+		return nil
+	}
+	return printTable(
+		[]colInfo{{"VNODE", "%d"}, {"PNODE", "%d"}, {"VPS", "%d"}, {"MEMORY(MB)", "%d"}},
+		m.VirtualNodes,
+		func(n hcsschema.VirtualNodeInfo) []any {
+			return []any{n.VirtualNodeIndex, n.PhysicalNodeNumber, n.VirtualProcessorCount, uint64(n.MemoryUsageInPages) * pageSize >> 20}
+		},
+	)
+}
+
+func printStatistics(s *hcsschema.Statistics) {
+	uptime := time.Duration(s.Uptime100ns) * 100
+	fields := []field{
+		{"Timestamp", formatTime(s.Timestamp)},
+		{"StartTime", formatTime(s.ContainerStartTime)},
+		{"Uptime", uptime.Round(time.Second).String()},
+	}
+	if p := s.Processor; p != nil {
+		total := time.Duration(p.TotalRuntime100ns) * 100
+		cpu := fmt.Sprintf("%s (user %s, kernel %s)",
+			total.Round(time.Millisecond),
+			(time.Duration(p.RuntimeUser100ns) * 100).Round(time.Millisecond),
+			(time.Duration(p.RuntimeKernel100ns) * 100).Round(time.Millisecond))
+		if uptime > 0 {
+			// Of one processor, so VMs with several can exceed 100%.
+			cpu += fmt.Sprintf(", %.1f%% average", float64(total)/float64(uptime)*100)
+		}
+		fields = append(fields, field{"CPUTime", cpu})
+	}
+	if m := s.Memory; m != nil {
+		fields = append(fields,
+			field{"Commit", fmt.Sprintf("%dMB (peak %dMB)", m.MemoryUsageCommitBytes>>20, m.MemoryUsageCommitPeakBytes>>20)},
+			field{"PrivateWorkingSet", fmt.Sprintf("%dMB", m.MemoryUsagePrivateWorkingSetBytes>>20)},
+		)
+	}
+	if st := s.Storage; st != nil {
+		fields = append(fields,
+			field{"StorageReads", fmt.Sprintf("%d (%dMB)", st.ReadCountNormalized, st.ReadSizeBytes>>20)},
+			field{"StorageWrites", fmt.Sprintf("%d (%dMB)", st.WriteCountNormalized, st.WriteSizeBytes>>20)},
+		)
+	}
+	printSection("Statistics", fields)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.DateTime)
+}
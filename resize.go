@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+type resizeCommand struct {
+	cf              commonFlags
+	memory          *uint64
+	hotHint         *bool
+	coldHint        *bool
+	coldDiscardHint *bool
+	cpuLimit        *uint64
+	cpuWeight       *uint64
+	cpuReservation  *uint64
+	maxFrequency    *uint
+}
+
+func (c *resizeCommand) Name() string { return "resize" }
+func (c *resizeCommand) Description() string {
+	return "Changes the memory size, memory hints and processor limits of a running VM."
+}
+func (c *resizeCommand) ArgHelp() string { return "" }
+func (c *resizeCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.memory = fs.Uint64("memory", 0, "New memory size in MB.")
+	c.hotHint = fs.Bool("hothint", false, "Enable or, with -hothint=false, disable hot hinting.")
+	c.coldHint = fs.Bool("coldhint", false, "Enable or, with -coldhint=false, disable cold hinting.")
+	c.coldDiscardHint = fs.Bool("colddiscardhint", false, "Enable or, with -colddiscardhint=false, disable cold discard hinting.")
+	c.cpuLimit = fs.Uint64("cpulimit", 0, "Processor limit, from 0 to 100000 for all of the VM's processors.")
+	c.cpuWeight = fs.Uint64("cpuweight", 0, "Processor weight relative to other VMs (0-10000).")
+	c.cpuReservation = fs.Uint64("cpureservation", 0, "Guaranteed processor reservation, from 0 to 100000 for all of the VM's processors.")
+	c.maxFrequency = fs.Uint("maxfreq", 0, "Target maximum processor frequency in MHz.")
+}
+
+// resizeProperties are the property types compared before and after a resize.
+var resizeProperties = []hcsschema.PropertyType{hcsschema.PTMemory, hcsschema.PTStatistics}
+
+func (c *resizeCommand) Execute(state *state, fs *flag.FlagSet) error {
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	if cs.config != nil && cs.config.VirtualMachine == nil {
+		return fmt.Errorf("%s is not a virtual machine", id)
+	}
+
+	// Only the flags that were given are sent, so that settings can be turned
+	// off as well as on. The generated schema types leave out zero values, so
+	// the settings are sent as maps.
+	var (
+		memorySize bool
+		hints      = make(map[string]bool)
+		limits     = make(map[string]uint64)
+	)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "memory":
+			memorySize = true
+		case "hothint":
+			hints["EnableHotHint"] = *c.hotHint
+		case "coldhint":
+			hints["EnableColdHint"] = *c.coldHint
+		case "colddiscardhint":
+			hints["EnableColdDiscardHint"] = *c.coldDiscardHint
+		case "cpulimit":
+			limits["Limit"] = *c.cpuLimit
+		case "cpuweight":
+			limits["Weight"] = *c.cpuWeight
+		case "cpureservation":
+			limits["Reservation"] = *c.cpuReservation
+		case "maxfreq":
+			limits["MaximumFrequencyMHz"] = uint64(*c.maxFrequency)
+		}
+	})
+	if !memorySize && len(hints) == 0 && len(limits) == 0 {
+		return fmt.Errorf("nothing to change; set -memory, a memory hint flag or a processor limit flag")
+	}
+	if memorySize && *c.memory == 0 {
+		return fmt.Errorf("-memory must be more than 0")
+	}
+	if *c.cpuLimit > 100000 || *c.cpuReservation > 100000 {
+		return fmt.Errorf("processor limits and reservations are from 0 to 100000")
+	}
+
+	// configPath is where a change is kept in the configuration, which
+	// differs from its resource path for the processor limits.
+	type change struct {
+		name, path, configPath string
+		settings               any
+	}
+	var changes []change
+	if memorySize {
+		path := "VirtualMachine/ComputeTopology/Memory/SizeInMB"
+		changes = append(changes, change{"memory size", path, path, *c.memory})
+	}
+	if len(hints) > 0 {
+		path := "VirtualMachine/ComputeTopology/Memory"
+		changes = append(changes, change{"memory hints", path, path, hints})
+	}
+	if len(limits) > 0 {
+		changes = append(changes, change{"processor limits", "VirtualMachine/ComputeTopology/Processor/Limits", "VirtualMachine/ComputeTopology/Processor", limits})
+	}
+
+	before, err := queryProperties(state, &c.cf, cs, resizeProperties...)
+	if err != nil {
+		return err
+	}
+	// Each change is a request of its own, so one can fail after others
+	// have been made.
+	var applied []string
+	for _, ch := range changes {
+		if err := modifySystem(state, &c.cf, cs, "Update", ch.path, ch.settings); err != nil {
+			err = fmt.Errorf("change %s: %w", ch.name, err)
+			if len(applied) > 0 {
+				return fmt.Errorf("%w; the %s change was already made", err, strings.Join(applied, " and "))
+			}
+			return err
+		}
+		trackModify(id, cs, "Update", ch.configPath, ch.settings)
+		applied = append(applied, ch.name)
+	}
+	after, err := queryProperties(state, &c.cf, cs, resizeProperties...)
+	if err != nil {
+		return err
+	}
+	return printResizeComparison(before, after)
+}
+
+func printResizeComparison(before, after *hcsschema.Properties) error {
+	type row struct {
+		name          string
+		before, after uint64
+	}
+	var rows []row
+	add := func(name string, get func(p *hcsschema.Properties) (uint64, bool)) {
+		b, ok1 := get(before)
+		a, ok2 := get(after)
+		if ok1 || ok2 {
+			rows = append(rows, row{name, b, a})
+		}
+	}
+	vmMemory := func(p *hcsschema.Properties) *hcsschema.VmMemory {
+		if p.Memory == nil {
+			return nil
+		}
+		return p.Memory.VirtualMachineMemory
+	}
+	memoryStats := func(p *hcsschema.Properties) *hcsschema.MemoryStats {
+		if p.Statistics == nil {
+			return nil
+		}
+		return p.Statistics.Memory
+	}
+	add("AssignedMemory", func(p *hcsschema.Properties) (uint64, bool) {
+		if m := vmMemory(p); m != nil {
+			return m.AssignedMemory, true
+		}
+		return 0, false
+	})
+	add("AvailableMemory", func(p *hcsschema.Properties) (uint64, bool) {
+		if m := vmMemory(p); m != nil {
+			return uint64(m.AvailableMemory), true
+		}
+		return 0, false
+	})
+	add("AvailableMemoryBuffer", func(p *hcsschema.Properties) (uint64, bool) {
+		if m := vmMemory(p); m != nil {
+			return uint64(m.AvailableMemoryBuffer), true
+		}
+		return 0, false
+	})
+	add("VirtualProcessors", func(p *hcsschema.Properties) (uint64, bool) {
+		if p.Memory == nil || len(p.Memory.VirtualNodes) == 0 {
+			return 0, false
+		}
+		var n uint64
+		for _, node := range p.Memory.VirtualNodes {
+			n += uint64(node.VirtualProcessorCount)
+		}
+		return n, true
+	})
+	add("MemoryUsageCommitBytes", func(p *hcsschema.Properties) (uint64, bool) {
+		if m := memoryStats(p); m != nil {
+			return m.MemoryUsageCommitBytes, true
+		}
+		return 0, false
+	})
+	add("MemoryUsageCommitPeakBytes", func(p *hcsschema.Properties) (uint64, bool) {
+		if m := memoryStats(p); m != nil {
+			return m.MemoryUsageCommitPeakBytes, true
+		}
+		return 0, false
+	})
+	add("MemoryUsagePrivateWorkingSetBytes", func(p *hcsschema.Properties) (uint64, bool) {
+		if m := memoryStats(p); m != nil {
+			return m.MemoryUsagePrivateWorkingSetBytes, true
+		}
+		return 0, false
+	})
+	return printTable(
+		[]colInfo{{"PROPERTY", "%s"}, {"BEFORE", "%d"}, {"AFTER", "%d"}, {"CHANGE", "%s"}},
+		rows,
+		func(r row) []any {
+			change := ""
+			if r.after != r.before {
+				change = fmt.Sprintf("%+d", int64(r.after-r.before))
+			}
+			return []any{r.name, r.before, r.after, change}
+		},
+	)
+}
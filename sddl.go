@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sddlSIDAliases are the two-letter SID strings that SDDL accepts in place of
+// a full SID.
+var sddlSIDAliases = []string{
+	"AA", "AC", "AN", "AO", "AP", "AS", "AU", "BA", "BG", "BO", "BU", "CA", "CD", "CG", "CN", "CO", "CY",
+	"DA", "DC", "DD", "DG", "DU", "EA", "ED", "EK", "ER", "ES", "HA", "HI", "IS", "IU", "KA", "LA", "LG",
+	"LS", "LU", "LW", "ME", "MP", "MU", "NO", "NS", "NU", "OW", "PA", "PO", "PS", "PU", "RA", "RC", "RD",
+	"RE", "RM", "RO", "RS", "RU", "SA", "SI", "SO", "SS", "SU", "SY", "UD", "WD", "WR",
+}
+
+var (
+	sddlACETypes = []string{
+		"A", "D", "OA", "OD", "AU", "AL", "OU", "OL", "ML", "XA", "XD", "ZA", "XU", "SP", "RA", "SCOPED",
+	}
+	sddlACEFlags    = []string{"CI", "OI", "NP", "IO", "ID", "SA", "FA", "TP", "CR"}
+	sddlACLFlags    = []string{"NO_ACCESS_CONTROL", "P", "AI", "AR"}
+	sddlAccessCodes = []string{
+		"GA", "GR", "GW", "GX", "RC", "SD", "WD", "WO", "RP", "WP", "CC", "DC", "LC", "SW", "LO", "DT", "CR",
+		"FA", "FR", "FW", "FX", "KA", "KR", "KW", "KX", "NR", "NW", "NX",
+	}
+	// sddlConditionalTypes are the ACE types that may carry a condition or
+	// resource attribute as a seventh field.
+	sddlConditionalTypes = []string{"XA", "XD", "ZA", "XU", "RA"}
+)
+
+var (
+	sidPattern    = regexp.MustCompile(`^S-1-[0-9]+(-[0-9]+)*$`)
+	guidPattern   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	rightsPattern = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|[0-9]+)$`)
+)
+
+// checkSDDL checks the syntax of a security descriptor string. It does not
+// check that account SIDs exist, which only Windows can do.
+func checkSDDL(s string) error {
+	if s == "" {
+		return fmt.Errorf("empty security descriptor")
+	}
+	seen := make(map[byte]bool)
+	for i := 0; i < len(s); {
+		if i+1 >= len(s) || s[i+1] != ':' || !strings.ContainsRune("OGDS", rune(s[i])) {
+			return fmt.Errorf("expected O:, G:, D: or S: at offset %d", i)
+		}
+		part := s[i]
+		if seen[part] {
+			return fmt.Errorf("%c: appears more than once", part)
+		}
+		seen[part] = true
+		i += 2
+		end := nextSDDLPart(s, i)
+		var err error
+		switch part {
+		case 'O', 'G':
+			err = checkSID(s[i:end])
+		default:
+			err = checkACL(s[i:end])
+		}
+		if err != nil {
+			return fmt.Errorf("%c: %w", part, err)
+		}
+		i = end
+	}
+	return nil
+}
+
+// nextSDDLPart returns the offset of the part of s that follows the one
+// starting at i, or len(s).
+func nextSDDLPart(s string, i int) int {
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ':':
+			// The letter before a colon outside of an ACE starts the next
+			// part. SIDs and ACL flags never contain colons.
+			if depth == 0 && j-1 > i {
+				return j - 1
+			}
+		}
+	}
+	return len(s)
+}
+
+func checkSID(s string) error {
+	if contains(sddlSIDAliases, s) || sidPattern.MatchString(s) {
+		return nil
+	}
+	if s == "" {
+		return fmt.Errorf("missing SID")
+	}
+	return fmt.Errorf("invalid SID %q", s)
+}
+
+func checkACL(s string) error {
+	flags := s
+	if i := strings.IndexByte(s, '('); i >= 0 {
+		flags = s[:i]
+		s = s[i:]
+	} else {
+		s = ""
+	}
+	if err := checkCodes("ACL flags", flags, sddlACLFlags); err != nil {
+		return err
+	}
+	for n := 1; s != ""; n++ {
+		if s[0] != '(' {
+			return fmt.Errorf("expected ( to start ACE %d", n)
+		}
+		end, depth := -1, 0
+		for j := 0; j < len(s) && end < 0; j++ {
+			switch s[j] {
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					end = j
+				}
+			}
+		}
+		if end < 0 {
+			return fmt.Errorf("ACE %d is not closed", n)
+		}
+		if err := checkACE(s[1:end]); err != nil {
+			return fmt.Errorf("ACE %d: %w", n, err)
+		}
+		s = s[end+1:]
+	}
+	return nil
+}
+
+func checkACE(s string) error {
+	fields := strings.SplitN(s, ";", 7)
+	if len(fields) < 6 {
+		return fmt.Errorf("expected 6 fields separated by ;, got %d", len(fields))
+	}
+	typ, flags, rights, object, inherited, sid := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+	if !contains(sddlACETypes, typ) {
+		return fmt.Errorf("unknown ACE type %q", typ)
+	}
+	if len(fields) == 7 && !contains(sddlConditionalTypes, typ) {
+		return fmt.Errorf("ACE type %s takes 6 fields, got 7", typ)
+	}
+	if err := checkCodes("ACE flags", flags, sddlACEFlags); err != nil {
+		return err
+	}
+	if !rightsPattern.MatchString(rights) {
+		if err := checkCodes("access rights", rights, sddlAccessCodes); err != nil {
+			return err
+		}
+	}
+	for _, g := range []string{object, inherited} {
+		if g != "" && !guidPattern.MatchString(g) {
+			return fmt.Errorf("invalid object GUID %q", g)
+		}
+	}
+	return checkSID(sid)
+}
+
+// checkCodes checks that s is a run of the given codes. Longer codes are
+// tried first.
+func checkCodes(what, s string, codes []string) error {
+	for rest := s; rest != ""; {
+		matched := ""
+		for _, c := range codes {
+			if strings.HasPrefix(rest, c) && len(c) > len(matched) {
+				matched = c
+			}
+		}
+		if matched == "" {
+			return fmt.Errorf("invalid %s %q", what, s)
+		}
+		rest = rest[len(matched):]
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSDDL(t *testing.T) {
+	for _, tc := range []struct {
+		sddl string
+		// err is part of the expected error, or empty if the string is valid.
+		err string
+	}{
+		{sddl: "D:(A;;GA;;;SY)"},
+		{sddl: "O:BAG:SYD:(A;;GA;;;SY)(A;;GA;;;BA)"},
+		{sddl: "D:P(A;OICI;0x1f01ff;;;S-1-5-32-544)"},
+		{sddl: "D:PAI(OA;CI;RPWP;bf967a7f-0de6-11d0-a285-00aa003049e2;;S-1-5-21-1-2-3-500)"},
+		{sddl: `D:(XA;;FX;;;WD;(@User.Title=="PM"))`},
+		{sddl: "S:(ML;;NW;;;LW)"},
+		{sddl: "", err: "empty security descriptor"},
+		{sddl: "X:BA", err: "expected O:, G:, D: or S: at offset 0"},
+		{sddl: "O:BAO:SY", err: "O: appears more than once"},
+		{sddl: "O:", err: "O: missing SID"},
+		{sddl: "O:ZZ", err: `O: invalid SID "ZZ"`},
+		{sddl: "D:Q(A;;GA;;;SY)", err: `D: invalid ACL flags "Q"`},
+		{sddl: "D:(A;;GA;;;SY", err: "D: ACE 1 is not closed"},
+		{sddl: "D:(A;;GA;;;SY)junk", err: "D: expected ( to start ACE 2"},
+		{sddl: "D:(Q;;GA;;;SY)", err: `D: ACE 1: unknown ACE type "Q"`},
+		{sddl: "D:(A;;GA;;SY)", err: "D: ACE 1: expected 6 fields separated by ;, got 5"},
+		{sddl: "D:(A;;GA;;;SY;x)", err: "D: ACE 1: ACE type A takes 6 fields, got 7"},
+		{sddl: "D:(A;XX;GA;;;SY)", err: `D: ACE 1: invalid ACE flags "XX"`},
+		{sddl: "D:(A;;ZZ;;;SY)", err: `D: ACE 1: invalid access rights "ZZ"`},
+		{sddl: "D:(OA;;GA;nope;;SY)", err: `D: ACE 1: invalid object GUID "nope"`},
+		{sddl: "D:(A;;GA;;;S-1-x)", err: `D: ACE 1: invalid SID "S-1-x"`},
+	} {
+		err := checkSDDL(tc.sddl)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("checkSDDL(%q) = %v", tc.sddl, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("checkSDDL(%q) = %v, want %s", tc.sddl, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// Plan9 share flags. hcsschema leaves them as a plain number.
+const (
+	plan9ReadOnly      = 0x00000001
+	plan9LinuxMetadata = 0x00000004
+	plan9CaseSensitive = 0x00000008
+)
+
+// plan9Port is the port Plan9 shares are served on by default.
+const plan9Port = 564
+
+// vsmbPresets are named sets of VirtualSmbShareOptions, as option names.
+var vsmbPresets = map[string][]string{
+	"default":         {"PseudoOplocks", "TakeBackupPrivilege", "CacheIo", "ShareRead"},
+	"readonly-cached": {"ReadOnly", "PseudoOplocks", "TakeBackupPrivilege", "CacheIo", "ShareRead"},
+	"layer":           {"ReadOnly", "ReparseBaseLayer", "PseudoOplocks", "TakeBackupPrivilege", "CacheIo", "ShareRead"},
+	"uncached":        {"NonCacheIo", "NoOplocks"},
+	"file":            {"ReadOnly", "SingleFileMapping", "RestrictFileAccess", "CacheIo", "ShareRead"},
+}
+
+// plan9Presets are named sets of Plan9 share flags.
+var plan9Presets = map[string]int32{
+	"default":         plan9LinuxMetadata,
+	"readonly-cached": plan9ReadOnly | plan9LinuxMetadata,
+	"layer":           plan9ReadOnly | plan9LinuxMetadata | plan9CaseSensitive,
+}
+
+// setVSMBOption sets the VirtualSmbShareOptions field called name, ignoring
+// case.
+func setVSMBOption(opts *hcsschema.VirtualSmbShareOptions, name string) error {
+	v := reflect.ValueOf(opts).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if strings.EqualFold(v.Type().Field(i).Name, name) {
+			v.Field(i).SetBool(true)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown virtual SMB option: %s", name)
+}
+
+// vsmbOptionNames returns the names of the options set in opts.
+func vsmbOptionNames(opts *hcsschema.VirtualSmbShareOptions) []string {
+	if opts == nil {
+		return nil
+	}
+	var names []string
+	v := reflect.ValueOf(opts).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Bool() {
+			names = append(names, v.Type().Field(i).Name)
+		}
+	}
+	return names
+}
+
+func presetNames[V any](presets map[string]V) string {
+	return strings.Join(sortedKeys(presets), ", ")
+}
+
+type shareCommand struct {
+	cf            commonFlags
+	proto         *string
+	name          *string
+	preset        *string
+	options       *string
+	allowed       listFlag
+	readOnly      *bool
+	accessName    *string
+	port          *int
+	linuxMetadata *bool
+	caseSensitive *bool
+	noGrant       *bool
+}
+
+func (c *shareCommand) Name() string { return "share" }
+func (c *shareCommand) Description() string {
+	return "Adds, removes or lists Plan9 and virtual SMB shares of a VM."
+}
+func (c *shareCommand) ArgHelp() string { return "add HOSTPATH|remove NAME|list" }
+func (c *shareCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.proto = fs.String("proto", "vsmb", "Share protocol: vsmb or plan9. When removing, defaults to the protocol of the share with that name.")
+	c.name = fs.String("name", "", "Name of the share. Defaults to the last element of the host path.")
+	c.preset = fs.String("preset", "default", fmt.Sprintf("Named set of options. vsmb: %s. plan9: %s.", presetNames(vsmbPresets), presetNames(plan9Presets)))
+	c.options = fs.String("opt", "", "Comma separated virtual SMB options to set on top of the preset, such as NoLocks,NoDirnotify.")
+	c.allowed = nil
+	fs.Var(&c.allowed, "allow", "File under the host path that the guest may access. Restricts the share to the listed files. Can be repeated.")
+	c.readOnly = fs.Bool("ro", false, "Make the share read-only, on top of the preset.")
+	c.accessName = fs.String("aname", "", "Plan9 access name. Defaults to the share name.")
+	c.port = fs.Int("port", plan9Port, "Plan9 port.")
+	c.linuxMetadata = fs.Bool("linuxmetadata", false, "Store Linux metadata on Plan9 shares, on top of the preset.")
+	c.caseSensitive = fs.Bool("casesensitive", false, "Make Plan9 shares case sensitive, on top of the preset.")
+	c.noGrant = fs.Bool("nogrant", false, "Do not grant the VM access to the host path.")
+}
+
+func (c *shareCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sub, err := parseSubcommand(fs, "add", "remove", "list")
+	if err != nil {
+		return err
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	vm, err := vmConfig(id, cs)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "add":
+		return c.add(state, id, cs, vm, fs.Arg(0))
+	case "remove":
+		return c.remove(state, fs, id, cs, vm, fs.Arg(0))
+	default:
+		printTracked(id)
+		return c.list(vm)
+	}
+}
+
+func (c *shareCommand) add(state *state, id string, cs *cs, vm *hcsschema.VirtualMachine, hostPath string) error {
+	if hostPath == "" {
+		return fmt.Errorf("a host path is required")
+	}
+	path, err := filepath.Abs(hostPath)
+	if err != nil {
+		return err
+	}
+	name := *c.name
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	if _, ok := findShare(vm, *c.proto, name); ok {
+		return fmt.Errorf("%s already has a share named %s", id, name)
+	}
+	var settings any
+	switch *c.proto {
+	case "vsmb":
+		settings, err = c.vsmbShare(name, path)
+	case "plan9":
+		settings, err = c.plan9Share(name, path)
+	default:
+		err = fmt.Errorf("unknown share protocol: %s", *c.proto)
+	}
+	if err != nil {
+		return err
+	}
+	if !*c.noGrant {
+		if err := state.hcs.GrantVmAccess(id, path); err != nil {
+			return fmt.Errorf("grant access to %s: %w", path, err)
+		}
+	}
+	if err := modifySystem(state, &c.cf, cs, "Add", shareResourcePath(*c.proto), settings); err != nil {
+		return err
+	}
+	switch s := settings.(type) {
+	case hcsschema.VirtualSmbShare:
+		if vm.Devices.VirtualSmb == nil {
+			vm.Devices.VirtualSmb = &hcsschema.VirtualSmb{}
+		}
+		vm.Devices.VirtualSmb.Shares = append(vm.Devices.VirtualSmb.Shares, s)
+	case hcsschema.Plan9Share:
+		if vm.Devices.Plan9 == nil {
+			vm.Devices.Plan9 = &hcsschema.Plan9{}
+		}
+		vm.Devices.Plan9.Shares = append(vm.Devices.Plan9.Shares, s)
+	}
+	fmt.Printf("added %s share %s for %s\n", *c.proto, name, path)
+	return nil
+}
+
+func (c *shareCommand) vsmbShare(name, path string) (hcsschema.VirtualSmbShare, error) {
+	preset, ok := vsmbPresets[*c.preset]
+	if !ok {
+		return hcsschema.VirtualSmbShare{}, fmt.Errorf("unknown vsmb preset %q, expected one of %s", *c.preset, presetNames(vsmbPresets))
+	}
+	names := append([]string(nil), preset...)
+	if *c.options != "" {
+		names = append(names, strings.Split(*c.options, ",")...)
+	}
+	if *c.readOnly {
+		names = append(names, "ReadOnly")
+	}
+	if len(c.allowed) > 0 {
+		names = append(names, "RestrictFileAccess")
+	}
+	opts := &hcsschema.VirtualSmbShareOptions{}
+	for _, n := range names {
+		if err := setVSMBOption(opts, n); err != nil {
+			return hcsschema.VirtualSmbShare{}, err
+		}
+	}
+	return hcsschema.VirtualSmbShare{
+		Name:         name,
+		Path:         path,
+		AllowedFiles: c.allowed,
+		Options:      opts,
+	}, nil
+}
+
+func (c *shareCommand) plan9Share(name, path string) (hcsschema.Plan9Share, error) {
+	flags, ok := plan9Presets[*c.preset]
+	if !ok {
+		return hcsschema.Plan9Share{}, fmt.Errorf("unknown plan9 preset %q, expected one of %s", *c.preset, presetNames(plan9Presets))
+	}
+	if *c.options != "" {
+		return hcsschema.Plan9Share{}, fmt.Errorf("-opt only applies to vsmb shares")
+	}
+	if *c.readOnly {
+		flags |= plan9ReadOnly
+	}
+	if *c.linuxMetadata {
+		flags |= plan9LinuxMetadata
+	}
+	if *c.caseSensitive {
+		flags |= plan9CaseSensitive
+	}
+	aname := *c.accessName
+	if aname == "" {
+		aname = name
+	}
+	return hcsschema.Plan9Share{
+		Name:         name,
+		AccessName:   aname,
+		Path:         path,
+		Port:         int32(*c.port),
+		Flags:        flags,
+		ReadOnly:     flags&plan9ReadOnly != 0,
+		AllowedFiles: c.allowed,
+	}, nil
+}
+
+func (c *shareCommand) remove(state *state, fs *flag.FlagSet, id string, cs *cs, vm *hcsschema.VirtualMachine, name string) error {
+	if name == "" {
+		return fmt.Errorf("a share name is required")
+	}
+	proto := *c.proto
+	protoSet := false
+	fs.Visit(func(f *flag.Flag) { protoSet = protoSet || f.Name == "proto" })
+	if !protoSet {
+		_, inVSMB := findShare(vm, "vsmb", name)
+		_, inPlan9 := findShare(vm, "plan9", name)
+		switch {
+		case inVSMB && inPlan9:
+			return fmt.Errorf("both a vsmb and a plan9 share are named %s; use -proto", name)
+		case inPlan9:
+			proto = "plan9"
+		}
+	}
+	i, ok := findShare(vm, proto, name)
+	if !ok {
+		return fmt.Errorf("%s has no %s share named %s", id, proto, name)
+	}
+	// Shares are matched by name. Plan9 also needs the access name and port
+	// of the share being removed.
+	var settings any = hcsschema.VirtualSmbShare{Name: name}
+	if proto == "plan9" {
+		s := vm.Devices.Plan9.Shares[i]
+		settings = hcsschema.Plan9Share{Name: name, AccessName: s.AccessName, Port: s.Port}
+	}
+	if err := modifySystem(state, &c.cf, cs, "Remove", shareResourcePath(proto), settings); err != nil {
+		return err
+	}
+	if proto == "plan9" {
+		shares := vm.Devices.Plan9.Shares
+		vm.Devices.Plan9.Shares = append(shares[:i:i], shares[i+1:]...)
+	} else {
+		shares := vm.Devices.VirtualSmb.Shares
+		vm.Devices.VirtualSmb.Shares = append(shares[:i:i], shares[i+1:]...)
+	}
+	return nil
+}
+
+// findShare returns the index of the share called name.
+func findShare(vm *hcsschema.VirtualMachine, proto, name string) (int, bool) {
+	switch proto {
+	case "vsmb":
+		if vm.Devices.VirtualSmb != nil {
+			for i, s := range vm.Devices.VirtualSmb.Shares {
+				if s.Name == name {
+					return i, true
+				}
+			}
+		}
+	case "plan9":
+		if vm.Devices.Plan9 != nil {
+			for i, s := range vm.Devices.Plan9.Shares {
+				if s.Name == name {
+					return i, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func (c *shareCommand) list(vm *hcsschema.VirtualMachine) error {
+	type share struct {
+		proto, name, path, options string
+		allowed                    int
+	}
+	var shares []share
+	if vm.Devices.VirtualSmb != nil {
+		for _, s := range vm.Devices.VirtualSmb.Shares {
+			shares = append(shares, share{"vsmb", s.Name, s.Path, strings.Join(vsmbOptionNames(s.Options), ","), len(s.AllowedFiles)})
+		}
+	}
+	if vm.Devices.Plan9 != nil {
+		for _, s := range vm.Devices.Plan9.Shares {
+			var opts []string
+			for _, f := range []struct {
+				flag int32
+				name string
+			}{{plan9ReadOnly, "ReadOnly"}, {plan9LinuxMetadata, "LinuxMetadata"}, {plan9CaseSensitive, "CaseSensitive"}} {
+				if s.Flags&f.flag != 0 {
+					opts = append(opts, f.name)
+				}
+			}
+			opts = append(opts, fmt.Sprintf("Port=%d", s.Port))
+			shares = append(shares, share{"plan9", s.Name, s.Path, strings.Join(opts, ","), len(s.AllowedFiles)})
+		}
+	}
+	sort.SliceStable(shares, func(i, j int) bool { return shares[i].name < shares[j].name })
+	return printTable(
+		[]colInfo{{"PROTO", "%s"}, {"NAME", "%s"}, {"ALLOWED", "%d"}, {"PATH", "%s"}, {"OPTIONS", "%s"}},
+		shares,
+		func(s share) []any { return []any{s.proto, s.name, s.allowed, s.path, s.options} },
+	)
+}
+
+func shareResourcePath(proto string) string {
+	if proto == "plan9" {
+		return "VirtualMachine/Devices/Plan9/Shares"
+	}
+	return "VirtualMachine/Devices/VirtualSmb/Shares"
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+)
+
+type simFailCommand struct {
+	clear      *bool
+	hang       *bool
+	disconnect *bool
+}
+
+func (c *simFailCommand) Name() string { return "simfail" }
+func (c *simFailCommand) Description() string {
+	return "Injects a failure into the next call to a simulator method."
+}
+func (c *simFailCommand) ArgHelp() string { return "METHOD [HRESULT]" }
+func (c *simFailCommand) SetupFlags(fs *flag.FlagSet) {
+	c.clear = fs.Bool("clear", false, "Clear all pending injected failures.")
+	c.hang = fs.Bool("hang", false, "Make the next operation hang until the system stops, instead of failing.")
+	c.disconnect = fs.Bool("disconnect", false, "Send a ServiceDisconnect event to every open compute system, instead of failing.")
+}
+
+func (c *simFailCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sim, ok := state.hcs.(*hcs.Simulator)
+	if !ok {
+		return fmt.Errorf("not using the simulator backend")
+	}
+	if *c.clear {
+		sim.ClearFailures()
+		return nil
+	}
+	if *c.disconnect {
+		sim.Disconnect()
+		return nil
+	}
+	if *c.hang {
+		return sim.HangNext(fs.Arg(0))
+	}
+	hr, err := hcs.ParseHRESULT(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return sim.FailNext(fs.Arg(0), hr)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// stdinRouter owns the terminal's input, and forwards it either to the REPL or,
+// while one is attached, to the stdin of a guest process.
+//
+// The REPL buffers its input, so anything it has already read ahead will not
+// reach an attached process. This only matters when commands are piped in
+// rather than typed.
+type stdinRouter struct {
+	mu     sync.Mutex
+	repl   io.WriteCloser
+	target io.WriteCloser
+	eof    bool
+}
+
+// newStdinRouter replaces os.Stdin with a pipe that is fed by the router. It
+// must be called before the REPL starts reading.
+func newStdinRouter() (*stdinRouter, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	in := os.Stdin
+	os.Stdin = r
+	sr := &stdinRouter{repl: w}
+	go sr.run(in)
+	return sr, nil
+}
+
+func (sr *stdinRouter) run(in io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := in.Read(buf)
+		sr.mu.Lock()
+		target := sr.target
+		if err != nil && target != nil {
+			// EOF ends the attached process's input, but not the REPL's.
+			sr.target = nil
+		}
+		sr.mu.Unlock()
+		if n > 0 {
+			if target != nil {
+				// If the process has gone away, drop its input rather than
+				// running it as commands.
+				target.Write(buf[:n])
+			} else if _, err := sr.repl.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			if target != nil {
+				target.Close()
+				continue
+			}
+			sr.mu.Lock()
+			sr.eof = true
+			sr.mu.Unlock()
+			sr.repl.Close()
+			return
+		}
+	}
+}
+
+// attach sends further input to w, until EOF is read or detach is called. w is
+// closed on EOF, including when there is no more input to begin with.
+func (sr *stdinRouter) attach(w io.WriteCloser) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.eof {
+		w.Close()
+		return
+	}
+	sr.target = w
+}
+
+func (sr *stdinRouter) detach() {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.target = nil
+}
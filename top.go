@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// topSample is a compute system's row in top.
+type topSample struct {
+	id    string
+	state string
+	stats *hcsschema.Statistics
+	// The rates are over the time since the previous sample, or since the
+	// system started for its first sample.
+	cpu, read, write float64
+}
+
+// topColumn is a column of top. Columns with a key sort by it, largest
+// first, and the others by their text.
+type topColumn struct {
+	header string
+	key    func(s *topSample) float64
+	text   func(s *topSample) string
+}
+
+// topNumber returns a column that shows and sorts by a number.
+func topNumber(header string, key func(s *topSample) float64) topColumn {
+	return topColumn{header, key, func(s *topSample) string { return fmt.Sprintf("%.1f", key(s)) }}
+}
+
+var topColumns = []topColumn{
+	{header: "ID", text: func(s *topSample) string { return s.id }},
+	{header: "STATE", text: func(s *topSample) string { return s.state }},
+	topNumber("CPU%", func(s *topSample) float64 { return s.cpu }),
+	{
+		header: "UPTIME",
+		key:    func(s *topSample) float64 { return float64(s.stats.Uptime100ns) },
+		text: func(s *topSample) string {
+			return (time.Duration(s.stats.Uptime100ns) * 100).Round(time.Second).String()
+		},
+	},
+	topNumber("PRIVATE(MB)", func(s *topSample) float64 {
+		return float64(s.memory().MemoryUsagePrivateWorkingSetBytes) / (1 << 20)
+	}),
+	topNumber("COMMITPEAK(MB)", func(s *topSample) float64 {
+		return float64(s.memory().MemoryUsageCommitPeakBytes) / (1 << 20)
+	}),
+	topNumber("READ(KB/S)", func(s *topSample) float64 { return s.read / 1024 }),
+	topNumber("WRITE(KB/S)", func(s *topSample) float64 { return s.write / 1024 }),
+}
+
+func (s *topSample) memory() *hcsschema.MemoryStats {
+	if s.stats.Memory == nil {
+		return &hcsschema.MemoryStats{}
+	}
+	return s.stats.Memory
+}
+
+func (s *topSample) storage() *hcsschema.StorageStats {
+	if s.stats.Storage == nil {
+		return &hcsschema.StorageStats{}
+	}
+	return s.stats.Storage
+}
+
+func (s *topSample) processor() *hcsschema.ProcessorStats {
+	if s.stats.Processor == nil {
+		return &hcsschema.ProcessorStats{}
+	}
+	return s.stats.Processor
+}
+
+// findTopColumn returns the index of the column whose header starts with
+// name, ignoring case.
+func findTopColumn(name string) (int, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" {
+		return 0, false
+	}
+	for i, col := range topColumns {
+		if strings.HasPrefix(col.header, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+type topCommand struct {
+	cf       commonFlags
+	all      *bool
+	interval *time.Duration
+	sortBy   *string
+	count    *int
+	batch    *bool
+}
+
+func (c *topCommand) Name() string { return "top" }
+func (c *topCommand) Description() string {
+	return "Shows CPU, memory and storage use of compute systems, refreshing until q or Ctrl-C. The timeout bounds each refresh's HCS calls."
+}
+func (c *topCommand) ArgHelp() string { return "" }
+func (c *topCommand) SetupFlags(fs *flag.FlagSet) {
+	setupTimeoutFlag(&c.cf, fs)
+	c.all = fs.Bool("all", false, "Show all systems on the host instead of only those you have open.")
+	c.interval = fs.Duration("interval", 2*time.Second, "Time between refreshes.")
+	c.sortBy = fs.String("sort", "CPU%", "Column to sort by, or the start of its name. While running, type a column name and Enter to sort by it, again to reverse.")
+	c.count = fs.Int("n", 0, "Number of refreshes to show. 0 keeps refreshing.")
+	c.batch = fs.Bool("b", false, "Print each refresh below the last instead of redrawing the screen.")
+}
+
+func (c *topCommand) Execute(state *state, fs *flag.FlagSet) error {
+	if *c.interval <= 0 {
+		return fmt.Errorf("-interval must be more than 0")
+	}
+	sortCol, ok := findTopColumn(*c.sortBy)
+	if !ok {
+		return fmt.Errorf("-sort: no column named %q", *c.sortBy)
+	}
+	reverse := false
+	if !*c.batch {
+		enableVT()
+	}
+
+	// The timeout bounds each refresh's HCS calls, not how long top runs.
+	ctx, cancel := interruptContext(state)
+	defer cancel()
+
+	// Lines typed while top runs choose the sort column.
+	r, w := io.Pipe()
+	defer r.Close()
+	state.stdin.attach(w)
+	defer state.stdin.detach()
+	input := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case input <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		close(input)
+	}()
+
+	// Systems that top opened itself are closed when it ends.
+	opened := make(map[string]hcs.System)
+	defer func() {
+		for _, sys := range opened {
+			sys.Close()
+		}
+	}()
+	prev := make(map[string]*hcsschema.Statistics)
+	ticker := time.NewTicker(*c.interval)
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		samples, err := c.sample(ctx, state, opened, prev)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := c.draw(samples, sortCol, reverse, ""); err != nil {
+			return err
+		}
+		if *c.count > 0 && n >= *c.count {
+			return nil
+		}
+		for waiting := true; waiting; {
+			select {
+			case <-ticker.C:
+				waiting = false
+			case line, ok := <-input:
+				if !ok {
+					// Without input, keep refreshing.
+					input = nil
+					continue
+				}
+				if strings.EqualFold(strings.TrimSpace(line), "q") {
+					return nil
+				}
+				status := ""
+				if i, ok := findTopColumn(line); !ok {
+					status = fmt.Sprintf("no column named %q", strings.TrimSpace(line))
+				} else {
+					reverse = i == sortCol && !reverse
+					sortCol = i
+				}
+				if err := c.draw(samples, sortCol, reverse, status); err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				// Ctrl-C ends top, and is not an error.
+				return nil
+			}
+		}
+	}
+}
+
+// sample queries the statistics of each system, and works out its rates
+// from prev, which it updates.
+func (c *topCommand) sample(ctx context.Context, state *state, opened map[string]hcs.System, prev map[string]*hcsschema.Statistics) ([]*topSample, error) {
+	systems := make(map[string]hcs.System)
+	for id, cs := range state.systems {
+		systems[id] = cs.sys
+	}
+	timeout := opTimeout(state, &c.cf)
+	if *c.all {
+		enumCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			enumCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		ids, err := enumerateSystems(enumCtx, state)
+		if err != nil {
+			return nil, err
+		}
+		present := make(map[string]bool)
+		for _, id := range ids {
+			present[id] = true
+			if _, ok := systems[id]; ok {
+				continue
+			}
+			if sys, ok := opened[id]; ok {
+				systems[id] = sys
+				continue
+			}
+			// Systems can go away between being listed and opened.
+			if sys, err := state.hcs.OpenComputeSystem(id); err == nil {
+				opened[id] = sys
+				systems[id] = sys
+			}
+		}
+		for id, sys := range opened {
+			if !present[id] {
+				sys.Close()
+				delete(opened, id)
+				delete(systems, id)
+			}
+		}
+	}
+
+	// A system that does not answer within the interval, or the timeout if
+	// that is shorter, is shown without statistics, rather than holding up
+	// the others.
+	pollTimeout := *c.interval
+	if timeout > 0 && timeout < pollTimeout {
+		pollTimeout = timeout
+	}
+	pollCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+	samples := make([]*topSample, 0, len(systems))
+	var wg sync.WaitGroup
+	for id, sys := range systems {
+		s := &topSample{id: id}
+		samples = append(samples, s)
+		wg.Add(1)
+		go func(sys hcs.System) {
+			defer wg.Done()
+			props, err := topQuery(pollCtx, state, sys)
+			if err != nil {
+				s.state = "error"
+				return
+			}
+			s.state = props.State
+			s.stats = props.Statistics
+		}(sys)
+	}
+	wg.Wait()
+
+	for _, s := range samples {
+		if s.stats == nil {
+			delete(prev, s.id)
+			continue
+		}
+		s.cpu, s.read, s.write = topRates(s.stats, prev[s.id])
+		prev[s.id] = s.stats
+	}
+	for id := range prev {
+		if _, ok := systems[id]; !ok {
+			delete(prev, id)
+		}
+	}
+	return samples, nil
+}
+
+// topRates returns a system's CPU% and storage read and write rates in bytes
+// per second over the time since its previous sample, or since it started if
+// there is none.
+func topRates(cur, prev *hcsschema.Statistics) (cpu, read, write float64) {
+	s := &topSample{stats: cur}
+	old := &topSample{stats: &hcsschema.Statistics{}}
+	// Counters that went backwards belong to a new system with the same ID,
+	// so its sample counts as its first.
+	if prev != nil {
+		p := &topSample{stats: prev}
+		if cur.Timestamp.After(prev.Timestamp) &&
+			cur.Uptime100ns >= prev.Uptime100ns &&
+			s.processor().TotalRuntime100ns >= p.processor().TotalRuntime100ns &&
+			s.storage().ReadSizeBytes >= p.storage().ReadSizeBytes &&
+			s.storage().WriteSizeBytes >= p.storage().WriteSizeBytes {
+			old = p
+		}
+	}
+	var elapsed float64
+	if old.stats.Timestamp.IsZero() {
+		elapsed = float64(cur.Uptime100ns) / 1e7
+	} else {
+		elapsed = cur.Timestamp.Sub(old.stats.Timestamp).Seconds()
+	}
+	if elapsed <= 0 {
+		return 0, 0, 0
+	}
+	// CPU% is of one processor, so systems with several can exceed 100.
+	cpu = float64(s.processor().TotalRuntime100ns-old.processor().TotalRuntime100ns) / 1e7 / elapsed * 100
+	read = float64(s.storage().ReadSizeBytes-old.storage().ReadSizeBytes) / elapsed
+	write = float64(s.storage().WriteSizeBytes-old.storage().WriteSizeBytes) / elapsed
+	return cpu, read, write
+}
+
+func topQuery(ctx context.Context, state *state, sys hcs.System) (*hcsschema.Properties, error) {
+	j, err := json.Marshal(hcsschema.PropertyQuery{PropertyTypes: []hcsschema.PropertyType{hcsschema.PTStatistics}})
+	if err != nil {
+		return nil, err
+	}
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := sys.GetProperties(op, string(j)); err != nil {
+		return nil, err
+	}
+	result, err := hcs.WaitResult(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	var props hcsschema.Properties
+	if err := json.Unmarshal([]byte(result), &props); err != nil {
+		return nil, err
+	}
+	return &props, nil
+}
+
+// enumerateSystems returns the IDs of all compute systems on the host.
+func enumerateSystems(ctx context.Context, state *state) ([]string, error) {
+	op := state.hcs.NewOperation()
+	defer op.Close()
+	if err := state.hcs.EnumerateComputeSystems("", op); err != nil {
+		return nil, err
+	}
+	result, err := hcs.WaitResult(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	var systems []struct {
+		ID string `json:"Id"`
+	}
+	if err := json.Unmarshal([]byte(result), &systems); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(systems))
+	for i, s := range systems {
+		ids[i] = s.ID
+	}
+	return ids, nil
+}
+
+func (c *topCommand) draw(samples []*topSample, sortCol int, reverse bool, status string) error {
+	col := topColumns[sortCol]
+	sorted := append([]*topSample(nil), samples...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		// Systems without statistics go last either way.
+		if col.key != nil && (a.stats == nil) != (b.stats == nil) {
+			return b.stats == nil
+		}
+		if reverse {
+			a, b = b, a
+		}
+		if col.key != nil && a.stats != nil {
+			if x, y := col.key(a), col.key(b); x != y {
+				return x > y
+			}
+		} else if col.key == nil {
+			if x, y := col.text(a), col.text(b); x != y {
+				return x < y
+			}
+		}
+		return a.id < b.id
+	})
+
+	if *c.batch {
+		fmt.Printf("\n")
+	} else {
+		// Home the cursor and clear the screen.
+		fmt.Printf("\x1b[H\x1b[2J")
+	}
+	order := ""
+	if reverse {
+		order = ", reversed"
+	}
+	fmt.Printf("%s  %d systems, sorted by %s%s\n", time.Now().Format(time.TimeOnly), len(samples), col.header, order)
+	cols := make([]colInfo, len(topColumns))
+	for i, col := range topColumns {
+		cols[i] = colInfo{col.header, "%s"}
+	}
+	if err := printTable(cols, sorted, func(s *topSample) []any {
+		row := make([]any, len(topColumns))
+		for i, col := range topColumns {
+			if col.key != nil && s.stats == nil {
+				row[i] = "-"
+			} else {
+				row[i] = col.text(s)
+			}
+		}
+		return row
+	}); err != nil {
+		return err
+	}
+	if status != "" {
+		fmt.Printf("%s\n", status)
+	}
+	return nil
+}
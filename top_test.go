@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+func TestTopRates(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats := func(at time.Duration, uptime time.Duration, cpu time.Duration, read, write uint64) *hcsschema.Statistics {
+		return &hcsschema.Statistics{
+			Timestamp:   start.Add(at),
+			Uptime100ns: uint64(uptime / 100),
+			Processor:   &hcsschema.ProcessorStats{TotalRuntime100ns: uint64(cpu / 100)},
+			Storage:     &hcsschema.StorageStats{ReadSizeBytes: read, WriteSizeBytes: write},
+		}
+	}
+	for _, tc := range []struct {
+		name             string
+		cur, prev        *hcsschema.Statistics
+		cpu, read, write float64
+	}{
+		{
+			name: "first sample is since start",
+			cur:  stats(10*time.Second, 10*time.Second, 5*time.Second, 10240, 2048),
+			cpu:  50, read: 1024, write: 204.8,
+		},
+		{
+			name: "delta from previous sample",
+			prev: stats(10*time.Second, 10*time.Second, 5*time.Second, 10240, 2048),
+			cur:  stats(12*time.Second, 12*time.Second, 8*time.Second, 14336, 2048),
+			cpu:  150, read: 2048, write: 0,
+		},
+		{
+			name: "counters reset by a recreated system",
+			prev: stats(10*time.Second, 100*time.Second, 90*time.Second, 1<<30, 1<<30),
+			cur:  stats(12*time.Second, 2*time.Second, 1*time.Second, 4096, 0),
+			cpu:  50, read: 2048, write: 0,
+		},
+		{
+			name: "storage counter reset alone",
+			prev: stats(10*time.Second, 10*time.Second, 5*time.Second, 1<<30, 0),
+			cur:  stats(12*time.Second, 12*time.Second, 6*time.Second, 1200, 0),
+			cpu:  50, read: 100, write: 0,
+		},
+		{
+			name: "sample not newer than previous",
+			prev: stats(10*time.Second, 10*time.Second, 5*time.Second, 0, 0),
+			cur:  stats(10*time.Second, 10*time.Second, 5*time.Second, 0, 0),
+			cpu:  50,
+		},
+		{
+			name: "no uptime",
+			cur:  &hcsschema.Statistics{Timestamp: start},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, read, write := topRates(tc.cur, tc.prev)
+			if cpu != tc.cpu || read != tc.read || write != tc.write {
+				t.Errorf("topRates() = %v, %v, %v, want %v, %v, %v", cpu, read, write, tc.cpu, tc.read, tc.write)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// pageSize is the size of the pages MemoryInformationForVm counts.
+const pageSize = 4096
+
+type topologyCommand struct {
+	cf commonFlags
+}
+
+func (c *topologyCommand) Name() string { return "topology" }
+func (c *topologyCommand) Description() string {
+	return "Shows the host's NUMA nodes, packages, cores and logical processors, with CPU groups and VM memory."
+}
+func (c *topologyCommand) ArgHelp() string { return "" }
+func (c *topologyCommand) SetupFlags(fs *flag.FlagSet) {
+	setupTimeoutFlag(&c.cf, fs)
+}
+
+func (c *topologyCommand) Execute(state *state, fs *flag.FlagSet) error {
+	var topology hcsschema.ProcessorTopology
+	if err := serviceProperty(state, hcsschema.PTProcessorTopology, &topology); err != nil {
+		return err
+	}
+	groups, err := cpuGroups(state)
+	if err != nil {
+		return err
+	}
+	// Groups are labelled by their hypervisor ID, which is shorter than the
+	// group ID.
+	lpGroups := make(map[uint32][]string)
+	if len(groups) > 0 {
+		fmt.Printf("CPU groups:\n")
+	}
+	for _, g := range groups {
+		var lps []uint32
+		if g.Affinity != nil {
+			for _, lp := range g.Affinity.LogicalProcessors {
+				lps = append(lps, uint32(lp))
+				lpGroups[uint32(lp)] = append(lpGroups[uint32(lp)], fmt.Sprintf("#%d", g.HypervisorGroupId))
+			}
+		}
+		sort.Slice(lps, func(i, j int) bool { return lps[i] < lps[j] })
+		fmt.Printf("  #%d %s: LPs %s\n", g.HypervisorGroupId, g.GroupId, formatLPList(lps))
+	}
+	nodeMemory := c.nodeMemory(state)
+
+	lps := append([]hcsschema.LogicalProcessor(nil), topology.LogicalProcessors...)
+	sort.Slice(lps, func(i, j int) bool {
+		a, b := lps[i], lps[j]
+		if a.NodeNumber != b.NodeNumber {
+			return a.NodeNumber < b.NodeNumber
+		}
+		if a.PackageId != b.PackageId {
+			return a.PackageId < b.PackageId
+		}
+		if a.CoreId != b.CoreId {
+			return a.CoreId < b.CoreId
+		}
+		return a.LpIndex < b.LpIndex
+	})
+	for i, lp := range lps {
+		newNode := i == 0 || lp.NodeNumber != lps[i-1].NodeNumber
+		newPackage := newNode || lp.PackageId != lps[i-1].PackageId
+		newCore := newPackage || lp.CoreId != lps[i-1].CoreId
+		if newNode {
+			fmt.Printf("node %d%s\n", lp.NodeNumber, nodeMemory[int32(lp.NodeNumber)])
+		}
+		if newPackage {
+			fmt.Printf("  package %d\n", lp.PackageId)
+		}
+		if newCore {
+			fmt.Printf("    core %d\n", lp.CoreId)
+		}
+		rootVP := "-"
+		if lp.RootVpIndex >= 0 {
+			rootVP = fmt.Sprint(lp.RootVpIndex)
+		}
+		line := fmt.Sprintf("      LP %d: root VP %s", lp.LpIndex, rootVP)
+		if g := lpGroups[lp.LpIndex]; len(g) > 0 {
+			line += ", groups " + strings.Join(g, ",")
+		}
+		fmt.Printf("%s\n", line)
+	}
+	return nil
+}
+
+// nodeMemory describes the memory that each open VM uses on each physical
+// node, keyed by node number. VMs whose memory cannot be queried, such as
+// those that are not running, are left out.
+func (c *topologyCommand) nodeMemory(state *state) map[int32]string {
+	type usage struct {
+		id    string
+		bytes uint64
+	}
+	nodes := make(map[int32][]usage)
+	for _, id := range sortedKeys(state.systems) {
+		cs := state.systems[id]
+		if cs.config != nil && cs.config.VirtualMachine == nil {
+			continue
+		}
+		props, err := queryProperties(state, &c.cf, cs, hcsschema.PTMemory)
+		if err != nil || props.Memory == nil {
+			continue
+		}
+		perNode := make(map[int32]uint64)
+		for _, vn := range props.Memory.VirtualNodes {
+			perNode[vn.PhysicalNodeNumber] += uint64(vn.MemoryUsageInPages) * pageSize
+		}
+		for node, bytes := range perNode {
+			nodes[node] = append(nodes[node], usage{id, bytes})
+		}
+	}
+	result := make(map[int32]string)
+	for node, usages := range nodes {
+		var total uint64
+		var parts []string
+		for _, u := range usages {
+			total += u.bytes
+			parts = append(parts, fmt.Sprintf("%s %dMB", u.id, u.bytes>>20))
+		}
+		result[node] = fmt.Sprintf(": VMs use %dMB (%s)", total>>20, strings.Join(parts, ", "))
+	}
+	return result
+}
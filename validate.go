@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// typeEnums lists the values of hcsschema's named string types.
+var typeEnums = map[reflect.Type][]string{
+	reflect.TypeOf(hcsschema.DeviceType("")):               {"ClassGuid", "DeviceInstance", "GpuMirror"},
+	reflect.TypeOf(hcsschema.InterruptModerationName("")):  interruptModerationNames(),
+	reflect.TypeOf(hcsschema.MigrationOrigin("")):          {"Source", "Destination"},
+	reflect.TypeOf(hcsschema.MigrationMemoryTransport("")): {"TCP"},
+	reflect.TypeOf(hcsschema.MigrationFinalOperation("")):  {"Resume", "Stop"},
+	reflect.TypeOf(hcsschema.ShutdownMechanism("")):        {"GuestConnection", "IntegrationService"},
+	reflect.TypeOf(hcsschema.ShutdownType("")):             {"Shutdown", "Hibernate", "Reboot"},
+}
+
+// interruptModerationNames returns the names of the interrupt moderation
+// modes, in order of the values HCS reports them as.
+func interruptModerationNames() []string {
+	var values []hcsschema.InterruptModerationValue
+	for v := range hcsschema.InterruptModerationValueToName {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = string(hcsschema.InterruptModerationValueToName[v])
+	}
+	return names
+}
+
+// fieldEnums lists the values of string fields that are enums in the schema,
+// but plain strings in hcsschema. They are keyed by TYPE.KEY, where KEY is the
+// field's JSON name.
+var fieldEnums = map[string][]string{
+	"Attachment.Type":                  {"VirtualDisk", "Iso", "PassThru"},
+	"Attachment.CachingMode":           {"Uncached", "Cached", "ReadOnlyCached"},
+	"GuestState.GuestStateFileType":    {"Default", "FileMode", "BlockStorage"},
+	"ModifySettingRequest.RequestType": {"Add", "Remove", "Update"},
+	"SaveOptions.SaveType":             {"ToFile", "AsTemplate"},
+	"Uefi.ApplySecureBootTemplate":     {"Skip", "Apply"},
+	"Uefi.Console":                     {"Default", "ComPort1", "ComPort2", "None"},
+	"UefiBootEntry.DeviceType":         {"ScsiDrive", "VmbFs", "Network", "File"},
+	"VirtualPMemDevice.ImageFormat":    {"Vhd1", "Vhdx"},
+	"VirtualPMemMapping.ImageFormat":   {"Vhd1", "Vhdx"},
+}
+
+// documentTypes are the documents that validate knows how to check.
+var documentTypes = map[string]reflect.Type{
+	"ComputeSystem":              reflect.TypeOf(hcsschema.ComputeSystem{}),
+	"ModifySettingRequest":       reflect.TypeOf(hcsschema.ModifySettingRequest{}),
+	"MigrationInitializeOptions": reflect.TypeOf(hcsschema.MigrationInitializeOptions{}),
+	"ProcessParameters":          reflect.TypeOf(hcsschema.ProcessParameters{}),
+	"SaveOptions":                reflect.TypeOf(hcsschema.SaveOptions{}),
+	"ShutdownOptions":            reflect.TypeOf(hcsschema.ShutdownOptions{}),
+}
+
+// schemaProblem is a way in which a document does not match the schema. Path
+// is a JSON pointer to the offending value.
+type schemaProblem struct {
+	path string
+	msg  string
+}
+
+func (p schemaProblem) String() string {
+	if p.path == "" {
+		return "document: " + p.msg
+	}
+	return p.path + ": " + p.msg
+}
+
+// checkDocument reports every way in which doc does not match t. Unlike
+// decoding with encoding/json, it carries on past the first problem, and
+// treats field names as case sensitive, as HCS does.
+func checkDocument(doc []byte, t reflect.Type) ([]schemaProblem, error) {
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if d.More() {
+		return nil, fmt.Errorf("invalid JSON: unexpected data after the document")
+	}
+	var problems []schemaProblem
+	checkValue(&problems, "", v, t, "")
+	if len(problems) == 0 {
+		// Anything that got past the checks above should also decode
+		// strictly. If not, the checks are missing a case.
+		d := json.NewDecoder(bytes.NewReader(doc))
+		d.DisallowUnknownFields()
+		if err := d.Decode(reflect.New(t).Interface()); err != nil {
+			problems = append(problems, schemaProblem{"", err.Error()})
+		}
+	}
+	return problems, nil
+}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+)
+
+// checkValue checks v, found at path, against t. field names the struct field
+// holding v as TYPE.KEY, for looking up its enum values.
+func checkValue(problems *[]schemaProblem, path string, v any, t reflect.Type, field string) {
+	fail := func(format string, args ...any) {
+		*problems = append(*problems, schemaProblem{path, fmt.Sprintf(format, args...)})
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if v == nil || t == rawMessageType || t.Kind() == reflect.Interface {
+		return
+	}
+	switch {
+	case t == timeType:
+		s, ok := v.(string)
+		if !ok {
+			fail("expected a timestamp string, got %s", jsonKind(v))
+		} else if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			fail("invalid timestamp %q", s)
+		}
+		return
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		s, ok := v.(string)
+		if !ok {
+			fail("expected a base64 string, got %s", jsonKind(v))
+		} else if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+			fail("invalid base64 data")
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("expected an object (%s), got %s", t.Name(), jsonKind(v))
+			return
+		}
+		fields := structFields(t)
+		for _, k := range sortedKeys(obj) {
+			p := path + "/" + escapePointer(k)
+			f, ok := fields[k]
+			if !ok {
+				*problems = append(*problems, schemaProblem{p, unknownField(k, t, fields)})
+				continue
+			}
+			checkValue(problems, p, obj[k], f.Type, t.Name()+"."+k)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("expected an object, got %s", jsonKind(v))
+			return
+		}
+		for _, k := range sortedKeys(obj) {
+			checkValue(problems, path+"/"+escapePointer(k), obj[k], t.Elem(), "")
+		}
+	case reflect.Slice, reflect.Array:
+		arr, ok := v.([]any)
+		if !ok {
+			fail("expected an array, got %s", jsonKind(v))
+			return
+		}
+		for i, e := range arr {
+			checkValue(problems, path+"/"+strconv.Itoa(i), e, t.Elem(), "")
+		}
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			fail("expected a string, got %s", jsonKind(v))
+			return
+		}
+		values, ok := typeEnums[t]
+		if !ok {
+			values, ok = fieldEnums[field]
+		}
+		if ok && !contains(values, s) {
+			fail("invalid value %q, expected one of %s", s, strings.Join(values, ", "))
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			fail("expected a boolean, got %s", jsonKind(v))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("expected an integer, got %s", jsonKind(v))
+			return
+		}
+		i, err := strconv.ParseInt(string(n), 10, 64)
+		if err != nil || reflect.Zero(t).OverflowInt(i) {
+			fail("%s is not a valid %s", n, t.Kind())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("expected an integer, got %s", jsonKind(v))
+			return
+		}
+		u, err := strconv.ParseUint(string(n), 10, 64)
+		if err != nil || reflect.Zero(t).OverflowUint(u) {
+			fail("%s is not a valid %s", n, t.Kind())
+		}
+	case reflect.Float32, reflect.Float64:
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("expected a number, got %s", jsonKind(v))
+			return
+		}
+		if f, err := n.Float64(); err != nil || math.IsInf(f, 0) {
+			fail("%s is not a valid number", n)
+		}
+	}
+}
+
+// structFields maps the JSON names of t's fields to the fields.
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// unknownField describes the unknown field k of t, suggesting the field that
+// was most likely meant.
+func unknownField(k string, t reflect.Type, fields map[string]reflect.StructField) string {
+	best, bestDist := "", 3
+	for name := range fields {
+		if strings.EqualFold(k, name) {
+			return fmt.Sprintf("unknown field %q in %s, field names are case sensitive (did you mean %q?)", k, t.Name(), name)
+		}
+		if d := editDistance(strings.ToLower(k), strings.ToLower(name)); d < bestDist || (d == bestDist && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("unknown field %q in %s (did you mean %q?)", k, t.Name(), best)
+	}
+	return fmt.Sprintf("unknown field %q in %s", k, t.Name())
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+// escapePointer escapes a key for use in a JSON pointer, per RFC 6901.
+func escapePointer(k string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+}
+
+// sortedKeys returns the keys of m in order, so that output is stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// preflight checks doc as a document of type t, printing any problems.
+func preflight(doc []byte, t reflect.Type) error {
+	problems, err := checkDocument(doc, t)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Printf("%s\n", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("document does not match the %s schema: %d problem(s)", t.Name(), len(problems))
+	}
+	return nil
+}
+
+type validateCommand struct {
+	as *string
+}
+
+func (c *validateCommand) Name() string { return "validate" }
+func (c *validateCommand) Description() string {
+	return "Checks a document against the HCS schema."
+}
+func (c *validateCommand) ArgHelp() string { return "PATH" }
+func (c *validateCommand) SetupFlags(fs *flag.FlagSet) {
+	names := make([]string, 0, len(documentTypes))
+	for name := range documentTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.as = fs.String("as", "ComputeSystem", "Schema type of the document: "+strings.Join(names, ", ")+".")
+}
+
+func (c *validateCommand) Execute(state *state, fs *flag.FlagSet) error {
+	t, ok := documentTypes[*c.as]
+	if !ok {
+		return fmt.Errorf("unknown document type: %s", *c.as)
+	}
+	doc, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return preflight(doc, t)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+func TestCheckDocument(t *testing.T) {
+	computeSystem := reflect.TypeOf(hcsschema.ComputeSystem{})
+	for _, tc := range []struct {
+		name string
+		doc  string
+		t    reflect.Type
+		// want are the problems expected, as the path and part of the message.
+		want [][2]string
+		err  bool
+	}{
+		{
+			name: "valid",
+			doc:  `{"SchemaVersion":{"Major":2,"Minor":1},"Owner":"test","VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":1024}}}}`,
+			t:    computeSystem,
+		},
+		{
+			name: "invalid JSON",
+			doc:  `{"Owner":`,
+			t:    computeSystem,
+			err:  true,
+		},
+		{
+			name: "data after the document",
+			doc:  `{} {}`,
+			t:    computeSystem,
+			err:  true,
+		},
+		{
+			name: "field names are case sensitive",
+			doc:  `{"owner":"test"}`,
+			t:    computeSystem,
+			want: [][2]string{{"/owner", `did you mean "Owner"`}},
+		},
+		{
+			name: "misspelled field",
+			doc:  `{"VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMb":1024,"SizeInMBs":1}}}}`,
+			t:    computeSystem,
+			want: [][2]string{
+				{"/VirtualMachine/ComputeTopology/Memory/SizeInMBs", `unknown field "SizeInMBs" in Memory2 (did you mean "SizeInMB"?)`},
+				{"/VirtualMachine/ComputeTopology/Memory/SizeInMb", "case sensitive"},
+			},
+		},
+		{
+			name: "every problem is reported",
+			doc:  `{"Owner":1,"ShouldTerminateOnLastHandleClosed":"yes","VirtualMachine":{"ComputeTopology":{"Memory":{"SizeInMB":-1},"Processor":{"Count":1.5}}}}`,
+			t:    computeSystem,
+			want: [][2]string{
+				{"/Owner", "expected a string, got a number"},
+				{"/ShouldTerminateOnLastHandleClosed", "expected a boolean, got a string"},
+				{"/VirtualMachine/ComputeTopology/Memory/SizeInMB", "-1 is not a valid uint64"},
+				{"/VirtualMachine/ComputeTopology/Processor/Count", "1.5 is not a valid int32"},
+			},
+		},
+		{
+			name: "integer overflow",
+			doc:  `{"VirtualMachine":{"ComputeTopology":{"Processor":{"Count":4294967296}}}}`,
+			t:    computeSystem,
+			want: [][2]string{{"/VirtualMachine/ComputeTopology/Processor/Count", "not a valid int32"}},
+		},
+		{
+			name: "field enum",
+			doc:  `{"VirtualMachine":{"Devices":{"Scsi":{"0":{"Attachments":{"0":{"Type":"Vhd","Path":"a.vhdx"}}}}}}}`,
+			t:    computeSystem,
+			want: [][2]string{{"/VirtualMachine/Devices/Scsi/0/Attachments/0/Type", `invalid value "Vhd", expected one of VirtualDisk, Iso, PassThru`}},
+		},
+		{
+			name: "type enum",
+			doc:  `{"Type":"PowerOff"}`,
+			t:    reflect.TypeOf(hcsschema.ShutdownOptions{}),
+			want: [][2]string{{"/Type", `invalid value "PowerOff"`}},
+		},
+		{
+			name: "wrong container types",
+			doc:  `{"VirtualMachine":{"Devices":{"Scsi":[]}},"HostedSystem":"x"}`,
+			t:    computeSystem,
+			want: [][2]string{
+				{"/VirtualMachine/Devices/Scsi", "expected an object, got an array"},
+			},
+		},
+		{
+			name: "map keys are escaped",
+			doc:  `{"VirtualMachine":{"Devices":{"Scsi":{"a/b":{"Attachments":{"0":{"Type":1}}}}}}}`,
+			t:    computeSystem,
+			want: [][2]string{{"/VirtualMachine/Devices/Scsi/a~1b/Attachments/0/Type", "expected a string"}},
+		},
+		{
+			name: "null values are allowed",
+			doc:  `{"Owner":null,"VirtualMachine":null}`,
+			t:    computeSystem,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			problems, err := checkDocument([]byte(tc.doc), tc.t)
+			if (err != nil) != tc.err {
+				t.Fatalf("checkDocument error = %v, want error %v", err, tc.err)
+			}
+			if len(problems) != len(tc.want) {
+				t.Fatalf("checkDocument = %v, want %d problems", problems, len(tc.want))
+			}
+			for i, p := range problems {
+				if p.path != tc.want[i][0] || !strings.Contains(p.msg, tc.want[i][1]) {
+					t.Errorf("problem %d = %s, want %s: ...%s...", i, p, tc.want[i][0], tc.want[i][1])
+				}
+			}
+		})
+	}
+}
+
+func TestInterruptModerationEnum(t *testing.T) {
+	got := typeEnums[reflect.TypeOf(hcsschema.InterruptModerationName(""))]
+	want := []string{"Default", "Adaptive", "Off", "Low", "Medium", "High"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("interrupt moderation enum = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/kevpar/hcstool/internal/hcs"
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+// vpciBARs are the sizes of a device's 32-bit BARs, which are mapped below
+// 4GB, and 64-bit BARs, which are mapped above it, in bytes.
+type vpciBARs struct {
+	low, high []uint64
+}
+
+// mmioPlan is the MMIO gap configuration of a VM, as in Memory2.
+type mmioPlan struct {
+	LowMMIOGapInMB   uint64 `json:"LowMmioGapInMB,omitempty"`
+	HighMMIOBaseInMB uint64 `json:"HighMmioBaseInMB,omitempty"`
+	HighMMIOGapInMB  uint64 `json:"HighMmioGapInMB,omitempty"`
+}
+
+// planMMIO returns the MMIO gaps that a VM with memory configuration mem
+// needs to map the BARs of devices, keeping what it has where that is enough.
+// It also reports whether mem already has them. Gaps that mem leaves unset
+// are chosen by HCS and cannot be known, so they never suffice.
+func planMMIO(mem *hcsschema.Memory2, devices []vpciBARs) (mmioPlan, bool) {
+	var low, high []uint64
+	for _, d := range devices {
+		low = append(low, d.low...)
+		high = append(high, d.high...)
+	}
+	// BARs are powers of two, naturally aligned. Placing them largest first
+	// packs them without padding.
+	lowMB, _ := barSpan(low)
+	highMB, largestMB := barSpan(high)
+
+	plan := mmioPlan{
+		LowMMIOGapInMB:   max(mem.LowMMIOGapInMB, lowMB),
+		HighMMIOBaseInMB: mem.HighMMIOBaseInMB,
+		HighMMIOGapInMB:  max(mem.HighMMIOGapInMB, highMB),
+	}
+	ok := mem.LowMMIOGapInMB >= lowMB
+	if len(high) > 0 {
+		// The high gap sits above the memory that does not fit below the
+		// low gap.
+		minBase := uint64(4096)
+		if below := 4096 - min(plan.LowMMIOGapInMB, 4096); mem.SizeInMB > below {
+			minBase += mem.SizeInMB - below
+		}
+		plan.HighMMIOBaseInMB = max(minBase, mem.HighMMIOBaseInMB)
+		// The largest BAR must start on a multiple of its size.
+		need := roundUp(plan.HighMMIOBaseInMB, largestMB) - plan.HighMMIOBaseInMB + highMB
+		plan.HighMMIOGapInMB = max(mem.HighMMIOGapInMB, need)
+		ok = ok && mem.HighMMIOBaseInMB >= minBase && mem.HighMMIOGapInMB >= need
+	}
+	return plan, ok
+}
+
+// barSpan returns the MB needed to map bars, and the size of the largest one
+// in MB.
+func barSpan(bars []uint64) (uint64, uint64) {
+	var total, largest uint64
+	for _, b := range bars {
+		b = 1 << bits.Len64(b-1)
+		total += b
+		largest = max(largest, b)
+	}
+	const mb = 1 << 20
+	return (total + mb - 1) / mb, max((largest+mb-1)/mb, 1)
+}
+
+func roundUp(n, align uint64) uint64 {
+	return (n + align - 1) / align * align
+}
+
+// parseSize parses a size in bytes, with an optional K, M, G or T suffix for
+// binary multiples.
+func parseSize(s string) (uint64, error) {
+	t := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	shift := 0
+	if t != "" {
+		if i := strings.IndexByte("KMGT", t[len(t)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			t = t[:len(t)-1]
+		}
+	}
+	n, err := strconv.ParseUint(t, 10, 64)
+	if err != nil || n == 0 || n > (1<<64-1)>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+type vpciCommand struct {
+	cf      commonFlags
+	id      *string
+	vf      *uint
+	bars    listFlag
+	lowBars listFlag
+	force   *bool
+}
+
+func (c *vpciCommand) Name() string { return "vpci" }
+func (c *vpciCommand) Description() string {
+	return "Assigns, removes or lists virtual PCI devices of a VM."
+}
+func (c *vpciCommand) ArgHelp() string { return "add INSTANCEPATH|remove ID|list" }
+func (c *vpciCommand) SetupFlags(fs *flag.FlagSet) {
+	setupCommonFlags(&c.cf, fs)
+	c.id = fs.String("id", "", "VMBus ID of the device. Defaults to a new GUID.")
+	c.vf = fs.Uint("vf", 0, "Index of the virtual function to assign. 0 assigns the device itself.")
+	c.bars = nil
+	fs.Var(&c.bars, "bar", "Size of a 64-bit BAR of the device, such as 16G, for the MMIO gap check. Can be repeated.")
+	c.lowBars = nil
+	fs.Var(&c.lowBars, "lowbar", "Size of a 32-bit BAR of the device, such as 32M, for the MMIO gap check. Can be repeated.")
+	c.force = fs.Bool("force", false, "Assign the device even if the VM's MMIO gaps are too small for its BARs.")
+}
+
+func (c *vpciCommand) Execute(state *state, fs *flag.FlagSet) error {
+	sub, err := parseSubcommand(fs, "add", "remove", "list")
+	if err != nil {
+		return err
+	}
+	id, cs, err := getCS(state, &c.cf)
+	if err != nil {
+		return err
+	}
+	vm, err := vmConfig(id, cs)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "add":
+		return c.add(state, id, cs, vm, fs.Arg(0))
+	case "remove":
+		return c.remove(state, id, cs, vm, fs.Arg(0))
+	default:
+		printTracked(id)
+		return c.list(cs, vm)
+	}
+}
+
+func (c *vpciCommand) add(state *state, id string, cs *cs, vm *hcsschema.VirtualMachine, instancePath string) error {
+	if instancePath == "" {
+		return fmt.Errorf("a device instance path is required")
+	}
+	if *c.vf > 0xffff {
+		return fmt.Errorf("-vf must be below 65536")
+	}
+	device := strings.ToLower(*c.id)
+	if device == "" {
+		device = hcs.NewGUID()
+	} else if _, ok := vm.Devices.VirtualPci[device]; ok {
+		return fmt.Errorf("%s already has a vPCI device %s", id, device)
+	}
+	for d, dev := range vm.Devices.VirtualPci {
+		for _, f := range dev.Functions {
+			if strings.EqualFold(f.DeviceInstancePath, instancePath) && uint(f.VirtualFunction) == *c.vf {
+				return fmt.Errorf("%s is already assigned as device %s", instancePath, d)
+			}
+		}
+	}
+	var bars vpciBARs
+	for _, f := range []struct {
+		sizes listFlag
+		into  *[]uint64
+	}{{c.lowBars, &bars.low}, {c.bars, &bars.high}} {
+		for _, s := range f.sizes {
+			size, err := parseSize(s)
+			if err != nil {
+				return err
+			}
+			*f.into = append(*f.into, size)
+		}
+	}
+	if len(bars.low)+len(bars.high) > 0 {
+		if err := c.checkMMIO(cs, vm, bars); err != nil {
+			return err
+		}
+	}
+	dev := hcsschema.VirtualPciDevice{
+		Functions: []hcsschema.VirtualPciFunction{{
+			DeviceInstancePath: instancePath,
+			VirtualFunction:    uint16(*c.vf),
+		}},
+	}
+	if err := modifySystem(state, &c.cf, cs, "Add", vpciResourcePath(device), dev); err != nil {
+		return err
+	}
+	if vm.Devices.VirtualPci == nil {
+		vm.Devices.VirtualPci = make(map[string]hcsschema.VirtualPciDevice)
+	}
+	vm.Devices.VirtualPci[device] = dev
+	if len(bars.low)+len(bars.high) > 0 {
+		if cs.bars == nil {
+			cs.bars = make(map[string]vpciBARs)
+		}
+		cs.bars[device] = bars
+	}
+	fmt.Printf("assigned %s as device %s\n", instancePath, device)
+	return nil
+}
+
+// checkMMIO fails if the MMIO gaps of vm cannot hold bars along with the BARs
+// of the devices it already has, and says what they need to be. The gaps are
+// fixed when a VM is created, so the VM must be recreated to change them.
+func (c *vpciCommand) checkMMIO(cs *cs, vm *hcsschema.VirtualMachine, bars vpciBARs) error {
+	devices := []vpciBARs{bars}
+	for _, b := range cs.bars {
+		devices = append(devices, b)
+	}
+	mem := &hcsschema.Memory2{}
+	if vm.ComputeTopology != nil && vm.ComputeTopology.Memory != nil {
+		mem = vm.ComputeTopology.Memory
+	}
+	plan, ok := planMMIO(mem, devices)
+	if ok {
+		return nil
+	}
+	j, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("The VM's MMIO gaps are too small for the BARs of its vPCI devices. It needs these Memory settings:\n")
+	fmt.Printf("\t%s\n", j)
+	fmt.Printf("which new vm sets with -lowmmiogap %d -highmmiobase %d -highmmiogap %d.\n", plan.LowMMIOGapInMB, plan.HighMMIOBaseInMB, plan.HighMMIOGapInMB)
+	if *c.force {
+		return nil
+	}
+	return fmt.Errorf("MMIO gaps too small (use -force to assign anyway)")
+}
+
+func (c *vpciCommand) remove(state *state, id string, cs *cs, vm *hcsschema.VirtualMachine, device string) error {
+	if device == "" {
+		return fmt.Errorf("a device ID or instance path is required")
+	}
+	key := ""
+	for d, dev := range vm.Devices.VirtualPci {
+		if strings.EqualFold(d, device) {
+			key = d
+		}
+		for _, f := range dev.Functions {
+			if key == "" && strings.EqualFold(f.DeviceInstancePath, device) {
+				key = d
+			}
+		}
+	}
+	if key == "" {
+		return fmt.Errorf("%s has no vPCI device %s", id, device)
+	}
+	if err := modifySystem(state, &c.cf, cs, "Remove", vpciResourcePath(key), nil); err != nil {
+		return err
+	}
+	delete(vm.Devices.VirtualPci, key)
+	delete(cs.bars, key)
+	return nil
+}
+
+func (c *vpciCommand) list(cs *cs, vm *hcsschema.VirtualMachine) error {
+	type function struct {
+		device string
+		f      hcsschema.VirtualPciFunction
+	}
+	var functions []function
+	for _, d := range sortedKeys(vm.Devices.VirtualPci) {
+		for _, f := range vm.Devices.VirtualPci[d].Functions {
+			functions = append(functions, function{d, f})
+		}
+	}
+	if err := printTable(
+		[]colInfo{{"DEVICE", "%s"}, {"VF", "%d"}, {"BARS", "%s"}, {"INSTANCEPATH", "%s"}},
+		functions,
+		func(f function) []any {
+			var sizes []string
+			bars := cs.bars[f.device]
+			for _, b := range bars.low {
+				sizes = append(sizes, formatSize(b)+"(32)")
+			}
+			for _, b := range bars.high {
+				sizes = append(sizes, formatSize(b))
+			}
+			return []any{f.device, f.f.VirtualFunction, strings.Join(sizes, ","), f.f.DeviceInstancePath}
+		},
+	); err != nil {
+		return err
+	}
+	mem := &hcsschema.Memory2{}
+	if vm.ComputeTopology != nil && vm.ComputeTopology.Memory != nil {
+		mem = vm.ComputeTopology.Memory
+	}
+	devices := make([]vpciBARs, 0, len(cs.bars))
+	for _, d := range sortedKeys(cs.bars) {
+		devices = append(devices, cs.bars[d])
+	}
+	plan, ok := planMMIO(mem, devices)
+	fmt.Printf("MMIO gaps: low %dMB, high %dMB at %dMB", mem.LowMMIOGapInMB, mem.HighMMIOGapInMB, mem.HighMMIOBaseInMB)
+	if !ok {
+		fmt.Printf(" (BARs need low %dMB, high %dMB at %dMB)", plan.LowMMIOGapInMB, plan.HighMMIOGapInMB, plan.HighMMIOBaseInMB)
+	}
+	fmt.Printf("\n")
+	return nil
+}
+
+// formatSize formats a size in bytes with the largest binary suffix that
+// divides it.
+func formatSize(n uint64) string {
+	for _, u := range []struct {
+		suffix string
+		shift  int
+	}{{"T", 40}, {"G", 30}, {"M", 20}, {"K", 10}} {
+		if n >= 1<<u.shift && n%(1<<u.shift) == 0 {
+			return fmt.Sprintf("%d%s", n>>u.shift, u.suffix)
+		}
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+func vpciResourcePath(device string) string {
+	return "VirtualMachine/Devices/VirtualPci/" + device
+}
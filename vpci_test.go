@@ -0,0 +1,143 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kevpar/hcstool/internal/hcsschema"
+)
+
+func TestPlanMMIO(t *testing.T) {
+	const mb = 1 << 20
+	for _, tc := range []struct {
+		name    string
+		mem     hcsschema.Memory2
+		devices []vpciBARs
+		want    mmioPlan
+		ok      bool
+	}{
+		{
+			name: "no BARs",
+			mem:  hcsschema.Memory2{SizeInMB: 1024},
+			ok:   true,
+		},
+		{
+			name:    "low gap too small",
+			mem:     hcsschema.Memory2{SizeInMB: 1024, LowMMIOGapInMB: 8},
+			devices: []vpciBARs{{low: []uint64{16 * mb}}, {low: []uint64{mb + 1}}},
+			want:    mmioPlan{LowMMIOGapInMB: 18},
+		},
+		{
+			name:    "low gap large enough",
+			mem:     hcsschema.Memory2{SizeInMB: 1024, LowMMIOGapInMB: 32},
+			devices: []vpciBARs{{low: []uint64{16 * mb}}, {low: []uint64{mb + 1}}},
+			want:    mmioPlan{LowMMIOGapInMB: 32},
+			ok:      true,
+		},
+		{
+			name:    "high gap unset",
+			mem:     hcsschema.Memory2{SizeInMB: 1024},
+			devices: []vpciBARs{{high: []uint64{1024 * mb, 256 * mb}}},
+			want:    mmioPlan{HighMMIOBaseInMB: 4096, HighMMIOGapInMB: 1280},
+		},
+		{
+			name:    "high gap above memory, aligned to the largest BAR",
+			mem:     hcsschema.Memory2{SizeInMB: 8192, LowMMIOGapInMB: 256},
+			devices: []vpciBARs{{high: []uint64{1024 * mb, 256 * mb}}},
+			want:    mmioPlan{LowMMIOGapInMB: 256, HighMMIOBaseInMB: 8448, HighMMIOGapInMB: 2048},
+		},
+		{
+			name:    "high gap large enough",
+			mem:     hcsschema.Memory2{SizeInMB: 8192, LowMMIOGapInMB: 256, HighMMIOBaseInMB: 9216, HighMMIOGapInMB: 1280},
+			devices: []vpciBARs{{high: []uint64{1024 * mb, 256 * mb}}},
+			want:    mmioPlan{LowMMIOGapInMB: 256, HighMMIOBaseInMB: 9216, HighMMIOGapInMB: 1280},
+			ok:      true,
+		},
+		{
+			name:    "high gap overlaps memory",
+			mem:     hcsschema.Memory2{SizeInMB: 8192, LowMMIOGapInMB: 256, HighMMIOBaseInMB: 4096, HighMMIOGapInMB: 65536},
+			devices: []vpciBARs{{high: []uint64{1024 * mb}}},
+			want:    mmioPlan{LowMMIOGapInMB: 256, HighMMIOBaseInMB: 8448, HighMMIOGapInMB: 65536},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, ok := planMMIO(&tc.mem, tc.devices)
+			if plan != tc.want || ok != tc.ok {
+				t.Errorf("planMMIO = %+v, %v, want %+v, %v", plan, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestBARSpan(t *testing.T) {
+	const mb = 1 << 20
+	for _, tc := range []struct {
+		bars           []uint64
+		total, largest uint64
+	}{
+		{nil, 0, 1},
+		{[]uint64{1}, 1, 1},
+		{[]uint64{4096, 4096}, 1, 1},
+		{[]uint64{3 * mb}, 4, 4},
+		{[]uint64{mb, mb}, 2, 1},
+		{[]uint64{1024 * mb, mb + 1}, 1026, 1024},
+	} {
+		total, largest := barSpan(tc.bars)
+		if total != tc.total || largest != tc.largest {
+			t.Errorf("barSpan(%v) = %d, %d, want %d, %d", tc.bars, total, largest, tc.total, tc.largest)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want uint64
+		err  bool
+	}{
+		{in: "4096", want: 4096},
+		{in: "4K", want: 4 << 10},
+		{in: "4kb", want: 4 << 10},
+		{in: "2MiB", want: 2 << 20},
+		{in: "1G", want: 1 << 30},
+		{in: "16777215T", want: 16777215 << 40},
+		{in: "16777216T", err: true},
+		{in: "0", err: true},
+		{in: "", err: true},
+		{in: "KB", err: true},
+		{in: "-1", err: true},
+		{in: "1P", err: true},
+		{in: "1.5G", err: true},
+	} {
+		n, err := parseSize(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("parseSize(%q) error = %v, want error %v", tc.in, err, tc.err)
+			continue
+		}
+		if n != tc.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tc.in, n, tc.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   uint64
+		want string
+	}{
+		{0, "0"},
+		{1536, "1536"},
+		{1 << 10, "1K"},
+		{1<<20 + 1<<10, "1025K"},
+		{3 << 30, "3G"},
+		{1 << 40, "1T"},
+	} {
+		if got := formatSize(tc.in); got != tc.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tc.in, got, tc.want)
+			continue
+		}
+		// The output is accepted by parseSize.
+		if n, err := parseSize(tc.want); tc.in != 0 && (err != nil || n != tc.in) {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tc.want, n, err, tc.in)
+		}
+	}
+}